@@ -5,20 +5,27 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"redis_cluster_proxy/pkg/ip_map"
-	"redis_cluster_proxy/pkg/port_pool"
-	"redis_cluster_proxy/pkg/proxy"
+	"redis_cluster_proxy/pkg/config"
 	"syscall"
+	"time"
 )
 
 const (
+	ConfigFileFlagName               = "config"
 	ListenAddrFlagName               = "listenAddr"
+	RouteAddrFlagName                = "routeAddr"
 	ClusterAddrFlagName              = "clusterAddr"
 	PublicHostFlagName               = "publicHost"
 	NumberOfBuffersFlagName          = "numberOfBuffers"
 	MaxConcurrentConnectionsFlagName = "maxConcurrentConnections"
 	ReadBufferByteSizeFlagName       = "readBufferByteSize"
 	EnableDebuggingFlagName          = "debug"
+	ReadOnlyFlagName                 = "readOnly"
+	ClientIdleTimeoutFlagName        = "clientIdleTimeout"
+	BackendReadTimeoutFlagName       = "backendReadTimeout"
+	WriteTimeoutFlagName             = "writeTimeout"
+	TCPKeepAliveFlagName             = "tcpKeepAlive"
+	TopologyRefreshFlagName          = "topologyRefreshInterval"
 )
 
 func buildArguments() *cli.App {
@@ -27,25 +34,37 @@ func buildArguments() *cli.App {
 		{
 			Name:        "server",
 			Usage:       "proxy local redis cluster requests to a private cluster",
-			UsageText:   "server -listenAddr LOCAL_ADDR:LOCAL_PORT -clusterAddr CLUSTER_ADDR:CLUSTER_PORT",
-			Description: "launches a proxy server that translates local ip addresses to the cluster-private IP addresses for a redis cluster",
+			UsageText:   "server [-config CONFIG_FILE] -listenAddr LOCAL_ADDR:LOCAL_PORT -clusterAddr CLUSTER_ADDR:CLUSTER_PORT -publicHost PUBLIC_HOST",
+			Description: "launches a proxy server that translates local ip addresses to the cluster-private IP addresses for a redis cluster. Flags override environment variables, which override the config file",
 			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:     ConfigFileFlagName,
+					EnvVar:   "CONFIG_FILE",
+					Required: false,
+					Usage:    "path to a YAML config file. Flags and environment variables take precedence over the values in the file",
+				},
 				cli.StringFlag{
 					Name:     ListenAddrFlagName,
 					EnvVar:   "LISTEN_ADDR",
-					Required: true,
+					Required: false,
 					Usage:    "HOST_OR_IP:PORT this is the first hostname/ipv4 and port to start listening for incoming connections from redis clients",
 				},
+				cli.StringFlag{
+					Name:     RouteAddrFlagName,
+					EnvVar:   "ROUTE_ADDR",
+					Required: false,
+					Usage:    "HOST_OR_IP:PORT to also accept clients on a single port, where the proxy sends each command to the node that owns its keys. For clients that are not cluster aware",
+				},
 				cli.StringFlag{
 					Name:     ClusterAddrFlagName,
 					EnvVar:   "CLUSTER_ADDR",
-					Required: true,
+					Required: false,
 					Usage:    "HOST_OR_IP:PORT this is how the proxy knows how to contact the cluster. This should be the address of any node in the cluster, the other nodes will be discovered by the proxy",
 				},
 				cli.StringFlag{
 					Name:     PublicHostFlagName,
 					EnvVar:   "PUBLIC_HOST",
-					Required: true,
+					Required: false,
 					Usage:    "HOST_OR_IP of the public address of the proxy. Clients connecting to the proxy will use this address to route traffic to the proxy",
 				},
 				cli.IntFlag{
@@ -60,7 +79,7 @@ func buildArguments() *cli.App {
 					EnvVar:   "MAX_CONNECTIONS",
 					Required: false,
 					Value:    100,
-					Usage:    "Not activate at this time",
+					Usage:    "[100] the most connections the routing endpoint opens to each cluster node, shared by its clients. Blocking commands get as many again",
 				},
 				cli.IntFlag{
 					Name:     ReadBufferByteSizeFlagName,
@@ -75,40 +94,104 @@ func buildArguments() *cli.App {
 					EnvVar:   "DEBUG",
 					Required: false,
 				},
+				cli.BoolFlag{
+					Name:     ReadOnlyFlagName,
+					Usage:    "specify this flag to reject every command that may change data with -READONLY",
+					EnvVar:   "READ_ONLY",
+					Required: false,
+				},
+				cli.DurationFlag{
+					Name:     ClientIdleTimeoutFlagName,
+					EnvVar:   "CLIENT_IDLE_TIMEOUT",
+					Required: false,
+					Usage:    "[0s] disconnect clients that have not sent a command for this long. 0 waits forever",
+				},
+				cli.DurationFlag{
+					Name:     BackendReadTimeoutFlagName,
+					EnvVar:   "BACKEND_READ_TIMEOUT",
+					Required: false,
+					Usage:    "[0s] disconnect a client if the cluster node does not reply to a forwarded command within this long. 0 waits forever",
+				},
+				cli.DurationFlag{
+					Name:     WriteTimeoutFlagName,
+					EnvVar:   "WRITE_TIMEOUT",
+					Required: false,
+					Usage:    "[0s] disconnect if a write to either the client or the cluster node takes longer than this. 0 waits forever",
+				},
+				cli.DurationFlag{
+					Name:     TCPKeepAliveFlagName,
+					EnvVar:   "TCP_KEEPALIVE",
+					Required: false,
+					Value:    30 * time.Second,
+					Usage:    "[30s] the TCP keep-alive period for client and cluster connections, used to detect half-open sessions. 0 uses the system default",
+				},
+				cli.DurationFlag{
+					Name:     TopologyRefreshFlagName,
+					EnvVar:   "TOPOLOGY_REFRESH_INTERVAL",
+					Required: false,
+					Value:    30 * time.Second,
+					Usage:    "[30s] how often to ask the cluster for its nodes so that nodes that move to a new address keep their local port. 0 disables it",
+				},
 			},
 			Action: func(c *cli.Context) (err error) {
-				var redisProxy *proxy.Redis
-				redisProxy, err = newProxy(
-					c.String(ListenAddrFlagName),
-					c.String(ClusterAddrFlagName),
-					c.String(PublicHostFlagName),
-					c.Int(NumberOfBuffersFlagName),
-					c.Int(MaxConcurrentConnectionsFlagName),
-					c.Int(ReadBufferByteSizeFlagName),
-				)
-
+				var cfg config.Config
+				cfg, err = configFromContext(c)
 				if err != nil {
-					log.Print(err)
+					return err
 				}
 
-				redisProxy.SetDebug(c.Bool(EnableDebuggingFlagName))
+				var proxies []namedProxy
+				proxies, err = newProxies(cfg)
+				if err != nil {
+					return err
+				}
 
 				// Discovers the cluster ips and ports
-				err = redisProxy.DiscoverAndListen()
-				if err != nil {
-					log.Fatal(err)
+				for _, p := range proxies {
+					err = p.redis.DiscoverAndListen()
+					if err != nil {
+						log.Fatalf("cluster '%s': %s", p.name, err)
+					}
+				}
+
+				// follow nodes that move or join the cluster
+				for _, p := range proxies {
+					go p.redis.WatchTopology(cfg.TopologyRefreshInterval)
 				}
 
 				// print the status to the stdout so that people can see what's going on
-				err = redisProxy.PrintConnectionStatuses(os.Stdout)
+				err = printStatuses(os.Stdout, proxies)
 				if err != nil {
 					log.Fatal(err)
 				}
 
+				// SIGUSR1 prints the mappings and the metrics without stopping the proxy
+				statusChan := make(chan os.Signal, 1)
+				signal.Notify(statusChan, syscall.SIGUSR1)
+				go func() {
+					for range statusChan {
+						_ = printStatuses(os.Stdout, proxies)
+						_ = printMetrics(os.Stdout, proxies)
+					}
+				}()
+
+				// SIGHUP re-reads the config file and applies what can be changed without restarting
+				reloadChan := make(chan os.Signal, 1)
+				signal.Notify(reloadChan, syscall.SIGHUP)
+				go func() {
+					for range reloadChan {
+						var reloadErr error
+						cfg, reloadErr = reloadConfig(c, proxies, cfg)
+						if reloadErr != nil {
+							log.Println("config not reloaded: " + reloadErr.Error())
+						}
+					}
+				}()
+
 				exitChan := make(chan os.Signal, 1)
 				signal.Notify(exitChan, syscall.SIGINT, syscall.SIGKILL, syscall.SIGTERM)
 				<-exitChan
-				err = redisProxy.Close()
+				err = closeProxies(proxies)
 				if err != nil {
 					log.Fatal(err)
 				}
@@ -119,18 +202,57 @@ func buildArguments() *cli.App {
 	return app
 }
 
-func newProxy(listenAddr, clusterAddr, publicHostname string, numberOfBuffers int, maxConcurrentConnections int, readBufferByteSize int) (redisProxy *proxy.Redis, err error) {
-
-	listenHostWithPort, err := ip_map.NewHostWithPortFromString(listenAddr)
-	if err != nil {
-		return
+// configFromContext loads the config file, if one was given, then overlays any flags or environment variables that were set
+func configFromContext(c *cli.Context) (cfg config.Config, err error) {
+	cfg = config.Defaults()
+	if c.IsSet(ConfigFileFlagName) {
+		cfg, err = config.Load(c.String(ConfigFileFlagName))
+		if err != nil {
+			return
+		}
 	}
-	clusterHostWithPort, err := ip_map.NewHostWithPortFromString(clusterAddr)
-	if err != nil {
-		return
+	if c.IsSet(ListenAddrFlagName) {
+		cfg.ListenAddr = c.String(ListenAddrFlagName)
 	}
-
-	portKeeper := port_pool.NewCounter(listenHostWithPort.Port)
-
-	return proxy.NewRedis(listenHostWithPort, clusterHostWithPort, publicHostname, portKeeper, numberOfBuffers, maxConcurrentConnections, readBufferByteSize), nil
+	if c.IsSet(RouteAddrFlagName) {
+		cfg.RouteAddr = c.String(RouteAddrFlagName)
+	}
+	if c.IsSet(ClusterAddrFlagName) {
+		cfg.ClusterAddr = c.String(ClusterAddrFlagName)
+	}
+	if c.IsSet(PublicHostFlagName) {
+		cfg.PublicHost = c.String(PublicHostFlagName)
+	}
+	if c.IsSet(NumberOfBuffersFlagName) {
+		cfg.NumberOfBuffers = c.Int(NumberOfBuffersFlagName)
+	}
+	if c.IsSet(MaxConcurrentConnectionsFlagName) {
+		cfg.MaxConcurrentConnections = c.Int(MaxConcurrentConnectionsFlagName)
+	}
+	if c.IsSet(ReadBufferByteSizeFlagName) {
+		cfg.ReadBufferByteSize = c.Int(ReadBufferByteSizeFlagName)
+	}
+	if c.IsSet(EnableDebuggingFlagName) {
+		cfg.Debug = c.Bool(EnableDebuggingFlagName)
+	}
+	if c.IsSet(ReadOnlyFlagName) {
+		cfg.ReadOnly = c.Bool(ReadOnlyFlagName)
+	}
+	if c.IsSet(ClientIdleTimeoutFlagName) {
+		cfg.Timeouts.ClientIdle = c.Duration(ClientIdleTimeoutFlagName)
+	}
+	if c.IsSet(BackendReadTimeoutFlagName) {
+		cfg.Timeouts.BackendRead = c.Duration(BackendReadTimeoutFlagName)
+	}
+	if c.IsSet(WriteTimeoutFlagName) {
+		cfg.Timeouts.Write = c.Duration(WriteTimeoutFlagName)
+	}
+	if c.IsSet(TCPKeepAliveFlagName) {
+		cfg.Timeouts.TCPKeepAlive = c.Duration(TCPKeepAliveFlagName)
+	}
+	if c.IsSet(TopologyRefreshFlagName) {
+		cfg.TopologyRefreshInterval = c.Duration(TopologyRefreshFlagName)
+	}
+	err = cfg.Validate()
+	return
 }
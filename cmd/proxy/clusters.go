@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"redis_cluster_proxy/pkg/config"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/port_pool"
+	"redis_cluster_proxy/pkg/proxy"
+)
+
+// namedProxy is one of the clusters hosted by this process
+type namedProxy struct {
+	name        string
+	clusterAddr string
+	redis       *proxy.Redis
+}
+
+// newProxies creates one proxy per cluster in the config, each with its own ports and address map
+func newProxies(cfg config.Config) (proxies []namedProxy, err error) {
+	clusters := cfg.ClusterList()
+	proxies = make([]namedProxy, 0, len(clusters))
+	for _, cluster := range clusters {
+		var redisProxy *proxy.Redis
+		redisProxy, err = newProxy(cfg, cluster)
+		if err != nil {
+			return nil, fmt.Errorf("cluster '%s': %w", cluster.Name, err)
+		}
+		proxies = append(proxies, namedProxy{
+			name:        cluster.Name,
+			clusterAddr: cluster.ClusterAddr,
+			redis:       redisProxy,
+		})
+	}
+	err = applyLiveSettings(proxies, cfg)
+	return
+}
+
+func newProxy(cfg config.Config, cluster config.Cluster) (redisProxy *proxy.Redis, err error) {
+	listenHostWithPort, err := ip_map.NewHostWithPortFromString(cluster.ListenAddr)
+	if err != nil {
+		return
+	}
+	clusterHostWithPort, err := ip_map.NewHostWithPortFromString(cluster.ClusterAddr)
+	if err != nil {
+		return
+	}
+
+	portKeeper := port_pool.NewRangeCounter(listenHostWithPort.Host, listenHostWithPort.Port, 65535)
+	if cluster.Ports.RangeMin != 0 {
+		portKeeper = port_pool.NewRangeCounter(listenHostWithPort.Host, cluster.Ports.RangeMin, cluster.Ports.RangeMax)
+	}
+	staticPorts := port_pool.NewStatic()
+	for _, static := range cluster.Ports.Static {
+		if len(static.NodeId) != 0 {
+			staticPorts.PinNodeId(static.NodeId, static.Port)
+		} else {
+			var staticAddr ip_map.HostWithPort
+			staticAddr, err = ip_map.NewHostWithPortFromString(static.Addr)
+			if err != nil {
+				return
+			}
+			staticPorts.PinAddr(staticAddr.String(), static.Port)
+		}
+	}
+
+	if cluster.Sentinel.IsEnabled() {
+		redisProxy = proxy.NewSentinel(listenHostWithPort, clusterHostWithPort, cluster.Sentinel.Masters, cluster.PublicHost, portKeeper, cfg.NumberOfBuffers, cfg.MaxConcurrentConnections, cfg.ReadBufferByteSize)
+	} else {
+		redisProxy = proxy.NewRedis(listenHostWithPort, clusterHostWithPort, cluster.PublicHost, portKeeper, cfg.NumberOfBuffers, cfg.MaxConcurrentConnections, cfg.ReadBufferByteSize)
+	}
+	redisProxy.SetStaticPorts(staticPorts)
+	if len(cluster.RouteAddr) != 0 {
+		var routeHostWithPort ip_map.HostWithPort
+		routeHostWithPort, err = ip_map.NewHostWithPortFromString(cluster.RouteAddr)
+		if err != nil {
+			return
+		}
+		redisProxy.SetRouteAddr(routeHostWithPort)
+	}
+	for _, horizon := range cluster.Horizons {
+		redisProxy.AddHorizon(proxy.Horizon{BindHost: horizon.BindHost, PublicHost: horizon.PublicHost})
+	}
+	return
+}
+
+// printStatuses writes the port mappings of every cluster, each under a heading with the cluster's name
+func printStatuses(writer io.Writer, proxies []namedProxy) (err error) {
+	for _, p := range proxies {
+		_, err = fmt.Fprintf(writer, "Cluster: %s (%s)\n", p.name, p.clusterAddr)
+		if err != nil {
+			return
+		}
+		err = p.redis.PrintConnectionStatuses(writer)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// printMetrics writes the counters of every cluster, each under a heading with the cluster's name
+func printMetrics(writer io.Writer, proxies []namedProxy) (err error) {
+	for _, p := range proxies {
+		_, err = fmt.Fprintf(writer, "Metrics for cluster: %s\n", p.name)
+		if err != nil {
+			return
+		}
+		err = p.redis.PrintMetrics(writer)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// closeProxies closes every cluster's listeners, returning the first error
+func closeProxies(proxies []namedProxy) (err error) {
+	for _, p := range proxies {
+		closeErr := p.redis.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"log"
+	"redis_cluster_proxy/pkg/config"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/proxy"
+	"reflect"
+)
+
+// applyLiveSettings pushes every setting that can change while the proxy is running to every cluster. Files, such as TLS
+// certificates, are loaded before anything is applied so that a bad file leaves the proxy as it was
+func applyLiveSettings(proxies []namedProxy, cfg config.Config) (err error) {
+	listenerTLS, err := cfg.TLS.Listener.ServerConfig()
+	if err != nil {
+		return
+	}
+	clusterTLS, err := cfg.TLS.Cluster.ClientConfig()
+	if err != nil {
+		return
+	}
+	commandRules, err := proxy.NewCommandRules(cfg.Commands.Allow, cfg.Commands.Deny)
+	if err != nil {
+		return
+	}
+	accessList, err := proxy.NewAccessList(cfg.Access.Allow, cfg.Access.Deny)
+	if err != nil {
+		return
+	}
+	users, err := newUsers(cfg.Users)
+	if err != nil {
+		return
+	}
+
+	clusters := make(map[string]config.Cluster)
+	scriptNodes := make(map[string]ip_map.HostWithPort)
+	for _, cluster := range cfg.ClusterList() {
+		clusters[cluster.Name] = cluster
+		if len(cluster.ScriptNode) != 0 {
+			if scriptNodes[cluster.Name], err = ip_map.NewHostWithPortFromString(cluster.ScriptNode); err != nil {
+				return
+			}
+		}
+	}
+	for _, p := range proxies {
+		cluster := clusters[p.name]
+		redisProxy := p.redis
+		redisProxy.SetDebug(cfg.Debug)
+		redisProxy.SetReadOnly(cfg.ReadOnly)
+		redisProxy.SetPublicHostname(cluster.PublicHost)
+		for _, horizon := range cluster.Horizons {
+			redisProxy.SetHorizonPublicHost(horizon.BindHost, horizon.PublicHost)
+		}
+		redisProxy.SetTimeouts(proxy.Timeouts{
+			ClientIdle:  cfg.Timeouts.ClientIdle,
+			BackendRead: cfg.Timeouts.BackendRead,
+			Write:       cfg.Timeouts.Write,
+			KeepAlive:   cfg.Timeouts.TCPKeepAlive,
+		})
+		redisProxy.SetCredentials(proxy.Credentials{
+			Username: cluster.Credentials.Username,
+			Password: cluster.Credentials.Password,
+		})
+		redisProxy.SetSentinelCredentials(proxy.Credentials{
+			Username: cluster.Sentinel.Credentials.Username,
+			Password: cluster.Sentinel.Credentials.Password,
+		})
+		redisProxy.SetCommandRules(commandRules)
+		redisProxy.SetAccessList(accessList)
+		redisProxy.SetUsers(users)
+		redisProxy.SetKeyPrefix(cluster.KeyPrefix)
+		redisProxy.SetScriptNode(scriptNodes[p.name])
+		redisProxy.SetRateLimits(proxy.RateLimits{
+			PerClient: proxy.Limit{
+				CommandsPerSecond: cfg.RateLimits.PerClient.CommandsPerSecond,
+				BytesPerSecond:    cfg.RateLimits.PerClient.BytesPerSecond,
+			},
+			PerUser: proxy.Limit{
+				CommandsPerSecond: cfg.RateLimits.PerUser.CommandsPerSecond,
+				BytesPerSecond:    cfg.RateLimits.PerUser.BytesPerSecond,
+			},
+			Reject: cfg.RateLimits.OverLimit == config.OverLimitReject,
+		})
+		redisProxy.SetListenerTLS(listenerTLS)
+		redisProxy.SetClusterTLS(clusterTLS)
+	}
+	return nil
+}
+
+// newUsers converts the configured users into the proxy's user table. No users leaves AUTH to the cluster
+func newUsers(configured []config.User) (*proxy.Users, error) {
+	if len(configured) == 0 {
+		return nil, nil
+	}
+	users := make([]proxy.User, 0, len(configured))
+	for _, user := range configured {
+		commandRules, err := proxy.NewCommandRules(user.Commands.Allow, user.Commands.Deny)
+		if err != nil {
+			return nil, fmt.Errorf("user '%s': %s", user.Name, err)
+		}
+		users = append(users, proxy.User{
+			Name:           user.Name,
+			PasswordSHA256: user.PasswordSHA256,
+			Backend: proxy.Credentials{
+				Username: user.Backend.Username,
+				Password: user.Backend.Password,
+			},
+			Commands:    commandRules,
+			KeyPatterns: user.Keys,
+			KeyPrefix:   user.KeyPrefix,
+		})
+	}
+	return proxy.NewUsers(users)
+}
+
+// reloadConfig re-reads the config file, flags and environment, and applies the settings that can be changed live.
+// Existing client connections are kept. Settings that need the listeners to be re-bound are logged and left as they were.
+// Returns the config that is now in effect
+func reloadConfig(c *cli.Context, proxies []namedProxy, current config.Config) (config.Config, error) {
+	next, err := configFromContext(c)
+	if err != nil {
+		return current, err
+	}
+	applied := keepRestartRequired(current, next)
+	err = applyLiveSettings(proxies, applied)
+	if err != nil {
+		return current, err
+	}
+
+	for _, change := range restartRequiredChanges(current, next) {
+		log.Println("config reload: " + change + " requires a restart to take effect, ignoring")
+	}
+	log.Println("config reloaded")
+	return applied, nil
+}
+
+// keepRestartRequired returns next with the settings that cannot be applied live put back to their current values,
+// so that the next reload reports them again
+func keepRestartRequired(current, next config.Config) config.Config {
+	next.NumberOfBuffers = current.NumberOfBuffers
+	next.MaxConcurrentConnections = current.MaxConcurrentConnections
+	next.ReadBufferByteSize = current.ReadBufferByteSize
+	next.TopologyRefreshInterval = current.TopologyRefreshInterval
+	if !sameClusterNames(current, next) {
+		next.Clusters = current.Clusters
+		next.ListenAddr = current.ListenAddr
+		next.RouteAddr = current.RouteAddr
+		next.ClusterAddr = current.ClusterAddr
+		next.PublicHost = current.PublicHost
+		next.Ports = current.Ports
+		next.Horizons = current.Horizons
+		next.Sentinel.Masters = current.Sentinel.Masters
+		return next
+	}
+
+	next.ListenAddr = current.ListenAddr
+	next.RouteAddr = current.RouteAddr
+	next.ClusterAddr = current.ClusterAddr
+	next.Ports = current.Ports
+	next.Sentinel.Masters = current.Sentinel.Masters
+	if !sameHorizonBindHosts(current.Horizons, next.Horizons) {
+		next.Horizons = current.Horizons
+	}
+	clusters := make([]config.Cluster, len(next.Clusters))
+	for i, cluster := range next.Clusters {
+		cluster.ListenAddr = current.Clusters[i].ListenAddr
+		cluster.RouteAddr = current.Clusters[i].RouteAddr
+		cluster.ClusterAddr = current.Clusters[i].ClusterAddr
+		cluster.Ports = current.Clusters[i].Ports
+		cluster.Sentinel.Masters = current.Clusters[i].Sentinel.Masters
+		if !sameHorizonBindHosts(current.Clusters[i].Horizons, cluster.Horizons) {
+			cluster.Horizons = current.Clusters[i].Horizons
+		}
+		clusters[i] = cluster
+	}
+	next.Clusters = clusters
+	return next
+}
+
+// restartRequiredChanges lists the settings that differ between current and next, but cannot be applied without re-binding
+func restartRequiredChanges(current, next config.Config) (changes []string) {
+	changes = make([]string, 0, 5)
+	if current.NumberOfBuffers != next.NumberOfBuffers {
+		changes = append(changes, fmt.Sprintf("numberOfBuffers change from %d to %d", current.NumberOfBuffers, next.NumberOfBuffers))
+	}
+	if current.MaxConcurrentConnections != next.MaxConcurrentConnections {
+		changes = append(changes, fmt.Sprintf("maxConcurrentConnections change from %d to %d", current.MaxConcurrentConnections, next.MaxConcurrentConnections))
+	}
+	if current.ReadBufferByteSize != next.ReadBufferByteSize {
+		changes = append(changes, fmt.Sprintf("readBufferByteSize change from %d to %d", current.ReadBufferByteSize, next.ReadBufferByteSize))
+	}
+	if current.TopologyRefreshInterval != next.TopologyRefreshInterval {
+		changes = append(changes, fmt.Sprintf("topologyRefreshInterval change from %s to %s", current.TopologyRefreshInterval, next.TopologyRefreshInterval))
+	}
+	if !sameClusterNames(current, next) {
+		return append(changes, "adding, removing or renaming clusters")
+	}
+
+	nextClusters := next.ClusterList()
+	for i, cluster := range current.ClusterList() {
+		prefix := ""
+		if len(current.Clusters) != 0 {
+			prefix = "cluster '" + cluster.Name + "' "
+		}
+		changes = append(changes, clusterRestartRequiredChanges(prefix, cluster, nextClusters[i])...)
+	}
+	return
+}
+
+func clusterRestartRequiredChanges(prefix string, current, next config.Cluster) (changes []string) {
+	if current.ListenAddr != next.ListenAddr {
+		changes = append(changes, fmt.Sprintf("%slistenAddr change from '%s' to '%s'", prefix, current.ListenAddr, next.ListenAddr))
+	}
+	if current.RouteAddr != next.RouteAddr {
+		changes = append(changes, fmt.Sprintf("%srouteAddr change from '%s' to '%s'", prefix, current.RouteAddr, next.RouteAddr))
+	}
+	if current.ClusterAddr != next.ClusterAddr {
+		changes = append(changes, fmt.Sprintf("%sclusterAddr change from '%s' to '%s'", prefix, current.ClusterAddr, next.ClusterAddr))
+	}
+	if !sameHorizonBindHosts(current.Horizons, next.Horizons) {
+		changes = append(changes, prefix+"horizons bindHost change")
+	}
+	if !reflect.DeepEqual(current.Ports, next.Ports) {
+		changes = append(changes, prefix+"ports change")
+	}
+	if !reflect.DeepEqual(current.Sentinel.Masters, next.Sentinel.Masters) {
+		changes = append(changes, prefix+"sentinel.masters change")
+	}
+	return
+}
+
+// sameClusterNames is true if both configs host the same clusters in the same order
+func sameClusterNames(current, next config.Config) bool {
+	if len(current.Clusters) != len(next.Clusters) {
+		return false
+	}
+	currentClusters, nextClusters := current.ClusterList(), next.ClusterList()
+	if len(currentClusters) != len(nextClusters) {
+		return false
+	}
+	for i := range currentClusters {
+		if currentClusters[i].Name != nextClusters[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+// sameHorizonBindHosts is true if only the public hosts of the horizons changed, which can be applied live
+func sameHorizonBindHosts(current, next []config.Horizon) bool {
+	if len(current) != len(next) {
+		return false
+	}
+	for i := range current {
+		if current[i].BindHost != next[i].BindHost {
+			return false
+		}
+	}
+	return true
+}
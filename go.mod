@@ -6,4 +6,5 @@ require (
 	github.com/stretchr/testify v1.4.0
 	github.com/urfave/cli v1.22.1
 	github.com/wojnosystems/retry v1.0.3
+	gopkg.in/yaml.v2 v2.2.2
 )
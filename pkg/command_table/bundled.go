@@ -0,0 +1,297 @@
+package command_table
+
+// bundled describes the commands of Redis 7, as reported by COMMAND. Container commands, such as CONFIG, are listed per
+// subcommand as "config|get" where their subcommands differ in keys or flags
+var bundled = []Spec{
+	// Strings
+	{Name: "get", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "set", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "setnx", Arity: 3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "setex", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "psetex", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "getset", Arity: 3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "getdel", Arity: 2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "getex", Arity: -2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "append", Arity: 3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "strlen", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "incr", Arity: 2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "decr", Arity: 2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "incrby", Arity: 3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "decrby", Arity: 3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "incrbyfloat", Arity: 3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "mget", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "mset", Arity: -3, Flags: Write, FirstKey: 1, LastKey: -1, Step: 2},
+	{Name: "msetnx", Arity: -3, Flags: Write, FirstKey: 1, LastKey: -1, Step: 2},
+	{Name: "setrange", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "getrange", Arity: 4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "substr", Arity: 4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "lcs", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 2, Step: 1},
+	{Name: "setbit", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "getbit", Arity: 3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "bitcount", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "bitpos", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "bitop", Arity: -4, Flags: Write, FirstKey: 2, LastKey: -1, Step: 1},
+	{Name: "bitfield", Arity: -2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "bitfield_ro", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	// Keys
+	{Name: "del", Arity: -2, Flags: Write, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "unlink", Arity: -2, Flags: Write, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "exists", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "touch", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "expire", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "pexpire", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "expireat", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "pexpireat", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "expiretime", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "pexpiretime", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "ttl", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "pttl", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "persist", Arity: 2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "type", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "rename", Arity: 3, Flags: Write, FirstKey: 1, LastKey: 2, Step: 1},
+	{Name: "renamenx", Arity: 3, Flags: Write, FirstKey: 1, LastKey: 2, Step: 1},
+	{Name: "copy", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 2, Step: 1},
+	{Name: "move", Arity: 3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "dump", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "restore", Arity: -4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "sort", Arity: -2, Flags: Write | MovableKeys, FirstKey: 1, LastKey: 1, Step: 1, keys: sortKeys},
+	{Name: "sort_ro", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "migrate", Arity: -6, Flags: Write | MovableKeys, keys: migrateKeys},
+	{Name: "object|encoding", Arity: 3, Flags: Readonly, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "object|freq", Arity: 3, Flags: Readonly, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "object|idletime", Arity: 3, Flags: Readonly, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "object|refcount", Arity: 3, Flags: Readonly, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "memory|usage", Arity: -3, Flags: Readonly, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "randomkey", Arity: 1, Flags: Readonly},
+	{Name: "keys", Arity: 2, Flags: Readonly},
+	{Name: "scan", Arity: -2, Flags: Readonly},
+	// Hashes
+	{Name: "hset", Arity: -4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hsetnx", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hmset", Arity: -4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hget", Arity: 3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hmget", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hgetall", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hdel", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hlen", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hstrlen", Arity: 3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hexists", Arity: 3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hkeys", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hvals", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hincrby", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hincrbyfloat", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hrandfield", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "hscan", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	// Lists
+	{Name: "lpush", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "rpush", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "lpushx", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "rpushx", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "lpop", Arity: -2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "rpop", Arity: -2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "llen", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "lindex", Arity: 3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "lset", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "lrange", Arity: 4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "ltrim", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "lrem", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "linsert", Arity: 5, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "lpos", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "rpoplpush", Arity: 3, Flags: Write, FirstKey: 1, LastKey: 2, Step: 1},
+	{Name: "lmove", Arity: 5, Flags: Write, FirstKey: 1, LastKey: 2, Step: 1},
+	{Name: "lmpop", Arity: -4, Flags: Write | MovableKeys, keys: numKeysAt(1)},
+	{Name: "blpop", Arity: -3, Flags: Write | Blocking, FirstKey: 1, LastKey: -2, Step: 1, timeout: secondsAt(-1)},
+	{Name: "brpop", Arity: -3, Flags: Write | Blocking, FirstKey: 1, LastKey: -2, Step: 1, timeout: secondsAt(-1)},
+	{Name: "brpoplpush", Arity: 4, Flags: Write | Blocking, FirstKey: 1, LastKey: 2, Step: 1, timeout: secondsAt(-1)},
+	{Name: "blmove", Arity: 6, Flags: Write | Blocking, FirstKey: 1, LastKey: 2, Step: 1, timeout: secondsAt(-1)},
+	{Name: "blmpop", Arity: -5, Flags: Write | Blocking | MovableKeys, keys: numKeysAt(2), timeout: secondsAt(1)},
+	// Sets
+	{Name: "sadd", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "srem", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "smembers", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "sismember", Arity: 3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "smismember", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "scard", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "spop", Arity: -2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "srandmember", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "smove", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 2, Step: 1},
+	{Name: "sinter", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "sunion", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "sdiff", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "sinterstore", Arity: -3, Flags: Write, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "sunionstore", Arity: -3, Flags: Write, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "sdiffstore", Arity: -3, Flags: Write, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "sintercard", Arity: -3, Flags: Readonly | MovableKeys, keys: numKeysAt(1)},
+	{Name: "sscan", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	// Sorted sets
+	{Name: "zadd", Arity: -4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zincrby", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zrem", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zcard", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zscore", Arity: 3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zmscore", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zrank", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zrevrank", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zrange", Arity: -4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zrangestore", Arity: -5, Flags: Write, FirstKey: 1, LastKey: 2, Step: 1},
+	{Name: "zrevrange", Arity: -4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zrangebyscore", Arity: -4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zrevrangebyscore", Arity: -4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zrangebylex", Arity: -4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zrevrangebylex", Arity: -4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zcount", Arity: 4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zlexcount", Arity: 4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zremrangebyrank", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zremrangebyscore", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zremrangebylex", Arity: 4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zpopmin", Arity: -2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zpopmax", Arity: -2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zrandmember", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "zunionstore", Arity: -4, Flags: Write | MovableKeys, FirstKey: 1, LastKey: 1, Step: 1, keys: numKeysAt(2)},
+	{Name: "zinterstore", Arity: -4, Flags: Write | MovableKeys, FirstKey: 1, LastKey: 1, Step: 1, keys: numKeysAt(2)},
+	{Name: "zdiffstore", Arity: -4, Flags: Write | MovableKeys, FirstKey: 1, LastKey: 1, Step: 1, keys: numKeysAt(2)},
+	{Name: "zunion", Arity: -3, Flags: Readonly | MovableKeys, keys: numKeysAt(1)},
+	{Name: "zinter", Arity: -3, Flags: Readonly | MovableKeys, keys: numKeysAt(1)},
+	{Name: "zdiff", Arity: -3, Flags: Readonly | MovableKeys, keys: numKeysAt(1)},
+	{Name: "zintercard", Arity: -3, Flags: Readonly | MovableKeys, keys: numKeysAt(1)},
+	{Name: "zmpop", Arity: -4, Flags: Write | MovableKeys, keys: numKeysAt(1)},
+	{Name: "bzpopmin", Arity: -3, Flags: Write | Blocking, FirstKey: 1, LastKey: -2, Step: 1, timeout: secondsAt(-1)},
+	{Name: "bzpopmax", Arity: -3, Flags: Write | Blocking, FirstKey: 1, LastKey: -2, Step: 1, timeout: secondsAt(-1)},
+	{Name: "bzmpop", Arity: -5, Flags: Write | Blocking | MovableKeys, keys: numKeysAt(2), timeout: secondsAt(1)},
+	{Name: "zscan", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	// HyperLogLog and geo
+	{Name: "pfadd", Arity: -2, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "pfcount", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "pfmerge", Arity: -2, Flags: Write, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "geoadd", Arity: -5, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "geodist", Arity: -4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "geohash", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "geopos", Arity: -2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "georadius", Arity: -6, Flags: Write | MovableKeys, FirstKey: 1, LastKey: 1, Step: 1, keys: geoRadiusKeys},
+	{Name: "georadiusbymember", Arity: -5, Flags: Write | MovableKeys, FirstKey: 1, LastKey: 1, Step: 1, keys: geoRadiusKeys},
+	{Name: "georadius_ro", Arity: -6, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "georadiusbymember_ro", Arity: -5, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "geosearch", Arity: -7, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "geosearchstore", Arity: -8, Flags: Write, FirstKey: 1, LastKey: 2, Step: 1},
+	// Streams
+	{Name: "xadd", Arity: -5, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xlen", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xrange", Arity: -4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xrevrange", Arity: -4, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xdel", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xtrim", Arity: -4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xread", Arity: -4, Flags: Readonly | Blocking | MovableKeys, keys: streamsKeys, timeout: streamsTimeout},
+	{Name: "xreadgroup", Arity: -7, Flags: Write | Blocking | MovableKeys, keys: streamsKeys, timeout: streamsTimeout},
+	{Name: "xack", Arity: -4, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xpending", Arity: -3, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xclaim", Arity: -6, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xautoclaim", Arity: -6, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xsetid", Arity: -3, Flags: Write, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "xgroup|create", Arity: -5, Flags: Write, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "xgroup|createconsumer", Arity: 5, Flags: Write, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "xgroup|delconsumer", Arity: 5, Flags: Write, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "xgroup|destroy", Arity: 4, Flags: Write, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "xgroup|setid", Arity: -5, Flags: Write, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "xinfo|stream", Arity: -3, Flags: Readonly, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "xinfo|groups", Arity: 3, Flags: Readonly, FirstKey: 2, LastKey: 2, Step: 1},
+	{Name: "xinfo|consumers", Arity: 4, Flags: Readonly, FirstKey: 2, LastKey: 2, Step: 1},
+	// Scripting and functions
+	{Name: "eval", Arity: -3, Flags: MayReplicate | MovableKeys, keys: numKeysAt(2)},
+	{Name: "evalsha", Arity: -3, Flags: MayReplicate | MovableKeys, keys: numKeysAt(2)},
+	{Name: "eval_ro", Arity: -3, Flags: Readonly | MovableKeys, keys: numKeysAt(2)},
+	{Name: "evalsha_ro", Arity: -3, Flags: Readonly | MovableKeys, keys: numKeysAt(2)},
+	{Name: "fcall", Arity: -3, Flags: MayReplicate | MovableKeys, keys: numKeysAt(2)},
+	{Name: "fcall_ro", Arity: -3, Flags: Readonly | MovableKeys, keys: numKeysAt(2)},
+	{Name: "script|load", Arity: 3},
+	{Name: "script|exists", Arity: -3},
+	{Name: "script|flush", Arity: -2, Flags: MayReplicate},
+	{Name: "script|kill", Arity: 2},
+	{Name: "function|load", Arity: -3, Flags: Write},
+	{Name: "function|delete", Arity: 3, Flags: Write},
+	{Name: "function|flush", Arity: -2, Flags: Write},
+	{Name: "function|restore", Arity: -3, Flags: Write},
+	{Name: "function|list", Arity: -2},
+	{Name: "function|dump", Arity: 2},
+	{Name: "function|kill", Arity: 2},
+	{Name: "function|stats", Arity: 2},
+	// Pub/Sub
+	{Name: "publish", Arity: 3, Flags: PubSub},
+	{Name: "subscribe", Arity: -2, Flags: PubSub},
+	{Name: "unsubscribe", Arity: -1, Flags: PubSub},
+	{Name: "psubscribe", Arity: -2, Flags: PubSub},
+	{Name: "punsubscribe", Arity: -1, Flags: PubSub},
+	{Name: "spublish", Arity: 3, Flags: PubSub, FirstKey: 1, LastKey: 1, Step: 1},
+	{Name: "ssubscribe", Arity: -2, Flags: PubSub, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "sunsubscribe", Arity: -1, Flags: PubSub, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "pubsub|channels", Arity: -2, Flags: PubSub},
+	{Name: "pubsub|numpat", Arity: 2, Flags: PubSub},
+	{Name: "pubsub|numsub", Arity: -2, Flags: PubSub},
+	{Name: "pubsub|shardchannels", Arity: -2, Flags: PubSub},
+	{Name: "pubsub|shardnumsub", Arity: -2, Flags: PubSub},
+	// Transactions and connections
+	{Name: "multi", Arity: 1},
+	{Name: "exec", Arity: 1},
+	{Name: "discard", Arity: 1},
+	{Name: "watch", Arity: -2, FirstKey: 1, LastKey: -1, Step: 1},
+	{Name: "unwatch", Arity: 1},
+	{Name: "auth", Arity: -2},
+	{Name: "hello", Arity: -1},
+	{Name: "ping", Arity: -1},
+	{Name: "echo", Arity: 2},
+	{Name: "select", Arity: 2},
+	{Name: "quit", Arity: -1},
+	{Name: "reset", Arity: 1},
+	{Name: "readonly", Arity: 1},
+	{Name: "readwrite", Arity: 1},
+	{Name: "client", Arity: -2},
+	{Name: "client|kill", Arity: -3, Flags: Admin},
+	{Name: "client|pause", Arity: -3, Flags: Admin},
+	{Name: "client|unpause", Arity: 2, Flags: Admin},
+	{Name: "client|no-evict", Arity: 3, Flags: Admin},
+	// Server
+	{Name: "flushall", Arity: -1, Flags: Write},
+	{Name: "flushdb", Arity: -1, Flags: Write},
+	{Name: "swapdb", Arity: 3, Flags: Write},
+	{Name: "dbsize", Arity: 1, Flags: Readonly},
+	{Name: "info", Arity: -1},
+	{Name: "time", Arity: 1},
+	{Name: "lastsave", Arity: 1},
+	{Name: "role", Arity: 1},
+	{Name: "command", Arity: -1},
+	{Name: "wait", Arity: 3, Flags: Blocking, timeout: millisecondsAt(2)},
+	{Name: "waitaof", Arity: 4, Flags: Blocking, timeout: millisecondsAt(3)},
+	{Name: "config|get", Arity: -3, Flags: Admin},
+	{Name: "config|set", Arity: -4, Flags: Admin},
+	{Name: "config|rewrite", Arity: 2, Flags: Admin},
+	{Name: "config|resetstat", Arity: 2, Flags: Admin},
+	{Name: "debug", Arity: -2, Flags: Admin},
+	{Name: "shutdown", Arity: -1, Flags: Admin},
+	{Name: "save", Arity: 1, Flags: Admin},
+	{Name: "bgsave", Arity: -1, Flags: Admin},
+	{Name: "bgrewriteaof", Arity: 1, Flags: Admin},
+	{Name: "monitor", Arity: 1, Flags: Admin},
+	{Name: "slowlog", Arity: -2, Flags: Admin},
+	{Name: "latency", Arity: -2, Flags: Admin},
+	{Name: "acl", Arity: -2, Flags: Admin},
+	{Name: "module", Arity: -2, Flags: Admin},
+	{Name: "replicaof", Arity: 3, Flags: Admin},
+	{Name: "slaveof", Arity: 3, Flags: Admin},
+	{Name: "failover", Arity: -1, Flags: Admin},
+	{Name: "sync", Arity: 1, Flags: Admin},
+	{Name: "psync", Arity: -3, Flags: Admin},
+	{Name: "cluster", Arity: -2},
+	{Name: "cluster|reset", Arity: -2, Flags: Admin},
+	{Name: "cluster|failover", Arity: -2, Flags: Admin},
+	{Name: "cluster|forget", Arity: 3, Flags: Admin},
+	{Name: "cluster|meet", Arity: -4, Flags: Admin},
+	{Name: "cluster|addslots", Arity: -3, Flags: Admin},
+	{Name: "cluster|addslotsrange", Arity: -4, Flags: Admin},
+	{Name: "cluster|delslots", Arity: -3, Flags: Admin},
+	{Name: "cluster|delslotsrange", Arity: -4, Flags: Admin},
+	{Name: "cluster|flushslots", Arity: 2, Flags: Admin},
+	{Name: "cluster|setslot", Arity: -4, Flags: Admin},
+	{Name: "cluster|replicate", Arity: 3, Flags: Admin},
+	{Name: "cluster|saveconfig", Arity: 2, Flags: Admin},
+	{Name: "cluster|set-config-epoch", Arity: 3, Flags: Admin},
+	{Name: "cluster|bumpepoch", Arity: 2, Flags: Admin},
+}
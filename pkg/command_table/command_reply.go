@@ -0,0 +1,105 @@
+package command_table
+
+import (
+	"fmt"
+	"redis_cluster_proxy/pkg/redis"
+	"strings"
+)
+
+// Statement is COMMAND, serialized, to ask a server for its command table
+const Statement = "*1\r\n$7\r\nCOMMAND\r\n"
+
+// flagNames maps the flags in the reply to COMMAND to Flag. Other flags are not used by the proxy
+var flagNames = map[string]Flag{
+	"write":         Write,
+	"readonly":      Readonly,
+	"may_replicate": MayReplicate,
+	"admin":         Admin,
+	"pubsub":        PubSub,
+	"blocking":      Blocking,
+	"movablekeys":   MovableKeys,
+}
+
+// SpecsFromCommandReply reads the reply to COMMAND. Each command is an array of its name, arity, flags, first key, last
+// key and step, followed on newer servers by ACL categories, tips, key specifications and subcommands, which are
+// described the same way
+func SpecsFromCommandReply(reply redis.Componenter) (specs []Spec, err error) {
+	if errorReply, ok := reply.(*redis.ErrorComp); ok {
+		return nil, fmt.Errorf("COMMAND failed: %s", errorReply.String())
+	}
+	commands, ok := reply.(*redis.Array)
+	if !ok {
+		return nil, fmt.Errorf("COMMAND replied with a %s, not an array", typeName(reply))
+	}
+	specs = make([]Spec, 0, len(*commands))
+	for i, command := range *commands {
+		specs, err = appendSpecs(specs, command)
+		if err != nil {
+			return nil, fmt.Errorf("command %d: %s", i, err)
+		}
+	}
+	return
+}
+
+// appendSpecs reads one command, and its subcommands, and appends them to specs
+func appendSpecs(specs []Spec, command redis.Componenter) ([]Spec, error) {
+	fields, ok := command.(*redis.Array)
+	if !ok || len(*fields) < 6 {
+		return nil, fmt.Errorf("expected an array of at least 6 fields")
+	}
+	name, ok := stringValue((*fields)[0])
+	if !ok {
+		return nil, fmt.Errorf("expected the command name first")
+	}
+	spec := Spec{Name: name}
+	for index, value := range map[int]*int{1: &spec.Arity, 3: &spec.FirstKey, 4: &spec.LastKey, 5: &spec.Step} {
+		if *value, ok = intValue((*fields)[index]); !ok {
+			return nil, fmt.Errorf("%s: expected an integer in field %d", name, index)
+		}
+	}
+	spec.Name = strings.ToLower(spec.Name)
+	if flags, isArray := (*fields)[2].(*redis.Array); isArray {
+		for _, flag := range *flags {
+			if name, isString := stringValue(flag); isString {
+				spec.Flags |= flagNames[strings.ToLower(name)]
+			}
+		}
+	}
+	specs = append(specs, spec)
+	if len(*fields) > 9 {
+		if subcommands, isArray := (*fields)[9].(*redis.Array); isArray {
+			var err error
+			for _, subcommand := range *subcommands {
+				specs, err = appendSpecs(specs, subcommand)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", spec.Name, err)
+				}
+			}
+		}
+	}
+	return specs, nil
+}
+
+func stringValue(component redis.Componenter) (value string, ok bool) {
+	switch typed := component.(type) {
+	case *redis.BulkString:
+		return typed.String(), true
+	case *redis.SimpleString:
+		return typed.String(), true
+	}
+	return
+}
+
+func intValue(component redis.Componenter) (value int, ok bool) {
+	if typed, isInt := component.(*redis.Int); isInt {
+		return typed.Int(), true
+	}
+	return
+}
+
+func typeName(component redis.Componenter) string {
+	if component == nil {
+		return "nothing"
+	}
+	return component.RedisTypeName()
+}
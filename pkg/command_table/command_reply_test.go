@@ -0,0 +1,52 @@
+package command_table
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"redis_cluster_proxy/pkg/redis"
+	"testing"
+	"time"
+)
+
+func TestSpecsFromCommandReply(t *testing.T) {
+	// GET from Redis 6, and EVAL and CONFIG with its GET subcommand from Redis 7, trimmed
+	reply := "*3\r\n" +
+		"*7\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n*0\r\n" +
+		"*10\r\n$4\r\neval\r\n:-3\r\n*3\r\n+noscript\r\n+may_replicate\r\n+movablekeys\r\n:0\r\n:0\r\n:0\r\n*0\r\n*0\r\n*0\r\n*0\r\n" +
+		"*10\r\n$6\r\nconfig\r\n:-2\r\n*0\r\n:0\r\n:0\r\n:0\r\n*0\r\n*0\r\n*0\r\n" +
+		"*1\r\n*10\r\n$10\r\nconfig|get\r\n:-3\r\n*2\r\n+admin\r\n+noscript\r\n:0\r\n:0\r\n:0\r\n*0\r\n*0\r\n*0\r\n*0\r\n"
+	component, _, err := redis.ComponentFromReader(bytes.NewBufferString(reply), make([]byte, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	specs, err := SpecsFromCommandReply(component)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []Spec{
+		{Name: "get", Arity: 2, Flags: Readonly, FirstKey: 1, LastKey: 1, Step: 1},
+		{Name: "eval", Arity: -3, Flags: MayReplicate | MovableKeys},
+		{Name: "config", Arity: -2},
+		{Name: "config|get", Arity: -3, Flags: Admin},
+	}, specs)
+
+	table := NewTable(nil).Merge(specs)
+	spec, ok := table.Lookup([]string{"CONFIG", "GET", "maxmemory"})
+	if assert.True(t, ok) {
+		assert.True(t, spec.Has(Admin))
+	}
+
+	merged := Bundled().Merge(specs)
+	spec, ok = merged.Lookup([]string{"EVAL", "return 1", "1", "k"})
+	if assert.True(t, ok) {
+		assert.Equal(t, []string{"k"}, spec.Keys([]string{"EVAL", "return 1", "1", "k"}), "movable keys keep the bundled key finder")
+	}
+	assert.Equal(t, Bundled().Len()+1, merged.Len(), "only the config container is new")
+
+	spec, _ = Bundled().Merge([]Spec{{Name: "blpop", Arity: -3, Flags: Write | Blocking, FirstKey: 1, LastKey: -2, Step: 1}}).Lookup([]string{"BLPOP", "k", "2"})
+	timeout, _ := spec.BlockTimeout([]string{"BLPOP", "k", "2"})
+	assert.Equal(t, 2*time.Second, timeout, "blocking commands keep the bundled timeout finder")
+
+	_, err = SpecsFromCommandReply(redis.NewErrorFromString("NOPERM this user has no permissions to run the 'command' command"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+package command_table
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Flag is a property of a command, named after the flags in the reply to COMMAND
+type Flag uint32
+
+const (
+	// Write commands may change the data
+	Write Flag = 1 << iota
+	// Readonly commands never change the data
+	Readonly
+	// MayReplicate commands, such as EVAL, may or may not change the data
+	MayReplicate
+	// Admin commands change or inspect the server itself
+	Admin
+	// PubSub commands publish or subscribe to channels
+	PubSub
+	// Blocking commands may wait for data or for replicas before they reply
+	Blocking
+	// MovableKeys commands have keys that FirstKey, LastKey and Step cannot describe, such as the keys of EVAL
+	MovableKeys
+)
+
+// Spec describes one command the way COMMAND does: its arity, flags and where its keys are
+type Spec struct {
+	// Name is lower case. Subcommands are named "command|subcommand"
+	Name string
+	// Arity is the number of arguments, including the command name. A negative arity is a minimum of -Arity
+	Arity int
+	Flags Flag
+	// FirstKey is the index of the first key, 0 if the command has no keys at fixed positions
+	FirstKey int
+	// LastKey is the index of the last key. Negative counts back from the end, so -1 is the last argument
+	LastKey int
+	// Step is the distance between keys, such as 2 for MSET key value key value
+	Step int
+	// keys finds the keys of MovableKeys commands. It is given every argument, including the command name
+	keys func(args []string) []int
+	// timeout finds how long a Blocking command may block. It is given every argument, including the command name
+	timeout func(args []string) (timeout time.Duration, blocks bool)
+}
+
+// Has is true if the command has every one of flags
+func (s Spec) Has(flags Flag) bool {
+	return s.Flags&flags == flags
+}
+
+// HasAny is true if the command has at least one of flags
+func (s Spec) HasAny(flags Flag) bool {
+	return s.Flags&flags != 0
+}
+
+// CheckArity is true if args, including the command name, is an acceptable number of arguments
+func (s Spec) CheckArity(args []string) bool {
+	if s.Arity >= 0 {
+		return len(args) == s.Arity
+	}
+	return len(args) >= -s.Arity
+}
+
+// KeyIndexes returns the positions of the keys in args, which includes the command name. Each position is listed once
+func (s Spec) KeyIndexes(args []string) (indexes []int) {
+	if s.FirstKey > 0 && s.FirstKey < len(args) {
+		last := s.LastKey
+		if last < 0 {
+			last += len(args)
+		}
+		if last >= len(args) {
+			last = len(args) - 1
+		}
+		step := s.Step
+		if step <= 0 {
+			step = 1
+		}
+		for i := s.FirstKey; i <= last; i += step {
+			indexes = append(indexes, i)
+		}
+	}
+	if s.keys != nil {
+		for _, index := range s.keys(args) {
+			if !containsIndex(indexes, index) {
+				indexes = append(indexes, index)
+			}
+		}
+	}
+	return
+}
+
+func containsIndex(indexes []int, index int) bool {
+	for _, existing := range indexes {
+		if existing == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns the keys in args, which includes the command name
+func (s Spec) Keys(args []string) (keys []string) {
+	for _, index := range s.KeyIndexes(args) {
+		keys = append(keys, args[index])
+	}
+	return
+}
+
+// BlockTimeout returns how long the command in args, which includes the command name, may block before it replies. A zero
+// timeout blocks until there is something to reply. blocks is false for commands that reply at once, including Blocking
+// commands such as XREAD that were not asked to block. Blocking commands the proxy does not know block without a timeout
+func (s Spec) BlockTimeout(args []string) (timeout time.Duration, blocks bool) {
+	if !s.Has(Blocking) {
+		return 0, false
+	}
+	if s.timeout == nil {
+		return 0, true
+	}
+	return s.timeout(args)
+}
+
+// secondsAt finds a timeout in seconds, such as the last argument of BLPOP key [key ...] timeout at index -1. A negative
+// index counts back from the end
+func secondsAt(index int) func(args []string) (time.Duration, bool) {
+	return func(args []string) (time.Duration, bool) {
+		at := index
+		if at < 0 {
+			at += len(args)
+		}
+		if at < 0 || at >= len(args) {
+			return 0, true
+		}
+		seconds, err := strconv.ParseFloat(args[at], 64)
+		if err != nil || seconds < 0 {
+			return 0, true
+		}
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+}
+
+// millisecondsAt finds a timeout in milliseconds, such as that of WAIT numreplicas timeout at index 2
+func millisecondsAt(index int) func(args []string) (time.Duration, bool) {
+	return func(args []string) (time.Duration, bool) {
+		if index >= len(args) {
+			return 0, true
+		}
+		return milliseconds(args[index]), true
+	}
+}
+
+// streamsTimeout finds the timeout of XREAD and XREADGROUP, which only block with BLOCK milliseconds before STREAMS
+func streamsTimeout(args []string) (time.Duration, bool) {
+	for i := 1; i+1 < len(args) && !strings.EqualFold(args[i], "STREAMS"); i++ {
+		if strings.EqualFold(args[i], "BLOCK") {
+			return milliseconds(args[i+1]), true
+		}
+	}
+	return 0, false
+}
+
+func milliseconds(arg string) time.Duration {
+	count, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || count < 0 {
+		return 0
+	}
+	return time.Duration(count) * time.Millisecond
+}
+
+// numKeysAt finds keys that follow a count, such as EVAL script numkeys key [key ...] where the count is at index 2
+func numKeysAt(countIndex int) func(args []string) []int {
+	return func(args []string) (indexes []int) {
+		if countIndex >= len(args) {
+			return
+		}
+		count, err := strconv.Atoi(args[countIndex])
+		if err != nil || count < 0 {
+			return
+		}
+		for i := countIndex + 1; i <= countIndex+count && i < len(args); i++ {
+			indexes = append(indexes, i)
+		}
+		return
+	}
+}
+
+// streamsKeys finds the keys of XREAD and XREADGROUP: the first half of the arguments after STREAMS
+func streamsKeys(args []string) (indexes []int) {
+	for i := 1; i < len(args); i++ {
+		if strings.EqualFold(args[i], "STREAMS") {
+			rest := len(args) - i - 1
+			for j := i + 1; j <= i+rest/2; j++ {
+				indexes = append(indexes, j)
+			}
+			return
+		}
+	}
+	return
+}
+
+// sortKeys finds the destination of SORT key ... STORE destination
+func sortKeys(args []string) []int {
+	return keywordKeys(args, "STORE")
+}
+
+// geoRadiusKeys finds the destination of GEORADIUS ... STORE key or STOREDIST key
+func geoRadiusKeys(args []string) []int {
+	return keywordKeys(args, "STORE", "STOREDIST")
+}
+
+// keywordKeys finds the arguments that follow any of keywords, skipping the command and its first key
+func keywordKeys(args []string, keywords ...string) (indexes []int) {
+	for i := 2; i+1 < len(args); i++ {
+		for _, keyword := range keywords {
+			if strings.EqualFold(args[i], keyword) {
+				indexes = append(indexes, i+1)
+			}
+		}
+	}
+	return
+}
+
+// migrateKeys finds the keys of MIGRATE host port key db timeout, or MIGRATE host port "" db timeout ... KEYS key [key ...]
+func migrateKeys(args []string) (indexes []int) {
+	if len(args) > 3 && len(args[3]) != 0 {
+		return []int{3}
+	}
+	for i := 6; i < len(args); i++ {
+		if strings.EqualFold(args[i], "KEYS") {
+			for j := i + 1; j < len(args); j++ {
+				indexes = append(indexes, j)
+			}
+			return
+		}
+	}
+	return
+}
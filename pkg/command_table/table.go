@@ -0,0 +1,85 @@
+package command_table
+
+import "strings"
+
+// Table looks up commands by name. It is not changed after it is built, so it is safe to use from many goroutines
+type Table struct {
+	specs map[string]Spec
+	// containers are the commands that are listed per subcommand
+	containers map[string]bool
+}
+
+// NewTable builds a table from specs
+func NewTable(specs []Spec) *Table {
+	t := &Table{
+		specs:      make(map[string]Spec, len(specs)),
+		containers: make(map[string]bool),
+	}
+	for _, spec := range specs {
+		spec.Name = strings.ToLower(spec.Name)
+		t.specs[spec.Name] = spec
+		if separator := strings.IndexByte(spec.Name, '|'); separator > 0 {
+			t.containers[spec.Name[:separator]] = true
+		}
+	}
+	return t
+}
+
+// Merge returns a new table with specs, such as those read from a server with COMMAND, replacing or adding to the commands
+// in t. The reply to COMMAND cannot say where the keys of MovableKeys commands are, nor where the timeout of Blocking
+// commands is, so those keep the finders from t. Commands with a key finder also keep the key range from t, as servers
+// report ranges that overlap the finder, such as index 3 for MIGRATE or 1 for XREAD on Redis 6
+func (t *Table) Merge(specs []Spec) *Table {
+	merged := make([]Spec, 0, len(t.specs)+len(specs))
+	for _, spec := range t.specs {
+		merged = append(merged, spec)
+	}
+	for _, spec := range specs {
+		spec.Name = strings.ToLower(spec.Name)
+		if existing, ok := t.specs[spec.Name]; ok {
+			if spec.keys == nil && existing.keys != nil {
+				spec.keys = existing.keys
+				spec.FirstKey, spec.LastKey, spec.Step = existing.FirstKey, existing.LastKey, existing.Step
+			}
+			if spec.timeout == nil {
+				spec.timeout = existing.timeout
+			}
+		}
+		merged = append(merged, spec)
+	}
+	return NewTable(merged)
+}
+
+// Bundled is the table of Redis 7 commands built into the proxy
+func Bundled() *Table {
+	return bundledTable
+}
+
+var bundledTable = NewTable(bundled)
+
+// Lookup finds the command args[0], or its subcommand args[1] for container commands such as CONFIG. args includes the
+// command name. Names are case insensitive
+func (t *Table) Lookup(args []string) (spec Spec, ok bool) {
+	if len(args) == 0 {
+		return
+	}
+	name := strings.ToLower(args[0])
+	if t.containers[name] && len(args) > 1 {
+		if spec, ok = t.specs[name+"|"+strings.ToLower(args[1])]; ok {
+			return
+		}
+	}
+	spec, ok = t.specs[name]
+	return
+}
+
+// Has is true if name, such as "get" or "config|set", is in the table
+func (t *Table) Has(name string) bool {
+	_, ok := t.specs[strings.ToLower(name)]
+	return ok
+}
+
+// Len is the number of commands and subcommands in the table
+func (t *Table) Len() int {
+	return len(t.specs)
+}
@@ -0,0 +1,115 @@
+package command_table
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeys(t *testing.T) {
+	cases := map[string]struct {
+		command  string
+		expected []string
+	}{
+		"single key":         {command: "GET k", expected: []string{"k"}},
+		"every key":          {command: "DEL a b c", expected: []string{"a", "b", "c"}},
+		"key value pairs":    {command: "MSET a 1 b 2", expected: []string{"a", "b"}},
+		"last key excluded":  {command: "BLPOP a b 0", expected: []string{"a", "b"}},
+		"subcommand":         {command: "OBJECT ENCODING k", expected: []string{"k"}},
+		"numkeys":            {command: "EVAL script 2 a b arg", expected: []string{"a", "b"}},
+		"destination":        {command: "ZUNIONSTORE dest 2 a b WEIGHTS 1 2", expected: []string{"dest", "a", "b"}},
+		"streams":            {command: "XREAD COUNT 2 STREAMS a b 0 0", expected: []string{"a", "b"}},
+		"sort store":         {command: "SORT k LIMIT 0 5 STORE dest", expected: []string{"k", "dest"}},
+		"migrate single":     {command: "MIGRATE host 6379 k 0 1000", expected: []string{"k"}},
+		"migrate keys":       {command: "MIGRATE host 6379 \"\" 0 1000 KEYS a b", expected: []string{"a", "b"}},
+		"no keys":            {command: "PING", expected: nil},
+		"container fallback": {command: "CLIENT LIST", expected: nil},
+	}
+
+	table := Bundled()
+	for caseName, c := range cases {
+		args := strings.Fields(c.command)
+		if args[0] == "MIGRATE" && args[3] == "\"\"" {
+			args[3] = ""
+		}
+		spec, ok := table.Lookup(args)
+		if !assert.True(t, ok, caseName) {
+			continue
+		}
+		assert.Equal(t, c.expected, spec.Keys(args), caseName)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table := Bundled()
+	spec, ok := table.Lookup([]string{"Config", "SET", "maxmemory", "1"})
+	if assert.True(t, ok) {
+		assert.Equal(t, "config|set", spec.Name)
+		assert.True(t, spec.Has(Admin))
+	}
+	spec, ok = table.Lookup([]string{"set", "k", "v"})
+	if assert.True(t, ok) {
+		assert.True(t, spec.Has(Write))
+		assert.True(t, spec.CheckArity([]string{"set", "k", "v"}))
+		assert.False(t, spec.CheckArity([]string{"set", "k"}))
+	}
+	_, ok = table.Lookup([]string{"NOTACOMMAND"})
+	assert.False(t, ok)
+}
+
+func TestBlockTimeout(t *testing.T) {
+	cases := map[string]struct {
+		command         string
+		expectedTimeout time.Duration
+		expectedBlocks  bool
+	}{
+		"seconds last":           {command: "BLPOP a b 1.5", expectedTimeout: 1500 * time.Millisecond, expectedBlocks: true},
+		"forever":                {command: "BRPOP a 0", expectedTimeout: 0, expectedBlocks: true},
+		"seconds first":          {command: "BLMPOP 2 1 a LEFT", expectedTimeout: 2 * time.Second, expectedBlocks: true},
+		"stream block":           {command: "XREAD COUNT 1 BLOCK 250 STREAMS a 0", expectedTimeout: 250 * time.Millisecond, expectedBlocks: true},
+		"stream without block":   {command: "XREAD COUNT 1 STREAMS a 0", expectedBlocks: false},
+		"block is a stream name": {command: "XREAD STREAMS BLOCK 0", expectedBlocks: false},
+		"wait":                   {command: "WAIT 1 100", expectedTimeout: 100 * time.Millisecond, expectedBlocks: true},
+		"not blocking":           {command: "LPOP a", expectedBlocks: false},
+	}
+
+	table := Bundled()
+	for caseName, c := range cases {
+		args := strings.Fields(c.command)
+		spec, ok := table.Lookup(args)
+		if !assert.True(t, ok, caseName) {
+			continue
+		}
+		timeout, blocks := spec.BlockTimeout(args)
+		assert.Equal(t, c.expectedBlocks, blocks, caseName)
+		assert.Equal(t, c.expectedTimeout, timeout, caseName)
+	}
+}
+
+func TestMergeKeepsKeyFinders(t *testing.T) {
+	// Key ranges as reported by COMMAND: MIGRATE from Redis 7, and XREAD and XREADGROUP from Redis 6
+	table := Bundled().Merge([]Spec{
+		{Name: "migrate", Arity: -6, Flags: Write | MovableKeys, FirstKey: 3, LastKey: 3, Step: 1},
+		{Name: "xread", Arity: -4, Flags: Readonly | Blocking | MovableKeys, FirstKey: 1, LastKey: 1, Step: 1},
+		{Name: "xreadgroup", Arity: -7, Flags: Write | Blocking | MovableKeys, FirstKey: 1, LastKey: 1, Step: 1},
+	})
+	cases := map[string]struct {
+		args     []string
+		expected []string
+	}{
+		"migrate single":    {args: []string{"MIGRATE", "host", "6379", "k", "0", "1000"}, expected: []string{"k"}},
+		"migrate keys":      {args: []string{"MIGRATE", "host", "6379", "", "0", "1000", "KEYS", "a", "b"}, expected: []string{"a", "b"}},
+		"xread count":       {args: strings.Fields("XREAD COUNT 2 STREAMS a b 0 0"), expected: []string{"a", "b"}},
+		"xreadgroup group":  {args: strings.Fields("XREADGROUP GROUP g c STREAMS a >"), expected: []string{"a"}},
+		"xread block first": {args: strings.Fields("XREAD BLOCK 0 STREAMS a $"), expected: []string{"a"}},
+	}
+
+	for caseName, c := range cases {
+		spec, ok := table.Lookup(c.args)
+		if !assert.True(t, ok, caseName) {
+			continue
+		}
+		assert.Equal(t, c.expected, spec.Keys(c.args), caseName)
+	}
+}
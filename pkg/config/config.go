@@ -0,0 +1,551 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"redis_cluster_proxy/pkg/ip_map"
+	"strings"
+	"time"
+)
+
+// Config is the schema of the file passed to the server command with -config. Every command line flag has a matching field.
+// Precedence, from highest to lowest: command line flags, environment variables, the config file, then the defaults from Defaults
+type Config struct {
+	ListenAddr               string      `yaml:"listenAddr"`
+	RouteAddr                string      `yaml:"routeAddr"`
+	ClusterAddr              string      `yaml:"clusterAddr"`
+	PublicHost               string      `yaml:"publicHost"`
+	NumberOfBuffers          int         `yaml:"numberOfBuffers"`
+	MaxConcurrentConnections int         `yaml:"maxConcurrentConnections"`
+	ReadBufferByteSize       int         `yaml:"readBufferByteSize"`
+	Debug                    bool        `yaml:"debug"`
+	ReadOnly                 bool        `yaml:"readOnly"`
+	Timeouts                 Timeouts    `yaml:"timeouts"`
+	Credentials              Credentials `yaml:"credentials"`
+	TLS                      TLS         `yaml:"tls"`
+	Ports                    Ports       `yaml:"ports"`
+	// Horizons are extra networks to listen on, each with the public host advertised to the clients that connect through it
+	Horizons []Horizon `yaml:"horizons"`
+	// TopologyRefreshInterval is how often the proxy asks the cluster for its nodes, to follow nodes that move. 0 disables it
+	TopologyRefreshInterval time.Duration `yaml:"topologyRefreshInterval"`
+	// Commands limits the commands clients may run through the proxy. Applies to every cluster
+	Commands Commands `yaml:"commands"`
+	// Access limits the client IPs that may connect to any listener
+	Access Access `yaml:"access"`
+	// Users makes the proxy answer AUTH and HELLO AUTH itself, instead of the cluster. Each user logs in to the cluster with
+	// its own backend credentials, or the proxy's, and may be limited to some commands and keys
+	Users []User `yaml:"users"`
+	// RateLimits throttle the commands each client, and each user, sends. Applies to every cluster separately
+	RateLimits RateLimits `yaml:"rateLimits"`
+	// Sentinel switches from Redis Cluster to standalone servers managed by Sentinel
+	Sentinel Sentinel `yaml:"sentinel"`
+	// KeyPrefix is a key namespace: prepended to every key clients send, and stripped from the keys in replies. Users with
+	// their own keyPrefix use that instead
+	KeyPrefix string `yaml:"keyPrefix"`
+	// ScriptNode is the HOST_OR_IP:PORT, as the cluster advertises it, of the node the routing endpoint sends the scripts and
+	// functions that declare no keys to. They are rejected when it is empty
+	ScriptNode string `yaml:"scriptNode"`
+	// Clusters proxies several clusters from one process. When set, listenAddr, clusterAddr, publicHost, ports and horizons
+	// move into each cluster. Credentials, keyPrefix and scriptNode at the top level are used by clusters that do not set their own
+	Clusters []Cluster `yaml:"clusters"`
+}
+
+// DefaultClusterName names the cluster described by the top level settings when no clusters are listed
+const DefaultClusterName = "default"
+
+// Cluster is one Redis Cluster hosted by the proxy, with its own ports and address map
+type Cluster struct {
+	Name        string      `yaml:"name"`
+	ListenAddr  string      `yaml:"listenAddr"`
+	RouteAddr   string      `yaml:"routeAddr"`
+	ClusterAddr string      `yaml:"clusterAddr"`
+	PublicHost  string      `yaml:"publicHost"`
+	Credentials Credentials `yaml:"credentials"`
+	Ports       Ports       `yaml:"ports"`
+	Horizons    []Horizon   `yaml:"horizons"`
+	Sentinel    Sentinel    `yaml:"sentinel"`
+	KeyPrefix   string      `yaml:"keyPrefix"`
+	ScriptNode  string      `yaml:"scriptNode"`
+}
+
+// Commands are allow and deny rules, each a command name such as "FLUSHALL", or a command and subcommand such as "CONFIG SET".
+// Deny rules win. When Allow is set, commands that match no allow rule are rejected too
+type Commands struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// User is a client login checked by the proxy
+type User struct {
+	Name string `yaml:"name"`
+	// PasswordSHA256 is the hex encoded SHA-256 of the password, such as the output of: printf %s 'password' | sha256sum
+	PasswordSHA256 string `yaml:"passwordSha256"`
+	// Backend are the credentials used with the cluster once a client logs in as this user. Unset uses the top level credentials
+	Backend Credentials `yaml:"backend"`
+	// Commands limits the commands this user may run, on top of the proxy wide commands
+	Commands Commands `yaml:"commands"`
+	// Keys are glob patterns, as in Redis ACL ~patterns, every key the user touches must match. Unset allows every key
+	Keys []string `yaml:"keys"`
+	// KeyPrefix is the user's key namespace, instead of the cluster's
+	KeyPrefix string `yaml:"keyPrefix"`
+}
+
+// Access are CIDR blocks, such as 10.0.0.0/8, or single IPs. Deny entries win. When Allow is set, clients that match no
+// allow entry are rejected too
+type Access struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// RateLimits are token buckets, refilled at the given rates with a burst of one second's worth. 0 is unlimited
+type RateLimits struct {
+	// PerClient applies to all the connections from one client IP
+	PerClient Limit `yaml:"perClient"`
+	// PerUser applies to all the connections that sent AUTH with the same username
+	PerUser Limit `yaml:"perUser"`
+	// OverLimit is either OverLimitDelay, the default, which holds commands back until the limit allows them, or
+	// OverLimitReject, which replies with an error instead of forwarding them
+	OverLimit string `yaml:"overLimit"`
+}
+
+type Limit struct {
+	CommandsPerSecond float64 `yaml:"commandsPerSecond"`
+	BytesPerSecond    float64 `yaml:"bytesPerSecond"`
+}
+
+const (
+	OverLimitDelay  = "delay"
+	OverLimitReject = "reject"
+)
+
+// Sentinel proxies standalone masters and replicas found through Sentinel instead of a Redis Cluster. When Masters is set,
+// clusterAddr is the address of any one of the Sentinels
+type Sentinel struct {
+	// Masters are the master names, as in the Sentinel configuration, whose servers are proxied
+	Masters []string `yaml:"masters"`
+	// Credentials are used to AUTH with the Sentinels. The top level credentials are used for the masters and replicas
+	Credentials Credentials `yaml:"credentials"`
+}
+
+// IsEnabled is true if the cluster is Sentinel-managed
+func (s Sentinel) IsEnabled() bool {
+	return len(s.Masters) != 0
+}
+
+// Horizon binds every node port on BindHost and advertises PublicHost to the clients that connect through it
+type Horizon struct {
+	BindHost   string `yaml:"bindHost"`
+	PublicHost string `yaml:"publicHost"`
+}
+
+// Ports controls which local port each cluster node is proxied on
+type Ports struct {
+	// Static pins nodes to fixed ports so that firewall rules and service definitions survive restarts
+	Static []StaticPort `yaml:"static"`
+	// RangeMin and RangeMax bound the ports handed to nodes without a static port. When unset, ports are handed out starting at the listenAddr port
+	RangeMin uint16 `yaml:"rangeMin"`
+	RangeMax uint16 `yaml:"rangeMax"`
+}
+
+// StaticPort maps one node to a port. Set either NodeId, the 40 character cluster node ID, or Addr, the node's private HOST_OR_IP:PORT
+type StaticPort struct {
+	NodeId string `yaml:"nodeId"`
+	Addr   string `yaml:"addr"`
+	Port   uint16 `yaml:"port"`
+}
+
+type Timeouts struct {
+	ClientIdle   time.Duration `yaml:"clientIdle"`
+	BackendRead  time.Duration `yaml:"backendRead"`
+	Write        time.Duration `yaml:"write"`
+	TCPKeepAlive time.Duration `yaml:"tcpKeepAlive"`
+}
+
+// Credentials are what the proxy uses to AUTH against the cluster nodes. Leave Username blank to use the default user
+type Credentials struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type TLS struct {
+	// Listener terminates TLS from clients on every listener when CertFile and KeyFile are set
+	Listener ListenerTLS `yaml:"listener"`
+	// Cluster enables TLS when the proxy dials the cluster nodes
+	Cluster ClusterTLS `yaml:"cluster"`
+}
+
+type ListenerTLS struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+type ClusterTLS struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"caFile"`
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// Defaults are used for any setting that is not in the config file or given as a flag or environment variable
+func Defaults() Config {
+	return Config{
+		NumberOfBuffers:          100,
+		MaxConcurrentConnections: 100,
+		ReadBufferByteSize:       16384, // 16KB
+		Timeouts: Timeouts{
+			TCPKeepAlive: 30 * time.Second,
+		},
+		TopologyRefreshInterval: 30 * time.Second,
+	}
+}
+
+// Load reads the YAML file at path on top of the defaults. Unknown keys are an error so that typos do not go unnoticed
+func Load(path string) (cfg Config, err error) {
+	cfg = Defaults()
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		return cfg, fmt.Errorf("unable to read config file '%s': only YAML config files are supported", path)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("unable to read config file '%s': %w", path, err)
+	}
+	err = yaml.UnmarshalStrict(contents, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("unable to parse config file '%s': %w", path, err)
+	}
+	return
+}
+
+// ClusterList returns the clusters to proxy. Without a clusters section, the top level settings describe a single cluster named DefaultClusterName
+func (c Config) ClusterList() []Cluster {
+	if len(c.Clusters) == 0 {
+		return []Cluster{{
+			Name:        DefaultClusterName,
+			ListenAddr:  c.ListenAddr,
+			RouteAddr:   c.RouteAddr,
+			ClusterAddr: c.ClusterAddr,
+			PublicHost:  c.PublicHost,
+			Credentials: c.Credentials,
+			Ports:       c.Ports,
+			Horizons:    c.Horizons,
+			Sentinel:    c.Sentinel,
+			KeyPrefix:   c.KeyPrefix,
+			ScriptNode:  c.ScriptNode,
+		}}
+	}
+	clusters := make([]Cluster, len(c.Clusters))
+	for i, cluster := range c.Clusters {
+		if !cluster.Credentials.IsSet() {
+			cluster.Credentials = c.Credentials
+		}
+		if len(cluster.KeyPrefix) == 0 {
+			cluster.KeyPrefix = c.KeyPrefix
+		}
+		if len(cluster.ScriptNode) == 0 {
+			cluster.ScriptNode = c.ScriptNode
+		}
+		clusters[i] = cluster
+	}
+	return clusters
+}
+
+// IsSet is true if either a username or a password was given
+func (c Credentials) IsSet() bool {
+	return len(c.Username) != 0 || len(c.Password) != 0
+}
+
+// Validate checks the settings once everything has been merged. All problems are reported together
+func (c Config) Validate() error {
+	problems := make([]string, 0, 4)
+	if len(c.Clusters) == 0 {
+		problems = append(problems, c.ClusterList()[0].validate("")...)
+	} else {
+		problems = append(problems, c.validateClusters()...)
+	}
+	if c.NumberOfBuffers < 2 {
+		problems = append(problems, fmt.Sprintf("numberOfBuffers must be at least 2, but was %d", c.NumberOfBuffers))
+	}
+	if c.ReadBufferByteSize <= 0 {
+		problems = append(problems, fmt.Sprintf("readBufferByteSize must be positive, but was %d", c.ReadBufferByteSize))
+	}
+	if c.Timeouts.ClientIdle < 0 || c.Timeouts.BackendRead < 0 || c.Timeouts.Write < 0 || c.Timeouts.TCPKeepAlive < 0 {
+		problems = append(problems, "timeouts cannot be negative")
+	}
+	if (len(c.TLS.Listener.CertFile) == 0) != (len(c.TLS.Listener.KeyFile) == 0) {
+		problems = append(problems, "tls.listener.certFile and tls.listener.keyFile must be set together")
+	}
+	problems = append(problems, c.Commands.validate("commands")...)
+	problems = append(problems, c.Access.validate()...)
+	problems = append(problems, c.validateUsers()...)
+	if c.RateLimits.PerClient.CommandsPerSecond < 0 || c.RateLimits.PerClient.BytesPerSecond < 0 || c.RateLimits.PerUser.CommandsPerSecond < 0 || c.RateLimits.PerUser.BytesPerSecond < 0 {
+		problems = append(problems, "rateLimits cannot be negative")
+	}
+	if c.RateLimits.OverLimit != "" && c.RateLimits.OverLimit != OverLimitDelay && c.RateLimits.OverLimit != OverLimitReject {
+		problems = append(problems, fmt.Sprintf("rateLimits.overLimit must be '%s' or '%s', but was '%s'", OverLimitDelay, OverLimitReject, c.RateLimits.OverLimit))
+	}
+	if c.TopologyRefreshInterval < 0 {
+		problems = append(problems, "topologyRefreshInterval cannot be negative")
+	}
+	if len(problems) != 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (c Commands) validate(prefix string) (problems []string) {
+	for i, rule := range c.Allow {
+		if words := len(strings.Fields(rule)); words == 0 || words > 2 {
+			problems = append(problems, fmt.Sprintf("%s.allow[%d] '%s' must be a command, optionally followed by a subcommand", prefix, i, rule))
+		}
+	}
+	for i, rule := range c.Deny {
+		if words := len(strings.Fields(rule)); words == 0 || words > 2 {
+			problems = append(problems, fmt.Sprintf("%s.deny[%d] '%s' must be a command, optionally followed by a subcommand", prefix, i, rule))
+		}
+	}
+	return
+}
+
+func (c Config) validateUsers() (problems []string) {
+	seenNames := make(map[string]bool)
+	for i, user := range c.Users {
+		prefix := fmt.Sprintf("users[%d]", i)
+		if len(user.Name) == 0 {
+			problems = append(problems, prefix+".name is required")
+		} else if seenNames[user.Name] {
+			problems = append(problems, fmt.Sprintf("%s.name '%s' is used by another user", prefix, user.Name))
+		}
+		seenNames[user.Name] = true
+		if _, err := hex.DecodeString(user.PasswordSHA256); err != nil || len(user.PasswordSHA256) != sha256.Size*2 {
+			problems = append(problems, fmt.Sprintf("%s.passwordSha256 must be %d hex characters", prefix, sha256.Size*2))
+		}
+		if len(user.Backend.Username) != 0 && len(user.Backend.Password) == 0 {
+			problems = append(problems, prefix+".backend.password is required when backend.username is set")
+		}
+		problems = append(problems, user.Commands.validate(prefix+".commands")...)
+		problems = append(problems, validateKeyPrefix(prefix+".keyPrefix", user.KeyPrefix)...)
+		for j, pattern := range user.Keys {
+			if len(pattern) == 0 {
+				problems = append(problems, fmt.Sprintf("%s.keys[%d] cannot be empty", prefix, j))
+			}
+		}
+	}
+	return
+}
+
+func (a Access) validate() (problems []string) {
+	for i, entry := range a.Allow {
+		if !isIPOrCIDR(entry) {
+			problems = append(problems, fmt.Sprintf("access.allow[%d] '%s' must be an IP address or CIDR block", i, entry))
+		}
+	}
+	for i, entry := range a.Deny {
+		if !isIPOrCIDR(entry) {
+			problems = append(problems, fmt.Sprintf("access.deny[%d] '%s' must be an IP address or CIDR block", i, entry))
+		}
+	}
+	return
+}
+
+func isIPOrCIDR(entry string) bool {
+	if _, _, err := net.ParseCIDR(entry); err == nil {
+		return true
+	}
+	return net.ParseIP(entry) != nil
+}
+
+// validateClusters checks each cluster, and that the clusters do not compete for the same ports
+func (c Config) validateClusters() (problems []string) {
+	if len(c.ListenAddr) != 0 || len(c.RouteAddr) != 0 || len(c.ClusterAddr) != 0 || len(c.PublicHost) != 0 || len(c.Horizons) != 0 || len(c.Ports.Static) != 0 || c.Ports.RangeMin != 0 || c.Ports.RangeMax != 0 || c.Sentinel.IsEnabled() {
+		problems = append(problems, "listenAddr, routeAddr, clusterAddr, publicHost, ports, horizons and sentinel are set on each cluster when clusters are listed")
+	}
+	seenNames := make(map[string]bool)
+	staticPorts := make(map[uint16]string)
+	for i, cluster := range c.ClusterList() {
+		prefix := fmt.Sprintf("clusters[%d].", i)
+		if len(cluster.Name) == 0 {
+			problems = append(problems, prefix+"name is required")
+		} else if seenNames[cluster.Name] {
+			problems = append(problems, fmt.Sprintf("%sname '%s' is used more than once", prefix, cluster.Name))
+		}
+		seenNames[cluster.Name] = true
+		problems = append(problems, cluster.validate(prefix)...)
+
+		// without a range, each cluster counts up from its listenAddr port to 65535 and would take the ports of the next cluster
+		if len(c.Clusters) > 1 && cluster.Ports.RangeMin == 0 {
+			problems = append(problems, prefix+"ports.rangeMin and ports.rangeMax are required when more than one cluster is listed")
+		}
+		for j, other := range c.Clusters[:i] {
+			if other.Ports.RangeMin != 0 && cluster.Ports.RangeMin != 0 && cluster.Ports.RangeMin <= other.Ports.RangeMax && other.Ports.RangeMin <= cluster.Ports.RangeMax {
+				problems = append(problems, fmt.Sprintf("%sports range %d-%d overlaps clusters[%d]", prefix, cluster.Ports.RangeMin, cluster.Ports.RangeMax, j))
+			}
+		}
+		for _, static := range cluster.Ports.Static {
+			if owner, ok := staticPorts[static.Port]; ok && owner != cluster.Name {
+				problems = append(problems, fmt.Sprintf("%sports.static port %d is also pinned by cluster '%s'", prefix, static.Port, owner))
+			}
+			staticPorts[static.Port] = cluster.Name
+		}
+	}
+	return
+}
+
+// validate checks the settings of one cluster. prefix is prepended to the setting names in the problems
+func (c Cluster) validate(prefix string) (problems []string) {
+	if len(c.ListenAddr) == 0 {
+		problems = append(problems, prefix+"listenAddr is required")
+	} else if _, err := ip_map.NewHostWithPortFromString(c.ListenAddr); err != nil {
+		problems = append(problems, fmt.Sprintf("%slistenAddr '%s' must be HOST_OR_IP:PORT: %s", prefix, c.ListenAddr, err))
+	}
+	if len(c.RouteAddr) != 0 {
+		if _, err := ip_map.NewHostWithPortFromString(c.RouteAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("%srouteAddr '%s' must be HOST_OR_IP:PORT: %s", prefix, c.RouteAddr, err))
+		} else if c.Sentinel.IsEnabled() {
+			problems = append(problems, prefix+"routeAddr cannot be used with sentinel")
+		}
+	}
+	if len(c.ScriptNode) != 0 {
+		if _, err := ip_map.NewHostWithPortFromString(c.ScriptNode); err != nil {
+			problems = append(problems, fmt.Sprintf("%sscriptNode '%s' must be HOST_OR_IP:PORT: %s", prefix, c.ScriptNode, err))
+		}
+	}
+	if len(c.ClusterAddr) == 0 {
+		problems = append(problems, prefix+"clusterAddr is required")
+	} else if _, err := ip_map.NewHostWithPortFromString(c.ClusterAddr); err != nil {
+		problems = append(problems, fmt.Sprintf("%sclusterAddr '%s' must be HOST_OR_IP:PORT: %s", prefix, c.ClusterAddr, err))
+	}
+	if len(c.PublicHost) == 0 {
+		problems = append(problems, prefix+"publicHost is required")
+	}
+	if len(c.Credentials.Username) != 0 && len(c.Credentials.Password) == 0 {
+		problems = append(problems, prefix+"credentials.password is required when credentials.username is set")
+	}
+	if len(c.Sentinel.Credentials.Username) != 0 && len(c.Sentinel.Credentials.Password) == 0 {
+		problems = append(problems, prefix+"sentinel.credentials.password is required when sentinel.credentials.username is set")
+	}
+	seenMasters := make(map[string]bool)
+	for i, master := range c.Sentinel.Masters {
+		if len(master) == 0 {
+			problems = append(problems, fmt.Sprintf("%ssentinel.masters[%d] is empty", prefix, i))
+		} else if seenMasters[master] {
+			problems = append(problems, fmt.Sprintf("%ssentinel.masters[%d] '%s' is listed more than once", prefix, i, master))
+		}
+		seenMasters[master] = true
+	}
+	problems = append(problems, validateKeyPrefix(prefix+"keyPrefix", c.KeyPrefix)...)
+	problems = append(problems, c.validateHorizons(prefix)...)
+	problems = append(problems, c.Ports.validate(prefix)...)
+	return
+}
+
+// validateKeyPrefix rejects braces, which would change the hash tag of prefixed keys and so their slot
+func validateKeyPrefix(field, keyPrefix string) (problems []string) {
+	if strings.ContainsAny(keyPrefix, "{}") {
+		problems = append(problems, fmt.Sprintf("%s '%s' cannot contain '{' or '}'", field, keyPrefix))
+	}
+	return
+}
+
+func (c Cluster) validateHorizons(prefix string) (problems []string) {
+	if len(c.Horizons) == 0 {
+		return
+	}
+	listenAddr, err := ip_map.NewHostWithPortFromString(c.ListenAddr)
+	if err == nil && isUnspecifiedHost(listenAddr.Host) {
+		problems = append(problems, prefix+"listenAddr must bind to a specific host when horizons are set, otherwise it takes the port on every interface")
+	}
+	seenBindHosts := map[string]bool{listenAddr.Host: true}
+	for i, horizon := range c.Horizons {
+		if len(horizon.BindHost) == 0 || len(horizon.PublicHost) == 0 {
+			problems = append(problems, fmt.Sprintf("%shorizons[%d] must set both bindHost and publicHost", prefix, i))
+			continue
+		}
+		if isUnspecifiedHost(horizon.BindHost) {
+			problems = append(problems, fmt.Sprintf("%shorizons[%d].bindHost '%s' must be a specific host", prefix, i, horizon.BindHost))
+		}
+		if seenBindHosts[horizon.BindHost] {
+			problems = append(problems, fmt.Sprintf("%shorizons[%d].bindHost '%s' is used more than once, including listenAddr", prefix, i, horizon.BindHost))
+		}
+		seenBindHosts[horizon.BindHost] = true
+	}
+	return
+}
+
+func isUnspecifiedHost(host string) bool {
+	return len(host) == 0 || host == "0.0.0.0" || host == "::"
+}
+
+func (p Ports) validate(prefix string) (problems []string) {
+	prefix += "ports."
+	if (p.RangeMin == 0) != (p.RangeMax == 0) {
+		problems = append(problems, prefix+"rangeMin and "+prefix+"rangeMax must be set together")
+	} else if p.RangeMin > p.RangeMax {
+		problems = append(problems, fmt.Sprintf("%srangeMin %d is greater than %srangeMax %d", prefix, p.RangeMin, prefix, p.RangeMax))
+	}
+	seenPorts := make(map[uint16]bool)
+	seenNodes := make(map[string]bool)
+	for i, static := range p.Static {
+		if (len(static.NodeId) == 0) == (len(static.Addr) == 0) {
+			problems = append(problems, fmt.Sprintf("%sstatic[%d] must set exactly one of nodeId or addr", prefix, i))
+		}
+		if len(static.Addr) != 0 {
+			if _, err := ip_map.NewHostWithPortFromString(static.Addr); err != nil {
+				problems = append(problems, fmt.Sprintf("%sstatic[%d].addr '%s' must be HOST_OR_IP:PORT: %s", prefix, i, static.Addr, err))
+			}
+		}
+		if static.Port == 0 {
+			problems = append(problems, fmt.Sprintf("%sstatic[%d].port is required", prefix, i))
+		} else if seenPorts[static.Port] {
+			problems = append(problems, fmt.Sprintf("%sstatic[%d].port %d is used more than once", prefix, i, static.Port))
+		}
+		seenPorts[static.Port] = true
+		node := static.NodeId + static.Addr
+		if seenNodes[node] {
+			problems = append(problems, fmt.Sprintf("%sstatic[%d] maps '%s' more than once", prefix, i, node))
+		}
+		seenNodes[node] = true
+	}
+	return
+}
+
+// ServerConfig loads the listener certificate. Returns nil if listener TLS is not configured
+func (t ListenerTLS) ServerConfig() (*tls.Config, error) {
+	if len(t.CertFile) == 0 {
+		return nil, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load tls.listener certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}}, nil
+}
+
+// ClientConfig builds the configuration used to dial the cluster. Returns nil if cluster TLS is not enabled
+func (t ClusterTLS) ClientConfig() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if len(t.CAFile) != 0 {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read tls.cluster.caFile: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls.cluster.caFile '%s' contained no certificates", t.CAFile)
+		}
+	}
+	return tlsConfig, nil
+}
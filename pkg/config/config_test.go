@@ -0,0 +1,211 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	cases := map[string]struct {
+		input         string
+		expected      Config
+		expectedError bool
+	}{
+		"overrides defaults": {
+			input: "listenAddr: \":8000\"\nclusterAddr: cluster:7000\npublicHost: 127.0.0.1\ntimeouts:\n  clientIdle: 5m\n",
+			expected: func() Config {
+				c := Defaults()
+				c.ListenAddr = ":8000"
+				c.ClusterAddr = "cluster:7000"
+				c.PublicHost = "127.0.0.1"
+				c.Timeouts.ClientIdle = 5 * time.Minute
+				return c
+			}(),
+		},
+		"unknown key": {
+			input:         "listenAdr: \":8000\"\n",
+			expectedError: true,
+		},
+	}
+
+	for caseName, c := range cases {
+		path := writeTempConfig(t, c.input)
+		actual, err := Load(path)
+		_ = os.Remove(path)
+		if c.expectedError {
+			assert.Error(t, err, caseName)
+			continue
+		}
+		assert.NoError(t, err, caseName)
+		assert.Equal(t, c.expected, actual, caseName)
+	}
+
+	_, err := Load("proxy.toml")
+	if assert.Error(t, err, "toml") {
+		assert.Contains(t, err.Error(), "only YAML config files are supported")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Defaults()
+	valid.ListenAddr = ":8000"
+	valid.ClusterAddr = "cluster:7000"
+	valid.PublicHost = "127.0.0.1"
+	assert.NoError(t, valid.Validate())
+
+	horizons := valid
+	horizons.Horizons = []Horizon{{BindHost: "10.8.0.1", PublicHost: "vpn.example.com"}}
+	err := horizons.Validate()
+	if assert.Error(t, err, "listenAddr binds every interface") {
+		assert.Contains(t, err.Error(), "listenAddr must bind to a specific host")
+	}
+	horizons.ListenAddr = "10.0.0.5:8000"
+	assert.NoError(t, horizons.Validate())
+
+	sentinel := valid
+	sentinel.Sentinel.Masters = []string{"mymaster", "mymaster"}
+	err = sentinel.Validate()
+	if assert.Error(t, err, "duplicate master") {
+		assert.Contains(t, err.Error(), "sentinel.masters[1] 'mymaster' is listed more than once")
+	}
+
+	users := valid
+	users.Users = []User{
+		{Name: "orders", PasswordSHA256: "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", Keys: []string{"orders:*"}},
+		{Name: "orders", PasswordSHA256: "secret", Commands: Commands{Deny: []string{"CONFIG SET now"}}},
+	}
+	err = users.Validate()
+	if assert.Error(t, err, "bad users") {
+		assert.Contains(t, err.Error(), "users[1].name 'orders' is used by another user")
+		assert.Contains(t, err.Error(), "users[1].passwordSha256 must be 64 hex characters")
+		assert.Contains(t, err.Error(), "users[1].commands.deny[0]")
+	}
+	users.Users = users.Users[:1]
+	assert.NoError(t, users.Validate())
+
+	keyPrefix := valid
+	keyPrefix.KeyPrefix = "{tenant}:"
+	err = keyPrefix.Validate()
+	if assert.Error(t, err, "braces in the key prefix") {
+		assert.Contains(t, err.Error(), "keyPrefix '{tenant}:' cannot contain '{' or '}'")
+	}
+
+	routing := valid
+	routing.RouteAddr = "7999"
+	err = routing.Validate()
+	if assert.Error(t, err, "route address without a port") {
+		assert.Contains(t, err.Error(), "routeAddr '7999' must be HOST_OR_IP:PORT")
+	}
+	routing.RouteAddr = ":7999"
+	routing.Sentinel.Masters = []string{"mymaster"}
+	err = routing.Validate()
+	if assert.Error(t, err, "routing with sentinel") {
+		assert.Contains(t, err.Error(), "routeAddr cannot be used with sentinel")
+	}
+
+	scripts := valid
+	scripts.ScriptNode = "10.0.0.1"
+	err = scripts.Validate()
+	if assert.Error(t, err, "script node without a port") {
+		assert.Contains(t, err.Error(), "scriptNode '10.0.0.1' must be HOST_OR_IP:PORT")
+	}
+	scripts.ScriptNode = "10.0.0.1:7000"
+	assert.NoError(t, scripts.Validate())
+
+	missing := Defaults()
+	err = missing.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "listenAddr is required")
+		assert.Contains(t, err.Error(), "clusterAddr is required")
+		assert.Contains(t, err.Error(), "publicHost is required")
+	}
+}
+
+func TestValidateClusters(t *testing.T) {
+	cases := map[string]struct {
+		clusters []Cluster
+		expected []string
+	}{
+		"separate ranges": {
+			clusters: []Cluster{
+				{Name: "cache", ListenAddr: ":8000", ClusterAddr: "cache:7000", PublicHost: "127.0.0.1", Ports: Ports{RangeMin: 8000, RangeMax: 8099}},
+				{Name: "sessions", ListenAddr: ":8100", ClusterAddr: "sessions:7000", PublicHost: "127.0.0.1", Ports: Ports{RangeMin: 8100, RangeMax: 8199}},
+			},
+		},
+		"overlapping ranges and names": {
+			clusters: []Cluster{
+				{Name: "cache", ListenAddr: ":8000", ClusterAddr: "cache:7000", PublicHost: "127.0.0.1", Ports: Ports{RangeMin: 8000, RangeMax: 8099}},
+				{Name: "cache", ListenAddr: ":8050", ClusterAddr: "sessions:7000", PublicHost: "127.0.0.1", Ports: Ports{RangeMin: 8050, RangeMax: 8149}},
+			},
+			expected: []string{
+				"clusters[1].name 'cache' is used more than once",
+				"clusters[1].ports range 8050-8149 overlaps clusters[0]",
+			},
+		},
+		"missing range": {
+			clusters: []Cluster{
+				{Name: "cache", ListenAddr: ":8000", ClusterAddr: "cache:7000", PublicHost: "127.0.0.1"},
+				{Name: "sessions", ListenAddr: ":8100", ClusterAddr: "sessions:7000", Ports: Ports{RangeMin: 8100, RangeMax: 8199}},
+			},
+			expected: []string{
+				"clusters[0].ports.rangeMin and ports.rangeMax are required",
+				"clusters[1].publicHost is required",
+			},
+		},
+	}
+
+	for caseName, c := range cases {
+		cfg := Defaults()
+		cfg.Clusters = c.clusters
+		err := cfg.Validate()
+		if len(c.expected) == 0 {
+			assert.NoError(t, err, caseName)
+			continue
+		}
+		if assert.Error(t, err, caseName) {
+			for _, expected := range c.expected {
+				assert.Contains(t, err.Error(), expected, caseName)
+			}
+		}
+	}
+}
+
+func TestClusterList(t *testing.T) {
+	single := Defaults()
+	single.ListenAddr = ":8000"
+	single.ClusterAddr = "cluster:7000"
+	single.PublicHost = "127.0.0.1"
+	assert.Equal(t, []Cluster{{Name: DefaultClusterName, ListenAddr: ":8000", ClusterAddr: "cluster:7000", PublicHost: "127.0.0.1"}}, single.ClusterList())
+
+	multi := Defaults()
+	multi.Credentials = Credentials{Password: "shared"}
+	multi.KeyPrefix = "shared:"
+	multi.ScriptNode = "10.0.0.1:7000"
+	multi.Clusters = []Cluster{
+		{Name: "cache"},
+		{Name: "sessions", Credentials: Credentials{Username: "sessions", Password: "own"}, KeyPrefix: "sessions:", ScriptNode: "10.0.1.1:7000"},
+	}
+	clusters := multi.ClusterList()
+	assert.Equal(t, Credentials{Password: "shared"}, clusters[0].Credentials)
+	assert.Equal(t, Credentials{Username: "sessions", Password: "own"}, clusters[1].Credentials)
+	assert.Equal(t, "shared:", clusters[0].KeyPrefix)
+	assert.Equal(t, "sessions:", clusters[1].KeyPrefix)
+	assert.Equal(t, "10.0.0.1:7000", clusters[0].ScriptNode)
+	assert.Equal(t, "10.0.1.1:7000", clusters[1].ScriptNode)
+}
+
+func writeTempConfig(t *testing.T, contents string) string {
+	file, err := ioutil.TempFile("", "redis-cluster-proxy-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	_, err = file.WriteString(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
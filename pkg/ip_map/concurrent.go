@@ -2,10 +2,14 @@ package ip_map
 
 import "sync"
 
+// Concurrent maps local ports to the cluster nodes they proxy to. Nodes are tracked by their cluster node ID when it is known,
+// so that a node that comes back at a new address keeps its local port
 type Concurrent struct {
 	mu            *sync.RWMutex
 	localToRemote map[uint16]HostWithPort
 	remoteToLocal map[HostWithPort]uint16
+	nodeToLocal   map[string]uint16
+	localToNode   map[uint16]string
 }
 
 func NewConcurrent() *Concurrent {
@@ -13,14 +17,62 @@ func NewConcurrent() *Concurrent {
 		mu:            &sync.RWMutex{},
 		localToRemote: make(map[uint16]HostWithPort),
 		remoteToLocal: make(map[HostWithPort]uint16),
+		nodeToLocal:   make(map[string]uint16),
+		localToNode:   make(map[uint16]string),
 	}
 }
 
 func (c *Concurrent) Create(remote HostWithPort, local uint16) {
+	c.CreateNode("", remote, local)
+}
+
+// CreateNode maps local to the node with the ID nodeId at remote. An empty nodeId maps by address only
+func (c *Concurrent) CreateNode(nodeId string, remote HostWithPort, local uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.localToRemote[local] = remote
+	c.remoteToLocal[remote] = local
+	if len(nodeId) != 0 {
+		if oldNodeId, exists := c.localToNode[local]; exists && oldNodeId != nodeId {
+			// a replacement node took over the port
+			delete(c.nodeToLocal, oldNodeId)
+		}
+		c.nodeToLocal[nodeId] = local
+		c.localToNode[local] = nodeId
+	}
+}
+
+// MoveNode points the local port of an already mapped node at its new address. Returns false if the node has no mapping
+func (c *Concurrent) MoveNode(nodeId string, remote HostWithPort) (local uint16, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	local, ok = c.nodeToLocal[nodeId]
+	if !ok {
+		return
+	}
+	oldRemote := c.localToRemote[local]
+	if oldLocal, exists := c.remoteToLocal[oldRemote]; exists && oldLocal == local {
+		delete(c.remoteToLocal, oldRemote)
+	}
 	c.localToRemote[local] = remote
 	c.remoteToLocal[remote] = local
+	return
+}
+
+// Delete removes every mapping for the local port
+func (c *Concurrent) Delete(local uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if remote, ok := c.localToRemote[local]; ok {
+		if remoteLocal, exists := c.remoteToLocal[remote]; exists && remoteLocal == local {
+			delete(c.remoteToLocal, remote)
+		}
+		delete(c.localToRemote, local)
+	}
+	if nodeId, ok := c.localToNode[local]; ok {
+		delete(c.nodeToLocal, nodeId)
+		delete(c.localToNode, local)
+	}
 }
 
 func (c *Concurrent) LocalToRemote(localAddr uint16) (remote HostWithPort, ok bool) {
@@ -37,6 +89,20 @@ func (c *Concurrent) RemoteToLocal(remoteAddr HostWithPort) (local uint16, ok bo
 	return
 }
 
+func (c *Concurrent) NodeToLocal(nodeId string) (local uint16, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	local, ok = c.nodeToLocal[nodeId]
+	return
+}
+
+func (c *Concurrent) LocalToNode(local uint16) (nodeId string, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	nodeId, ok = c.localToNode[local]
+	return
+}
+
 func (c *Concurrent) SnapshotLocalsToRemotes() (ret map[uint16]HostWithPort) {
 	ret = make(map[uint16]HostWithPort)
 	c.mu.RLock()
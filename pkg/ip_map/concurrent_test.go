@@ -0,0 +1,28 @@
+package ip_map
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMoveNode(t *testing.T) {
+	lookup := NewConcurrent()
+	oldAddr := HostWithPort{Host: "10.0.0.1", Port: 7000}
+	newAddr := HostWithPort{Host: "10.0.0.9", Port: 7000}
+	lookup.CreateNode("901e06d850fe7a21253fbb200b5bdd55d3286848", oldAddr, 8000)
+
+	local, ok := lookup.MoveNode("901e06d850fe7a21253fbb200b5bdd55d3286848", newAddr)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(8000), local)
+
+	remote, _ := lookup.LocalToRemote(8000)
+	assert.Equal(t, newAddr, remote)
+	local, ok = lookup.RemoteToLocal(newAddr)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(8000), local)
+	_, ok = lookup.RemoteToLocal(oldAddr)
+	assert.False(t, ok, "old address no longer maps to the port")
+
+	_, ok = lookup.MoveNode("unknown", newAddr)
+	assert.False(t, ok)
+}
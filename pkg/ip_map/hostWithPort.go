@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 )
 
 type HostWithPort struct {
@@ -25,6 +26,28 @@ func NewHostWithPortFromString(hostColonPort string) (hostWithPort HostWithPort,
 	return
 }
 
+// NewHostWithPortFromClusterString parses an address the way Redis Cluster writes them in MOVED and ASK errors and in CLUSTER NODES:
+// HOST:PORT where an IPv6 host is not wrapped in brackets, such as ::1:7000. Bracketed IPv6 hosts are accepted too
+func NewHostWithPortFromClusterString(hostColonPort string) (hostWithPort HostWithPort, err error) {
+	separator := strings.LastIndex(hostColonPort, ":")
+	if separator < 0 {
+		return hostWithPort, fmt.Errorf("address '%s' is missing a colon for the port", hostColonPort)
+	}
+	hostWithPort.Host = strings.TrimSuffix(strings.TrimPrefix(hostColonPort[:separator], "["), "]")
+	portUInt64, err := strconv.ParseUint(hostColonPort[separator+1:], 10, 16)
+	if err != nil {
+		return
+	}
+	hostWithPort.Port = uint16(portUInt64)
+	return
+}
+
+// String formats the address for dialing and listening. IPv6 hosts are wrapped in brackets, such as [::1]:7000
 func (h HostWithPort) String() string {
+	return net.JoinHostPort(h.Host, strconv.Itoa(int(h.Port)))
+}
+
+// ClusterString formats the address the way Redis Cluster does in MOVED and ASK errors: HOST:PORT without brackets around IPv6 hosts
+func (h HostWithPort) ClusterString() string {
 	return fmt.Sprintf("%s:%d", h.Host, h.Port)
 }
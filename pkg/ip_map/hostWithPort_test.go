@@ -0,0 +1,41 @@
+package ip_map
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHostWithPortIPv6(t *testing.T) {
+	cases := map[string]struct {
+		input         string
+		expected      HostWithPort
+		dialString    string
+		clusterString string
+	}{
+		"ipv4": {
+			input:         "172.22.0.2:7000",
+			expected:      HostWithPort{Host: "172.22.0.2", Port: 7000},
+			dialString:    "172.22.0.2:7000",
+			clusterString: "172.22.0.2:7000",
+		},
+		"ipv6 unbracketed": {
+			input:         "fd00::2:7000",
+			expected:      HostWithPort{Host: "fd00::2", Port: 7000},
+			dialString:    "[fd00::2]:7000",
+			clusterString: "fd00::2:7000",
+		},
+		"ipv6 bracketed": {
+			input:         "[fd00::2]:7000",
+			expected:      HostWithPort{Host: "fd00::2", Port: 7000},
+			dialString:    "[fd00::2]:7000",
+			clusterString: "fd00::2:7000",
+		},
+	}
+	for caseName, c := range cases {
+		actual, err := NewHostWithPortFromClusterString(c.input)
+		assert.NoError(t, err, caseName)
+		assert.Equal(t, c.expected, actual, caseName)
+		assert.Equal(t, c.dialString, actual.String(), caseName)
+		assert.Equal(t, c.clusterString, actual.ClusterString(), caseName)
+	}
+}
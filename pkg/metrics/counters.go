@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Counters is a set of named, monotonically increasing counters that are safe to use from many goroutines
+type Counters struct {
+	mu     *sync.RWMutex
+	counts map[string]uint64
+}
+
+func NewCounters() *Counters {
+	return &Counters{
+		mu:     &sync.RWMutex{},
+		counts: make(map[string]uint64),
+	}
+}
+
+func (c *Counters) Incr(name string) {
+	c.Add(name, 1)
+}
+
+func (c *Counters) Add(name string, delta uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[name] += delta
+}
+
+func (c *Counters) Get(name string) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.counts[name]
+}
+
+func (c *Counters) Snapshot() (ret map[string]uint64) {
+	ret = make(map[string]uint64)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for key, value := range c.counts {
+		ret[key] = value
+	}
+	return
+}
+
+// Print writes every counter to the writer, one per line, sorted by name
+func (c *Counters) Print(writer io.Writer) (err error) {
+	snapshot := c.Snapshot()
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err = fmt.Fprintf(writer, "%s: %d\n", name, snapshot[name])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
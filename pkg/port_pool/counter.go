@@ -2,32 +2,100 @@ package port_pool
 
 import (
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
 	"sync"
 )
 
 type Counter interface {
+	// Next hands out the lowest port in the range that is neither in use nor already bound by another process
 	Next() (uint16, error)
+	// Release returns a port to the pool so that it can be handed out again
+	Release(port uint16)
+	// InUse lists the ports that have been handed out and not released, in ascending order
+	InUse() []uint16
+}
+
+// ErrRangeExhausted is returned by Next when every port in the range is in use or bound
+type ErrRangeExhausted struct {
+	Min uint16
+	Max uint16
+}
+
+func (e ErrRangeExhausted) Error() string {
+	return fmt.Sprintf("out of ports: every port in the range %d-%d is in use or already bound", e.Min, e.Max)
 }
 
 type counter struct {
-	mu      *sync.Mutex
-	counter uint16
+	mu    *sync.Mutex
+	min   uint16
+	max   uint16
+	inUse map[uint16]bool
+	// isBound reports ports that another socket is already listening on
+	isBound func(port uint16) bool
 }
 
+// NewCounter hands out ports from startAt up to 65535, checking that they are free on all interfaces
 func NewCounter(startAt uint16) Counter {
+	return NewRangeCounter("", startAt, 65535)
+}
+
+// NewRangeCounter hands out ports from min to max, inclusive. Ports are checked to be free on bindHost before they are handed out
+func NewRangeCounter(bindHost string, min, max uint16) Counter {
 	return &counter{
-		mu:      &sync.Mutex{},
-		counter: startAt,
+		mu:    &sync.Mutex{},
+		min:   min,
+		max:   max,
+		inUse: make(map[uint16]bool),
+		isBound: func(port uint16) bool {
+			return isPortBound(bindHost, port)
+		},
 	}
 }
 
 func (k *counter) Next() (uint16, error) {
 	k.mu.Lock()
 	defer k.mu.Unlock()
-	if 0 == k.counter {
-		return 0, fmt.Errorf("out of ports")
+	if 0 == k.min {
+		return 0, ErrRangeExhausted{Min: k.min, Max: k.max}
+	}
+	// widen to int so that a range ending at 65535 does not wrap
+	for port := int(k.min); port <= int(k.max); port++ {
+		candidate := uint16(port)
+		if k.inUse[candidate] || k.isBound(candidate) {
+			continue
+		}
+		k.inUse[candidate] = true
+		return candidate, nil
+	}
+	return 0, ErrRangeExhausted{Min: k.min, Max: k.max}
+}
+
+func (k *counter) Release(port uint16) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.inUse, port)
+}
+
+func (k *counter) InUse() (ports []uint16) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	ports = make([]uint16, 0, len(k.inUse))
+	for port := range k.inUse {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i] < ports[j]
+	})
+	return
+}
+
+func isPortBound(host string, port uint16) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		return true
 	}
-	ret := k.counter
-	k.counter++
-	return ret, nil
+	_ = listener.Close()
+	return false
 }
@@ -0,0 +1,64 @@
+package port_pool
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newTestCounter(min, max uint16, bound ...uint16) *counter {
+	c := NewRangeCounter("", min, max).(*counter)
+	c.isBound = func(port uint16) bool {
+		for _, b := range bound {
+			if b == port {
+				return true
+			}
+		}
+		return false
+	}
+	return c
+}
+
+func TestRangeCounter(t *testing.T) {
+	counter := newTestCounter(65534, 65535)
+	port, err := counter.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(65534), port)
+	port, err = counter.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(65535), port)
+	_, err = counter.Next()
+	assert.Equal(t, ErrRangeExhausted{Min: 65534, Max: 65535}, err)
+	assert.Contains(t, err.Error(), "65534-65535")
+}
+
+func TestRangeCounterReleaseAndSkip(t *testing.T) {
+	counter := newTestCounter(8000, 8003, 8001)
+	first, _ := counter.Next()
+	second, _ := counter.Next()
+	assert.Equal(t, uint16(8000), first)
+	assert.Equal(t, uint16(8002), second, "bound port is skipped")
+	assert.Equal(t, []uint16{8000, 8002}, counter.InUse())
+
+	counter.Release(first)
+	assert.Equal(t, []uint16{8002}, counter.InUse())
+	reused, err := counter.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(8000), reused, "released port is reused")
+}
+
+func TestStatic(t *testing.T) {
+	static := NewStatic()
+	static.PinNodeId("901e06d850fe7a21253fbb200b5bdd55d3286848", 8000)
+	static.PinAddr("172.22.0.2:7001", 8001)
+
+	port, ok := static.PortFor("901e06d850fe7a21253fbb200b5bdd55d3286848", "172.22.0.2:7001")
+	assert.True(t, ok)
+	assert.Equal(t, uint16(8000), port, "node ID wins over address")
+	port, ok = static.PortFor("other", "172.22.0.2:7001")
+	assert.True(t, ok)
+	assert.Equal(t, uint16(8001), port)
+	_, ok = static.PortFor("other", "172.22.0.2:7002")
+	assert.False(t, ok)
+	assert.True(t, static.IsReserved(8001))
+	assert.False(t, static.IsReserved(8002))
+}
@@ -0,0 +1,41 @@
+package port_pool
+
+// Static pins cluster nodes to fixed local ports, either by cluster node ID or by the node's private HOST:PORT address.
+// A node ID mapping wins over an address mapping for the same node
+type Static struct {
+	byNodeId map[string]uint16
+	byAddr   map[string]uint16
+	reserved map[uint16]bool
+}
+
+func NewStatic() *Static {
+	return &Static{
+		byNodeId: make(map[string]uint16),
+		byAddr:   make(map[string]uint16),
+		reserved: make(map[uint16]bool),
+	}
+}
+
+func (s *Static) PinNodeId(nodeId string, port uint16) {
+	s.byNodeId[nodeId] = port
+	s.reserved[port] = true
+}
+
+func (s *Static) PinAddr(addr string, port uint16) {
+	s.byAddr[addr] = port
+	s.reserved[port] = true
+}
+
+// PortFor returns the pinned port for the node, if it has one
+func (s *Static) PortFor(nodeId, addr string) (port uint16, ok bool) {
+	if port, ok = s.byNodeId[nodeId]; ok {
+		return
+	}
+	port, ok = s.byAddr[addr]
+	return
+}
+
+// IsReserved is true if the port is pinned to a node, so it must not be handed out to any other node
+func (s *Static) IsReserved(port uint16) bool {
+	return s.reserved[port]
+}
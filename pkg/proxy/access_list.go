@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// AccessList decides which client IPs may connect. Entries are CIDR blocks, such as 10.0.0.0/8, or single IPs. A deny entry
+// always wins. When there are allow entries, clients that match none of them are rejected too
+type AccessList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// Metric names for rejected connections
+const (
+	// metricAccessDenied counts clients that matched a deny entry
+	metricAccessDenied = "access.denied"
+	// metricAccessNotAllowed counts clients that matched no allow entry
+	metricAccessNotAllowed = "access.not_allowed"
+)
+
+// NewAccessList parses the allow and deny entries
+func NewAccessList(allow, deny []string) (accessList *AccessList, err error) {
+	accessList = &AccessList{}
+	accessList.allow, err = parseNetworks(allow)
+	if err != nil {
+		return nil, err
+	}
+	accessList.deny, err = parseNetworks(deny)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// parseNetworks parses CIDR blocks. A single IP is a block of one address
+func parseNetworks(entries []string) (networks []*net.IPNet, err error) {
+	networks = make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("'%s' is not an IP address or CIDR block", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, parseErr := net.ParseCIDR(entry)
+		if parseErr != nil {
+			return nil, fmt.Errorf("'%s' is not an IP address or CIDR block: %w", entry, parseErr)
+		}
+		networks = append(networks, network)
+	}
+	return
+}
+
+// IsEmpty is true if there are no entries, so every client may connect
+func (a *AccessList) IsEmpty() bool {
+	return a == nil || (len(a.allow) == 0 && len(a.deny) == 0)
+}
+
+// Check decides if a client at ip may connect. If not, reason says why and metricName is the counter for the rejection
+func (a *AccessList) Check(ip net.IP) (allowed bool, reason string, metricName string) {
+	if a.IsEmpty() {
+		return true, "", ""
+	}
+	for _, network := range a.deny {
+		if network.Contains(ip) {
+			return false, "matches deny entry " + network.String(), metricAccessDenied
+		}
+	}
+	if len(a.allow) == 0 {
+		return true, "", ""
+	}
+	for _, network := range a.allow {
+		if network.Contains(ip) {
+			return true, "", ""
+		}
+	}
+	return false, "matches no allow entry", metricAccessNotAllowed
+}
+
+// SetAccessList replaces the client access list. nil lets every client connect. Only new connections are checked
+func (r *Redis) SetAccessList(accessList *AccessList) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.accessList = accessList
+}
+
+// admitConnection checks a freshly accepted client against the access list in effect, logging and counting rejections
+func (r *Redis) admitConnection(conn net.Conn, accessList *AccessList) bool {
+	if accessList.IsEmpty() {
+		return true
+	}
+	var ip net.IP
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = tcpAddr.IP
+	}
+	allowed, reason, metricName := accessList.Check(ip)
+	if !allowed {
+		r.metrics.Incr(metricName)
+		log.Printf("rejected connection from %s to %s: %s\n", conn.RemoteAddr().String(), conn.LocalAddr().String(), reason)
+	}
+	return allowed
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestAccessListCheck(t *testing.T) {
+	cases := map[string]struct {
+		allow, deny     []string
+		ip              string
+		expectedAllowed bool
+		expectedMetric  string
+	}{
+		"no entries": {
+			ip:              "203.0.113.7",
+			expectedAllowed: true,
+		},
+		"allowed block": {
+			allow:           []string{"10.0.0.0/8"},
+			ip:              "10.1.2.3",
+			expectedAllowed: true,
+		},
+		"not allowed": {
+			allow:          []string{"10.0.0.0/8"},
+			ip:             "203.0.113.7",
+			expectedMetric: metricAccessNotAllowed,
+		},
+		"deny wins": {
+			allow:          []string{"10.0.0.0/8"},
+			deny:           []string{"10.0.5.1"},
+			ip:             "10.0.5.1",
+			expectedMetric: metricAccessDenied,
+		},
+		"ipv4 mapped client": {
+			deny:           []string{"192.168.0.0/16"},
+			ip:             "::ffff:192.168.1.1",
+			expectedMetric: metricAccessDenied,
+		},
+		"ipv6 block": {
+			allow:           []string{"fd00::/8"},
+			ip:              "fd00::2",
+			expectedAllowed: true,
+		},
+	}
+
+	for caseName, c := range cases {
+		accessList, err := NewAccessList(c.allow, c.deny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowed, _, metricName := accessList.Check(net.ParseIP(c.ip))
+		assert.Equal(t, c.expectedAllowed, allowed, caseName)
+		assert.Equal(t, c.expectedMetric, metricName, caseName)
+	}
+
+	_, err := NewAccessList([]string{"10.0.0.0/33"}, nil)
+	assert.Error(t, err)
+}
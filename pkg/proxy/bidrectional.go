@@ -1,52 +1,243 @@
 package proxy
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"net"
 	"redis_cluster_proxy/pkg/redis"
+	"time"
 )
 
 type RewriteFunc func(componenterIn redis.Componenter) (componenterOut redis.Componenter)
 
-// Bidirectional creates a two-way proxy, buffering data. BLocks until one or both sides are closed
-func Bidirectional(client, cluster net.Conn, intercept RewriteFunc, reWrite RewriteFunc, buffer1, buffer2 []byte, doneChan chan<- error, debugOutputEnabled bool) {
-	go halfDuplex(client, cluster, intercept, reWrite, buffer1, doneChan, "cli["+client.LocalAddr().String()+"] -> cluster["+cluster.RemoteAddr().String()+"]", debugOutputEnabled)
-	go halfDuplex(cluster, client, intercept, reWrite, buffer2, doneChan, "cluster["+cluster.RemoteAddr().String()+"] -> cli["+client.LocalAddr().String()+"]", debugOutputEnabled)
+// farewellWriteTimeout bounds the write of a farewell to a client when there is no write timeout
+const farewellWriteTimeout = time.Second
+
+// AdmitFunc is called with every command read from the client, and its size in bytes, before it is intercepted or forwarded.
+// It may block to slow the client down. It returns either the command to carry on with, which may be a replacement, or a
+// reply to send back to the client instead of forwarding anything
+type AdmitFunc func(componenter redis.Componenter, byteCount int) (forward, reply redis.Componenter)
+
+// chainAdmit runs each AdmitFunc in turn on the command the previous one forwarded, stopping at the first reply
+func chainAdmit(admits ...AdmitFunc) AdmitFunc {
+	return func(componenter redis.Componenter, byteCount int) (forward, reply redis.Componenter) {
+		forward = componenter
+		for _, admit := range admits {
+			forward, reply = admit(forward, byteCount)
+			if reply != nil {
+				return nil, reply
+			}
+		}
+		return
+	}
+}
+
+// ClientHooks follow one client connection. Unlike intercept and reWrite, which see both directions, each hook only sees one.
+// Any of them may be nil
+type ClientHooks struct {
+	// Admit is called with every command from the client, before intercept
+	Admit AdmitFunc
+	// Forwarded is called with every command just before it is sent to the cluster, after intercept let it through
+	Forwarded func(command redis.Componenter)
+	// Replied is called with every reply from the cluster and the command it answers, before Reply. Pub/Sub messages are not
+	// replies. An error closes the connection once the reply is written
+	Replied func(command, reply redis.Componenter) error
+	// Reply rewrites every component from the cluster, before reWrite. A nil result drops the component
+	Reply RewriteFunc
+	// Farewell is called once the cluster connection ends, for a last reply to send the client, such as why the proxy
+	// disconnected it. nil sends nothing
+	Farewell func() redis.Componenter
+	// BlockTimeout tells how long a command may block on the node before it replies, so that the backend read timeout
+	// counts from the end of it. A zero timeout blocks until there is something to reply
+	BlockTimeout func(command redis.Componenter) (timeout time.Duration, blocks bool)
+}
+
+// replyWait is how much longer than the backend read timeout the reply to command may take. Negative is no limit
+func (h ClientHooks) replyWait(command redis.Componenter) time.Duration {
+	if h.BlockTimeout == nil {
+		return 0
+	}
+	timeout, blocks := h.BlockTimeout(command)
+	switch {
+	case !blocks:
+		return 0
+	case timeout == 0:
+		return -1
+	}
+	return timeout
 }
 
-func halfDuplex(read, write net.Conn, intercept RewriteFunc, reWrite RewriteFunc, buffer []byte, doneChan chan<- error, label string, debugOutputEnabled bool) {
+// Bidirectional creates a two-way proxy, buffering data. BLocks until one or both sides are closed.
+// debugOutputEnabled is checked for every component so that debugging can be toggled on connections that are already open
+func Bidirectional(client, cluster net.Conn, intercept RewriteFunc, reWrite RewriteFunc, hooks ClientHooks, buffer1, buffer2 []byte, doneChan chan<- error, timeouts Timeouts, debugOutputEnabled func() bool) {
+	pending := newPendingReplies(client, cluster, timeouts)
+	go halfDuplex(client, cluster, intercept, reWrite, buffer1, doneChan, halfDuplexSide{
+		readTimeout: timeouts.ClientIdle,
+		readErr:     ErrClientIdleTimeout,
+		write:       timeouts.Write,
+		admit:       hooks.Admit,
+		forwarded:   hooks.Forwarded,
+		local:       pending.Local,
+		onRead:      func(redis.Componenter) error { return nil },
+		onWrite: func(command redis.Componenter) {
+			pending.Sent(command, hooks.replyWait(command))
+		},
+		done: func() error { return nil },
+	}, "cli["+client.LocalAddr().String()+"] -> cluster["+cluster.RemoteAddr().String()+"]", debugOutputEnabled)
+	go halfDuplex(cluster, client, intercept, reWrite, buffer2, doneChan, halfDuplexSide{
+		readErr: ErrBackendReadTimeout,
+		write:   timeouts.Write,
+		rewrite: hooks.Reply,
+		onRead: func(reply redis.Componenter) error {
+			if command, isReply := pending.Received(reply); isReply && hooks.Replied != nil {
+				return hooks.Replied(command, reply)
+			}
+			return nil
+		},
+		onWrite:  func(redis.Componenter) {},
+		done:     pending.Flush,
+		farewell: hooks.Farewell,
+	}, "cluster["+cluster.RemoteAddr().String()+"] -> cli["+client.LocalAddr().String()+"]", debugOutputEnabled)
+}
+
+// halfDuplexSide describes the timeouts for one direction of the proxy
+type halfDuplexSide struct {
+	// readTimeout is armed before every read, if set. The cluster side leaves this unset as its deadline is managed by pendingReplies
+	readTimeout time.Duration
+	// readErr is the reason reported when a read from this side times out
+	readErr error
+	write   time.Duration
+	// admit, if set, is called with every component read from this side and its size. It may replace the component, or reply instead of forwarding
+	admit AdmitFunc
+	// forwarded, if set, is called with every component just before it is forwarded to the other side
+	forwarded func(componenter redis.Componenter)
+	// rewrite, if set, rewrites every component read from this side before reWrite. nil drops the component
+	rewrite RewriteFunc
+	// local, if set, is offered every reply to this side that did not come from the other side. It returns true if it took
+	// the reply to write later, behind the replies still due from the other side
+	local func(reply redis.Componenter) (queued bool)
+	// onRead is called with every component read from this side. An error ends the connection once the component is forwarded
+	onRead func(componenter redis.Componenter) error
+	// onWrite is called with every component just before it is forwarded to the other side
+	onWrite func(componenter redis.Componenter)
+	// done is called once every component read from this side has been forwarded or dropped
+	done func() error
+	// farewell, if set, is called when reading from this side fails, for a last component to write to the other side
+	farewell func() redis.Componenter
+}
+
+func halfDuplex(read, write net.Conn, intercept RewriteFunc, reWrite RewriteFunc, buffer []byte, doneChan chan<- error, side halfDuplexSide, label string, debugOutputEnabled func() bool) {
 	var interceptedComponent redis.Componenter
 	var componenter redis.Componenter
-	var err error
+	var byteCount int
+	var err, readHookErr error
 	for {
-		componenter, _, err = redis.ComponentFromReader(read, buffer)
+		if side.readTimeout > 0 {
+			_ = read.SetReadDeadline(time.Now().Add(side.readTimeout))
+		}
+		componenter, byteCount, err = redis.ComponentFromReader(read, buffer)
 		if err != nil {
+			if isTimeout(err) {
+				err = fmt.Errorf("%s: %w", label, side.readErr)
+			}
+			sayFarewell(write, side)
 			_ = write.Close()
 			break
 		}
-		interceptedComponent = intercept(componenter)
+		readHookErr = side.onRead(componenter)
+		interceptedComponent = nil
+		if side.admit != nil {
+			componenter, interceptedComponent = side.admit(componenter, byteCount)
+		}
+		if interceptedComponent == nil {
+			interceptedComponent = intercept(componenter)
+		}
 		if interceptedComponent != nil {
-			_, err = redis.ComponentToStream(read, interceptedComponent)
+			if side.local != nil && side.local(interceptedComponent) {
+				continue
+			}
+			setWriteDeadline(read, side.write)
+			err = writeComponent(read, interceptedComponent)
 			if err != nil {
+				err = writeError(label, err)
 				_ = write.Close()
 				_ = read.Close()
 				break
 			}
 			continue
 		}
-		componenter = reWrite(componenter)
-		debugClientIn(label, debugOutputEnabled, componenter)
-		_, err = redis.ComponentToStream(write, componenter)
-		if err != nil {
-			if err == io.EOF {
-				_ = write.Close()
+		if side.rewrite != nil {
+			componenter = side.rewrite(componenter)
+		}
+		if componenter != nil {
+			componenter = reWrite(componenter)
+			if side.forwarded != nil {
+				side.forwarded(componenter)
 			}
+			debugClientIn(label, debugOutputEnabled, componenter)
+			side.onWrite(componenter)
+			setWriteDeadline(write, side.write)
+			err = writeComponent(write, componenter)
+		}
+		if err == nil {
+			err = side.done()
+		}
+		if err == nil {
+			err = readHookErr
+		}
+		if err != nil {
+			err = writeError(label, err)
+			_ = write.Close()
+			_ = read.Close()
 			break
 		}
 	}
 	doneChan <- hideErrors(err)
 }
 
+// writeComponent serializes the component before writing it so that it reaches the socket in a single Write.
+// This way a write from another goroutine, such as a reply the proxy answers itself, cannot land in the middle of it
+func writeComponent(conn net.Conn, componenter redis.Componenter) (err error) {
+	buffer := &bytes.Buffer{}
+	_, err = redis.ComponentToStream(buffer, componenter)
+	if err != nil {
+		return
+	}
+	_, err = conn.Write(buffer.Bytes())
+	return
+}
+
+// sayFarewell writes the farewell of side, if it has one, to conn
+func sayFarewell(conn net.Conn, side halfDuplexSide) {
+	if side.farewell == nil {
+		return
+	}
+	farewell := side.farewell()
+	if farewell == nil {
+		return
+	}
+	if side.write > 0 {
+		setWriteDeadline(conn, side.write)
+	} else {
+		setWriteDeadline(conn, farewellWriteTimeout)
+	}
+	_ = writeComponent(conn, farewell)
+}
+
+func setWriteDeadline(conn net.Conn, timeout time.Duration) {
+	if timeout > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+}
+
+func writeError(label string, err error) error {
+	if isTimeout(err) {
+		return fmt.Errorf("%s: %w", label, ErrWriteTimeout)
+	}
+	return err
+}
+
 func hideErrors(err error) error {
 	if err == io.EOF {
 		return nil
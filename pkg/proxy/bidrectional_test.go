@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"redis_cluster_proxy/pkg/redis"
+	"testing"
+	"time"
+)
+
+func passThrough(componenterIn redis.Componenter) redis.Componenter {
+	return componenterIn
+}
+
+func noIntercept(componenterIn redis.Componenter) redis.Componenter {
+	return nil
+}
+
+func TestBidirectionalTimeouts(t *testing.T) {
+	cases := map[string]struct {
+		timeouts Timeouts
+		hooks    ClientHooks
+		act      func(client, cluster net.Conn)
+		expected error
+	}{
+		"client idle": {
+			timeouts: Timeouts{ClientIdle: 20 * time.Millisecond},
+			act:      func(client, cluster net.Conn) {},
+			expected: ErrClientIdleTimeout,
+		},
+		"backend read": {
+			timeouts: Timeouts{BackendRead: 20 * time.Millisecond},
+			act: func(client, cluster net.Conn) {
+				// swallow the forwarded command but never reply
+				go func() { _, _, _ = redis.ComponentFromReader(cluster, make([]byte, BufferSizeBytes)) }()
+				_, _ = client.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+			},
+			expected: ErrBackendReadTimeout,
+		},
+		"pipelining does not hold off a stuck reply": {
+			timeouts: Timeouts{BackendRead: 50 * time.Millisecond},
+			act: func(client, cluster net.Conn) {
+				go swallow(cluster)
+				for i := 0; i < 100; i++ {
+					if _, err := client.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+						return
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			},
+			expected: ErrBackendReadTimeout,
+		},
+		"blocking command times out after its own timeout": {
+			timeouts: Timeouts{BackendRead: 20 * time.Millisecond},
+			hooks: ClientHooks{BlockTimeout: func(redis.Componenter) (time.Duration, bool) {
+				return 50 * time.Millisecond, true
+			}},
+			act: func(client, cluster net.Conn) {
+				go swallow(cluster)
+				_, _ = client.Write([]byte("*3\r\n$5\r\nBLPOP\r\n$1\r\nk\r\n$4\r\n0.05\r\n"))
+			},
+			expected: ErrBackendReadTimeout,
+		},
+		"write": {
+			timeouts: Timeouts{Write: 20 * time.Millisecond},
+			act: func(client, cluster net.Conn) {
+				// nobody reads from the cluster side of the pipe, so forwarding blocks
+				_, _ = client.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+			},
+			expected: ErrWriteTimeout,
+		},
+	}
+
+	for caseName, c := range cases {
+		clientSide, proxyClientSide := net.Pipe()
+		proxyClusterSide, clusterSide := net.Pipe()
+		doneChan := make(chan error, 2)
+		Bidirectional(proxyClientSide, proxyClusterSide, noIntercept, passThrough, c.hooks, make([]byte, BufferSizeBytes), make([]byte, BufferSizeBytes), doneChan, c.timeouts, func() bool { return false })
+		go c.act(clientSide, clusterSide)
+
+		select {
+		case err := <-doneChan:
+			assert.True(t, errors.Is(err, c.expected), "%s: got %v", caseName, err)
+		case <-time.After(time.Second):
+			t.Errorf("%s: proxy did not time out", caseName)
+		}
+		_ = clientSide.Close()
+		_ = clusterSide.Close()
+	}
+}
+
+func TestBidirectionalBlockingWithoutTimeout(t *testing.T) {
+	clientSide, proxyClientSide := net.Pipe()
+	proxyClusterSide, clusterSide := net.Pipe()
+	defer func() { _ = clientSide.Close() }()
+	defer func() { _ = clusterSide.Close() }()
+	doneChan := make(chan error, 2)
+	hooks := ClientHooks{BlockTimeout: func(redis.Componenter) (time.Duration, bool) {
+		return 0, true
+	}}
+	Bidirectional(proxyClientSide, proxyClusterSide, noIntercept, passThrough, hooks, make([]byte, BufferSizeBytes), make([]byte, BufferSizeBytes), doneChan, Timeouts{BackendRead: 20 * time.Millisecond}, func() bool { return false })
+	go swallow(clusterSide)
+	_, _ = clientSide.Write([]byte("*3\r\n$5\r\nBLPOP\r\n$1\r\nk\r\n$1\r\n0\r\n"))
+
+	select {
+	case err := <-doneChan:
+		t.Errorf("BLPOP 0 was cut off: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// swallow reads the commands forwarded to the cluster and never replies
+func swallow(cluster net.Conn) {
+	buffer := make([]byte, BufferSizeBytes)
+	for {
+		if _, _, err := redis.ComponentFromReader(cluster, buffer); err != nil {
+			return
+		}
+	}
+}
+
+func TestBidirectionalLocalRepliesKeepOrder(t *testing.T) {
+	clientSide, proxyClientSide := net.Pipe()
+	proxyClusterSide, clusterSide := net.Pipe()
+	defer func() { _ = clientSide.Close() }()
+	defer func() { _ = clusterSide.Close() }()
+	doneChan := make(chan error, 2)
+	var replied []string
+	hooks := ClientHooks{
+		Admit: func(command redis.Componenter, _ int) (forward, reply redis.Componenter) {
+			if name, _ := commandName(command); name == "set" {
+				return nil, redis.NewErrorFromString("READONLY")
+			}
+			return command, nil
+		},
+		Replied: func(command, reply redis.Componenter) error {
+			name, _ := commandName(command)
+			replied = append(replied, name)
+			return nil
+		},
+	}
+	Bidirectional(proxyClientSide, proxyClusterSide, noIntercept, passThrough, hooks, make([]byte, BufferSizeBytes), make([]byte, BufferSizeBytes), doneChan, Timeouts{}, func() bool { return false })
+	go func() {
+		buffer := make([]byte, BufferSizeBytes)
+		_, _, _ = redis.ComponentFromReader(clusterSide, buffer)
+		// reply late, after the proxy rejected the SET pipelined behind the GET
+		time.Sleep(20 * time.Millisecond)
+		_, _ = clusterSide.Write([]byte("$1\r\nv\r\n"))
+	}()
+	go func() {
+		_, _ = clientSide.Write([]byte("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"))
+	}()
+
+	buffer := make([]byte, BufferSizeBytes)
+	first, _, err := redis.ComponentFromReader(clientSide, buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "$1\r\nv\r\n", componentToString(t, first))
+	second, _, err := redis.ComponentFromReader(clientSide, buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "-READONLY\r\n", componentToString(t, second))
+	assert.Equal(t, []string{"get"}, replied)
+}
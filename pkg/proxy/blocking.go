@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"time"
+)
+
+// maxReadAhead bounds how much of a client's next commands are read while its blocking command waits
+const maxReadAhead = 64 * 1024
+
+var errClientLeft = errors.New("the client left while its command was blocked")
+
+// blockingCall describes a command that may block on the node, such as BLPOP or XREAD BLOCK
+type blockingCall struct {
+	// timeout is how long the node may block the command. Zero blocks until there is something to reply
+	timeout time.Duration
+	// gone is closed if the client leaves while the command waits. nil when the client is not watched
+	gone <-chan struct{}
+}
+
+func (b *blockingCall) left() bool {
+	if b == nil || b.gone == nil {
+		return false
+	}
+	select {
+	case <-b.gone:
+		return true
+	default:
+		return false
+	}
+}
+
+// blockTimeout returns how long command may block on the node, from the command table. Unknown commands reply at once
+func (r *Redis) blockTimeout(command redisPkg.Componenter) (timeout time.Duration, blocks bool) {
+	args, ok := commandArgs(command)
+	if !ok || len(args) == 0 {
+		return 0, false
+	}
+	spec, known := r.commandTable().Lookup(args)
+	if !known {
+		return 0, false
+	}
+	return spec.BlockTimeout(args)
+}
+
+// clientWatch starts watching the client until stop is called. gone is closed if the client leaves meanwhile
+type clientWatch func() (gone <-chan struct{}, stop func())
+
+// sendBlocking sends a blocking command to the master of slot over a connection of its own, so that clients waiting on
+// blocking commands hold up no one else. A negative slot is any master
+func (r *Redis) sendBlocking(slot int, command redisPkg.Componenter, timeout time.Duration, watch clientWatch) redisPkg.Componenter {
+	addr, ok := r.slotOwner(slot)
+	if !ok {
+		return redisPkg.NewErrorFromString(slotNotServed)
+	}
+	blocking := &blockingCall{timeout: timeout}
+	if watch != nil {
+		var stop func()
+		blocking.gone, stop = watch()
+		defer stop()
+	}
+	return r.sendTo(addr, command, blocking)
+}
+
+// clientReader reads the commands of a client of the routing endpoint. While a blocking command waits, watch reads ahead
+// to notice the client leaving. What it reads is kept for the next commands
+type clientReader struct {
+	conn  net.Conn
+	ahead []byte
+	err   error
+	// left is set when watch saw the client close the connection
+	left bool
+}
+
+func (c *clientReader) Read(p []byte) (n int, err error) {
+	if len(c.ahead) > 0 {
+		n = copy(p, c.ahead)
+		c.ahead = c.ahead[n:]
+		return
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.conn.Read(p)
+}
+
+// watch reads ahead from the client until stop is called. Neither Read nor watch may be called until stop returns
+func (c *clientReader) watch() (gone <-chan struct{}, stop func()) {
+	goneChan := make(chan struct{})
+	if c.err != nil {
+		return goneChan, func() {}
+	}
+	finished := make(chan struct{})
+	// the client is expected to wait for the reply, so the idle timeout does not apply
+	_ = c.conn.SetReadDeadline(time.Time{})
+	go func() {
+		defer close(finished)
+		buffer := make([]byte, 512)
+		for len(c.ahead) < maxReadAhead {
+			n, err := c.conn.Read(buffer)
+			c.ahead = append(c.ahead, buffer[:n]...)
+			if err != nil {
+				if !isTimeout(err) {
+					c.err, c.left = err, true
+					close(goneChan)
+				}
+				return
+			}
+		}
+	}()
+	return goneChan, func() {
+		_ = c.conn.SetReadDeadline(time.Now())
+		<-finished
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRouteBlocking(t *testing.T) {
+	// BLPOP replies once the test releases it, or after a delay for "slow"
+	release := make(chan struct{})
+	node := func(args []string) string {
+		if args[0] != "BLPOP" {
+			return "$1\r\n1\r\n"
+		}
+		if args[1] == "slow" {
+			time.Sleep(150 * time.Millisecond)
+		} else {
+			<-release
+		}
+		return "*2\r\n$3\r\nbar\r\n$1\r\nv\r\n"
+	}
+	cluster := newFakeCluster(map[string]func(args []string) string{"10.0.0.1:7000": node, "10.0.0.2:7000": node})
+	r := newRoutingRedis(cluster)
+	// one connection per node, which a blocked BLPOP would hold if it shared it
+	r.backends = newConnPool(1, cluster.dial)
+	r.blockingBackends = newConnPool(1, cluster.dial)
+	r.SetTimeouts(Timeouts{BackendRead: 50 * time.Millisecond})
+
+	blocked := make(chan string)
+	go func() {
+		blocked <- componentToString(t, r.route(commandFromWords("BLPOP", "bar", "0"), nil))
+	}()
+	assert.Eventually(t, func() bool {
+		return len(cluster.commands("10.0.0.1:7000")) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "$1\r\n1\r\n", componentToString(t, r.route(commandFromWords("GET", "bar"), nil)), "not held up by the blocked BLPOP")
+	close(release)
+	assert.Equal(t, "*2\r\n$3\r\nbar\r\n$1\r\nv\r\n", <-blocked, "a timeout of 0 is not cut short by the backend read timeout")
+
+	reply := r.route(commandFromWords("BLPOP", "slow", "0.2"), nil)
+	assert.Equal(t, "*2\r\n$3\r\nbar\r\n$1\r\nv\r\n", componentToString(t, reply), "the backend read timeout counts from the end of the command's timeout")
+}
+
+func TestRouteBlockingClientLeaves(t *testing.T) {
+	cluster := newFakeCluster(map[string]func(args []string) string{
+		"10.0.0.1:7000": func(args []string) string {
+			time.Sleep(time.Second)
+			return "*2\r\n$3\r\nbar\r\n$1\r\nv\r\n"
+		},
+	})
+	r := newRoutingRedis(cluster)
+	clientSide, proxySide := net.Pipe()
+	reader := &clientReader{conn: proxySide}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = clientSide.Close()
+	}()
+	start := time.Now()
+	r.route(commandFromWords("BLPOP", "bar", "0"), reader.watch)
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "the command is abandoned when the client leaves")
+	assert.True(t, reader.left)
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"fmt"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"strings"
+)
+
+// CommandRules decide which commands clients may run through the proxy. Rules are a command name, such as "FLUSHALL", or a
+// command and its subcommand, such as "CONFIG SET". A deny rule always wins. When there are allow rules, commands that match
+// none of them are rejected too
+type CommandRules struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// Metric names for the command rules. Each is followed by the rule that was hit, such as "commands.deny.config|set"
+const (
+	metricCommandAllowPrefix = "commands.allow."
+	metricCommandDenyPrefix  = "commands.deny."
+	// metricCommandNotAllowed counts commands rejected because they matched no allow rule
+	metricCommandNotAllowed = "commands.not_allowed"
+)
+
+// NewCommandRules parses the allow and deny rules. Names are case insensitive
+func NewCommandRules(allow, deny []string) (rules *CommandRules, err error) {
+	rules = &CommandRules{}
+	rules.allow, err = parseCommandRules(allow)
+	if err != nil {
+		return nil, err
+	}
+	rules.deny, err = parseCommandRules(deny)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func parseCommandRules(rules []string) (parsed map[string]bool, err error) {
+	parsed = make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		words := strings.Fields(rule)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, fmt.Errorf("command rule '%s' must be a command, optionally followed by a subcommand", rule)
+		}
+		parsed[strings.ToLower(strings.Join(words, "|"))] = true
+	}
+	return
+}
+
+// IsEmpty is true if there are no rules, so every command is allowed
+func (c *CommandRules) IsEmpty() bool {
+	return c == nil || (len(c.allow) == 0 && len(c.deny) == 0)
+}
+
+// Check decides if the command may be forwarded. rule is the rule that decided, in the form "command" or "command|subcommand",
+// and is empty if the command was rejected because it matched no allow rule, or allowed because there are no allow rules
+func (c *CommandRules) Check(command redisPkg.Componenter) (rule string, allowed bool) {
+	if c.IsEmpty() {
+		return "", true
+	}
+	name, subcommand := commandName(command)
+	if len(name) == 0 {
+		return "", true
+	}
+	withSubcommand := name + "|" + subcommand
+	if c.deny[name] {
+		return name, false
+	}
+	if len(subcommand) != 0 && c.deny[withSubcommand] {
+		return withSubcommand, false
+	}
+	if len(c.allow) == 0 {
+		return "", true
+	}
+	if c.allow[name] {
+		return name, true
+	}
+	if len(subcommand) != 0 && c.allow[withSubcommand] {
+		return withSubcommand, true
+	}
+	return "", false
+}
+
+// commandName returns the lower case command name and first argument, if the command is an array of bulk strings
+func commandName(command redisPkg.Componenter) (name, subcommand string) {
+	array, ok := command.(*redisPkg.Array)
+	if !ok || len(*array) == 0 {
+		return
+	}
+	if first, ok := (*array)[0].(*redisPkg.BulkString); ok {
+		name = strings.ToLower(first.String())
+	}
+	if len(*array) > 1 {
+		if second, ok := (*array)[1].(*redisPkg.BulkString); ok {
+			subcommand = strings.ToLower(second.String())
+		}
+	}
+	return
+}
+
+// commandArgs returns the command and its arguments, if the command is an array of bulk strings
+func commandArgs(command redisPkg.Componenter) (args []string, ok bool) {
+	array, isArray := command.(*redisPkg.Array)
+	if !isArray {
+		return
+	}
+	args = make([]string, 0, len(*array))
+	for _, arg := range *array {
+		bulk, isBulk := arg.(*redisPkg.BulkString)
+		if !isBulk {
+			return nil, false
+		}
+		args = append(args, bulk.String())
+	}
+	return args, true
+}
+
+// noPermError is sent to the client instead of forwarding a rejected command, the way Redis rejects commands an ACL user may not run
+func noPermError(command redisPkg.Componenter) redisPkg.Componenter {
+	name, subcommand := commandName(command)
+	if len(subcommand) != 0 {
+		name += "|" + subcommand
+	}
+	return redisPkg.NewErrorFromString(fmt.Sprintf("NOPERM the proxy does not allow the '%s' command", name))
+}
+
+// commandRulesAdmitter rejects the client commands the rules in effect do not allow. It is an AdmitFunc rather than an
+// intercept so that it never sees replies from the cluster, which can look just like commands
+func (r *Redis) commandRulesAdmitter() AdmitFunc {
+	return func(componenterIn redisPkg.Componenter, _ int) (forward, reply redisPkg.Componenter) {
+		rules := r.liveSettings().commandRules
+		if rules.IsEmpty() {
+			return componenterIn, nil
+		}
+		rule, allowed := rules.Check(componenterIn)
+		switch {
+		case !allowed && len(rule) == 0:
+			r.metrics.Incr(metricCommandNotAllowed)
+		case !allowed:
+			r.metrics.Incr(metricCommandDenyPrefix + rule)
+		case len(rule) != 0:
+			r.metrics.Incr(metricCommandAllowPrefix + rule)
+		}
+		if !allowed {
+			return nil, noPermError(componenterIn)
+		}
+		return componenterIn, nil
+	}
+}
+
+// SetCommandRules replaces the command rules. nil allows every command. Applies to commands sent after the call, including
+// on connections that are already open
+func (r *Redis) SetCommandRules(rules *CommandRules) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.commandRules = rules
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/port_pool"
+	"testing"
+)
+
+func TestCommandRulesCheck(t *testing.T) {
+	cases := map[string]struct {
+		allow, deny     []string
+		command         string
+		expectedRule    string
+		expectedAllowed bool
+	}{
+		"no rules": {
+			command:         "*1\r\n$8\r\nFLUSHALL\r\n",
+			expectedAllowed: true,
+		},
+		"denied command": {
+			deny:         []string{"flushall"},
+			command:      "*1\r\n$8\r\nFLUSHALL\r\n",
+			expectedRule: "flushall",
+		},
+		"denied subcommand": {
+			deny:         []string{"CONFIG SET"},
+			command:      "*4\r\n$6\r\nconfig\r\n$3\r\nset\r\n$7\r\nmaxconn\r\n$1\r\n1\r\n",
+			expectedRule: "config|set",
+		},
+		"other subcommand": {
+			deny:            []string{"CONFIG SET"},
+			command:         "*3\r\n$6\r\nCONFIG\r\n$3\r\nGET\r\n$7\r\nmaxconn\r\n",
+			expectedAllowed: true,
+		},
+		"allowed": {
+			allow:           []string{"GET", "SET"},
+			command:         "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n",
+			expectedRule:    "get",
+			expectedAllowed: true,
+		},
+		"not allowed": {
+			allow:   []string{"GET", "SET"},
+			command: "*1\r\n$4\r\nKEYS\r\n",
+		},
+		"deny wins": {
+			allow:        []string{"CLUSTER"},
+			deny:         []string{"CLUSTER RESET"},
+			command:      "*2\r\n$7\r\nCLUSTER\r\n$5\r\nRESET\r\n",
+			expectedRule: "cluster|reset",
+		},
+	}
+
+	for caseName, c := range cases {
+		rules, err := NewCommandRules(c.allow, c.deny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		command, err := stringToComponents(c.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rule, allowed := rules.Check(command)
+		assert.Equal(t, c.expectedRule, rule, caseName)
+		assert.Equal(t, c.expectedAllowed, allowed, caseName)
+	}
+}
+
+func TestCommandRulesAdmitter(t *testing.T) {
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	rules, err := NewCommandRules(nil, []string{"FLUSHALL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetCommandRules(rules)
+	admit := r.commandRulesAdmitter()
+
+	command, err := stringToComponents("*1\r\n$8\r\nflushall\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward, reply := admit(command, 0)
+	assert.Nil(t, forward)
+	assert.Equal(t, "-NOPERM the proxy does not allow the 'flushall' command\r\n", componentToString(t, reply))
+	assert.Equal(t, uint64(1), r.metrics.Get(metricCommandDenyPrefix+"flushall"))
+
+	command, err = stringToComponents("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward, reply = admit(command, 0)
+	assert.Equal(t, command, forward)
+	assert.Nil(t, reply)
+}
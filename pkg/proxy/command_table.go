@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"redis_cluster_proxy/pkg/command_table"
+)
+
+// commandTable returns the commands the cluster understands
+func (r *Redis) commandTable() *command_table.Table {
+	r.settingsMu.RLock()
+	defer r.settingsMu.RUnlock()
+	return r.commands
+}
+
+// loadCommandTable asks the cluster for its commands with COMMAND and merges them into the bundled table, so that commands
+// added to Redis, or by modules, are known too. The bundled table is kept if the cluster cannot be asked
+func (r *Redis) loadCommandTable(cluster net.Conn) {
+	buffer := r.buffers.Get()
+	if buffer == nil {
+		log.Println("unable to load the command table from the cluster, using the bundled table: ran out of buffers")
+		return
+	}
+	defer r.buffers.Put(buffer)
+
+	reply, err := queryCluster(cluster, command_table.Statement, buffer)
+	if err != nil {
+		log.Println("unable to load the command table from the cluster, using the bundled table: " + err.Error())
+		return
+	}
+	specs, err := command_table.SpecsFromCommandReply(reply)
+	if err != nil {
+		log.Println("unable to load the command table from the cluster, using the bundled table: " + err.Error())
+		return
+	}
+	table := command_table.Bundled().Merge(specs)
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.commands = table
+}
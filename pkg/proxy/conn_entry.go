@@ -5,6 +5,8 @@ import "net"
 type connEntry struct {
 	idle        []net.Conn
 	activeCount int
+	// closed entries close connections as they are put back, instead of keeping them
+	closed bool
 }
 
 func newConnEntry(maxIdleConnections int) *connEntry {
@@ -36,11 +38,21 @@ func (c *connEntry) AddIdle(conn net.Conn) bool {
 }
 
 func (c *connEntry) Put(conn net.Conn) {
+	if c.closed {
+		c.activeCount--
+		_ = conn.Close()
+		return
+	}
 	if c.AddIdle(conn) {
 		c.activeCount--
 	}
 }
 
+// Remove forgets an active connection that was closed rather than put back
+func (c *connEntry) Remove() {
+	c.activeCount--
+}
+
 func (c connEntry) doesConnExist(conn net.Conn) bool {
 	for _, value := range c.idle {
 		if conn == value {
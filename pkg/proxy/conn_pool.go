@@ -9,19 +9,24 @@ import (
 type ConnectionPooler interface {
 	Dial(destinationAddr string) (conn net.Conn, err error)
 	ReleaseConnection(connection *pooledConnection) error
+	// DiscardConnection closes the connection instead of returning it to the pool, such as after a read or write failed
+	DiscardConnection(connection *pooledConnection) error
 }
 
 type connPool struct {
 	mu                      *sync.Mutex
 	pool                    map[string]*connEntry
 	maxConnectionsPerTarget int
+	dial                    func(destinationAddr string) (net.Conn, error)
 }
 
-func newConnPool(maxConnectionsPerTarget int) *connPool {
+// newConnPool keeps up to maxConnectionsPerTarget connections to each destination. dial opens new ones
+func newConnPool(maxConnectionsPerTarget int, dial func(destinationAddr string) (net.Conn, error)) *connPool {
 	return &connPool{
 		mu:                      &sync.Mutex{},
 		pool:                    make(map[string]*connEntry),
 		maxConnectionsPerTarget: maxConnectionsPerTarget,
+		dial:                    dial,
 	}
 }
 
@@ -29,40 +34,66 @@ var ErrPoolDepleted = fmt.Errorf("no connections available")
 
 func (c *connPool) Dial(destinationAddr string) (conn net.Conn, err error) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if _, ok := c.pool[destinationAddr]; !ok {
-		c.pool[destinationAddr] = newConnEntry(c.maxConnectionsPerTarget)
+	entry, ok := c.pool[destinationAddr]
+	if !ok {
+		entry = newConnEntry(c.maxConnectionsPerTarget)
+		c.pool[destinationAddr] = entry
 	}
-
-	connEntry := c.pool[destinationAddr]
-	if conn = connEntry.Get(); nil == conn {
+	if conn = entry.Get(); nil == conn {
 		// no idle connections
-		if connEntry.TotalOpenConnections() >= c.maxConnectionsPerTarget {
+		if entry.TotalOpenConnections() >= c.maxConnectionsPerTarget {
 			// Pool is full, don't create a new connection
+			c.mu.Unlock()
 			return nil, ErrPoolDepleted
-		} else {
-			// We have room, add it as an Idle connection to track it, then get it
-			var newConn net.Conn
-			newConn, err = net.Dial("tcp", destinationAddr)
-			if err != nil {
-				return
-			}
-			connEntry.AddIdle(newConn)
-			c.pool[destinationAddr] = connEntry
-			conn = connEntry.Get()
+		}
+		// We have room, count the connection as active before dialing so that the pool is not locked while the node answers
+		entry.activeCount++
+	}
+	c.mu.Unlock()
+
+	if conn == nil {
+		conn, err = c.dial(destinationAddr)
+		if err != nil {
+			c.mu.Lock()
+			entry.Remove()
+			c.mu.Unlock()
+			return nil, err
 		}
 	}
 
 	// wrap the connection so that it auto-returns to the pool when Close is called
-	conn = newPooledConnection(c, conn)
+	conn = newPooledConnection(c, destinationAddr, conn)
 	return
 }
 
+// ReleaseConnection returns the connection to the pool of the address it was dialed with. The remote address is not
+// used as it may be a resolved IP rather than the hostname that was dialed
 func (c *connPool) ReleaseConnection(connection *pooledConnection) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.pool[connection.RemoteAddr().String()].Put(connection.realConnection)
+	c.pool[connection.destinationAddr].Put(connection.realConnection)
 	return nil
 }
+
+func (c *connPool) DiscardConnection(connection *pooledConnection) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pool[connection.destinationAddr].Remove()
+	return connection.realConnection.Close()
+}
+
+// Close closes the idle connections. Connections in use are closed when they are released
+func (c *connPool) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, entry := range c.pool {
+		for _, idle := range entry.idle {
+			_ = idle.Close()
+		}
+		entry.idle = entry.idle[:0]
+		entry.closed = true
+	}
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+)
+
+// Credentials are sent with AUTH on every connection the proxy opens to the cluster. An empty Password disables AUTH
+type Credentials struct {
+	Username string
+	Password string
+}
+
+func (c Credentials) IsSet() bool {
+	return len(c.Password) != 0
+}
+
+// authCommand builds AUTH [username] password
+func (c Credentials) authCommand() redisPkg.Componenter {
+	command := redisPkg.Array{redisPkg.NewBulkStringFromString("AUTH")}
+	if len(c.Username) != 0 {
+		command = append(command, redisPkg.NewBulkStringFromString(c.Username))
+	}
+	command = append(command, redisPkg.NewBulkStringFromString(c.Password))
+	return &command
+}
+
+// authenticate sends AUTH to a freshly dialed cluster connection and waits for the OK
+func authenticate(conn net.Conn, credentials Credentials) (err error) {
+	_, err = redisPkg.ComponentToStream(conn, credentials.authCommand())
+	if err != nil {
+		return
+	}
+	reply, _, err := redisPkg.ComponentFromReader(conn, make([]byte, authReplyBufferSize))
+	if err != nil {
+		return
+	}
+	if errorReply, ok := reply.(*redisPkg.ErrorComp); ok {
+		return fmt.Errorf("unable to AUTH with the cluster at %s: %s", conn.RemoteAddr().String(), errorReply.String())
+	}
+	return nil
+}
+
+const authReplyBufferSize = 512
@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"redis_cluster_proxy/pkg/ip_map"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fanOutMerge combines the replies of every master, none of which are errors, into the reply for the client
+type fanOutMerge func(replies []redisPkg.Componenter) redisPkg.Componenter
+
+// fanOutCommands are commands without keys that act on the node they are sent to. The routing endpoint sends them to
+// every master instead, so that they act on the whole cluster. Subcommands are keyed as "command|subcommand"
+var fanOutCommands = map[string]fanOutMerge{
+	"dbsize":       sumReplies,
+	"flushall":     firstReply,
+	"flushdb":      firstReply,
+	"keys":         concatReplies,
+	"randomkey":    randomReply,
+	"script|flush": firstReply,
+	// every master hashes the script the same way, so any reply is the SHA
+	"script|load": firstReply,
+	// functions are loaded on every master, so that FCALL finds them whichever master owns its keys
+	"function|delete":  firstReply,
+	"function|flush":   firstReply,
+	"function|load":    firstReply,
+	"function|restore": firstReply,
+}
+
+// scanNodeBits are the low bits of a routed SCAN cursor that hold the index of the master being scanned. The rest hold
+// that master's own cursor
+const scanNodeBits = 10
+
+// fanOutFor returns how to merge the replies, if args is a command that is sent to every master. INFO is only sent to
+// every master for the keyspace section, as the other sections describe one server
+func fanOutFor(args []string) (merge fanOutMerge, ok bool) {
+	name := strings.ToLower(args[0])
+	if name == "info" {
+		if len(args) == 2 && strings.EqualFold(args[1], "keyspace") {
+			return mergeKeyspaceInfo, true
+		}
+		return nil, false
+	}
+	if merge, ok = fanOutCommands[name]; ok {
+		return
+	}
+	if len(args) > 1 {
+		merge, ok = fanOutCommands[name+"|"+strings.ToLower(args[1])]
+	}
+	return
+}
+
+// masters returns the address of every master in the latest CLUSTER SLOTS, sorted so that the order only changes when
+// masters join or leave
+func (r *Redis) masters() (addrs []ip_map.HostWithPort) {
+	slotRanges, _ := r.topology()
+	seen := make(map[ip_map.HostWithPort]bool)
+	for _, slotRange := range slotRanges {
+		servers := slotRange.Servers()
+		if len(servers) == 0 {
+			continue
+		}
+		addr := ip_map.HostWithPort{Host: servers[0].Ip(), Port: servers[0].Port()}
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].String() < addrs[j].String()
+	})
+	return
+}
+
+// sendToMasters sends command to every master in parallel and merges the replies. If any master fails, the first
+// error is the reply
+func (r *Redis) sendToMasters(merge fanOutMerge, command redisPkg.Componenter) redisPkg.Componenter {
+	masters := r.masters()
+	if len(masters) == 0 {
+		return redisPkg.NewErrorFromString(slotNotServed)
+	}
+	replies := inParallel(len(masters), func(i int) redisPkg.Componenter {
+		return r.sendTo(masters[i], command, nil)
+	})
+	if reply := firstError(replies); reply != nil {
+		return reply
+	}
+	return merge(replies)
+}
+
+// scanCluster runs SCAN cursor [options] over every master in turn. The cursor sent to the client carries the index of
+// the master in its low scanNodeBits bits and that master's cursor in the rest, so that the client walks the whole
+// cluster with one cursor. A cursor is only good while the same masters are in the cluster
+func (r *Redis) scanCluster(args []string) redisPkg.Componenter {
+	if len(args) < 2 {
+		return redisPkg.NewErrorFromString("ERR wrong number of arguments for 'scan' command")
+	}
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return redisPkg.NewErrorFromString("ERR invalid cursor")
+	}
+	masters := r.masters()
+	nodeIndex := int(cursor & (1<<scanNodeBits - 1))
+	if nodeIndex >= len(masters) {
+		return redisPkg.NewErrorFromString("ERR invalid cursor")
+	}
+	command := redisPkg.Array{redisPkg.NewBulkStringFromString(args[0]), redisPkg.NewBulkStringFromString(strconv.FormatUint(cursor>>scanNodeBits, 10))}
+	for _, arg := range args[2:] {
+		command = append(command, redisPkg.NewBulkStringFromString(arg))
+	}
+
+	reply := r.sendTo(masters[nodeIndex], &command, nil)
+	page, ok := reply.(*redisPkg.Array)
+	if !ok || len(*page) != 2 {
+		if isError(reply) {
+			return reply
+		}
+		return unexpectedReply(reply)
+	}
+	nodeCursor, ok := (*page)[0].(*redisPkg.BulkString)
+	if !ok {
+		return unexpectedReply((*page)[0])
+	}
+	next, err := strconv.ParseUint(nodeCursor.String(), 10, 64)
+	if err != nil || next > 1<<(64-scanNodeBits)-1 {
+		return redisPkg.NewErrorFromString(fmt.Sprintf("ERR cursor '%s' from the cluster is too large for the proxy", nodeCursor.String()))
+	}
+	switch {
+	case next != 0:
+		next = next<<scanNodeBits | uint64(nodeIndex)
+	case nodeIndex+1 < len(masters):
+		// this master is done, the next page starts on the next one
+		next = uint64(nodeIndex + 1)
+	}
+	return &redisPkg.Array{redisPkg.NewBulkStringFromString(strconv.FormatUint(next, 10)), (*page)[1]}
+}
+
+// concatReplies joins the array replies, as for KEYS
+func concatReplies(replies []redisPkg.Componenter) redisPkg.Componenter {
+	merged := redisPkg.Array{}
+	for _, reply := range replies {
+		elements, ok := reply.(*redisPkg.Array)
+		if !ok {
+			return unexpectedReply(reply)
+		}
+		merged = append(merged, *elements...)
+	}
+	return &merged
+}
+
+// randomReply picks one of the masters' random keys, skipping masters without keys
+func randomReply(replies []redisPkg.Componenter) redisPkg.Componenter {
+	keys := make([]redisPkg.Componenter, 0, len(replies))
+	for _, reply := range replies {
+		if _, ok := reply.(*redisPkg.BulkString); ok {
+			keys = append(keys, reply)
+		}
+	}
+	if len(keys) == 0 {
+		return redisPkg.NewNullString()
+	}
+	return keys[rand.Intn(len(keys))]
+}
+
+// mergeKeyspaceInfo adds up the lines of INFO keyspace, such as db0:keys=1,expires=0,avg_ttl=0, for each database.
+// avg_ttl is averaged over the keys with an expiry instead
+func mergeKeyspaceInfo(replies []redisPkg.Componenter) redisPkg.Componenter {
+	databases := make([]string, 0, 1)
+	fieldNames := make(map[string][]string)
+	named := make(map[string]bool)
+	totals := make(map[string]map[string]int64)
+	for _, reply := range replies {
+		info, ok := reply.(*redisPkg.BulkString)
+		if !ok {
+			return unexpectedReply(reply)
+		}
+		for _, line := range strings.Split(info.String(), "\r\n") {
+			separator := strings.Index(line, ":")
+			if separator < 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			database := line[:separator]
+			if _, seen := totals[database]; !seen {
+				databases = append(databases, database)
+				totals[database] = make(map[string]int64)
+			}
+			fields := make(map[string]int64)
+			for _, field := range strings.Split(line[separator+1:], ",") {
+				nameAndValue := strings.SplitN(field, "=", 2)
+				if len(nameAndValue) != 2 {
+					continue
+				}
+				value, err := strconv.ParseInt(nameAndValue[1], 10, 64)
+				if err != nil {
+					continue
+				}
+				if !named[database+":"+nameAndValue[0]] {
+					named[database+":"+nameAndValue[0]] = true
+					fieldNames[database] = append(fieldNames[database], nameAndValue[0])
+				}
+				fields[nameAndValue[0]] = value
+			}
+			for name, value := range fields {
+				if name == "avg_ttl" {
+					// weighted by this node's keys with an expiry, divided by the total below
+					value *= fields["expires"]
+				}
+				totals[database][name] += value
+			}
+		}
+	}
+	sort.Slice(databases, func(i, j int) bool {
+		return databaseIndex(databases[i]) < databaseIndex(databases[j])
+	})
+	merged := "# Keyspace\r\n"
+	for _, database := range databases {
+		fields := make([]string, 0, len(fieldNames[database]))
+		for _, name := range fieldNames[database] {
+			value := totals[database][name]
+			if name == "avg_ttl" && totals[database]["expires"] != 0 {
+				value /= totals[database]["expires"]
+			}
+			fields = append(fields, fmt.Sprintf("%s=%d", name, value))
+		}
+		merged += database + ":" + strings.Join(fields, ",") + "\r\n"
+	}
+	return redisPkg.NewBulkStringFromString(merged)
+}
+
+// databaseIndex is the number of a database named in INFO keyspace, such as 10 for db10, so that db2 sorts before db10
+func databaseIndex(database string) int {
+	index, err := strconv.Atoi(strings.TrimPrefix(database, "db"))
+	if err != nil {
+		return -1
+	}
+	return index
+}
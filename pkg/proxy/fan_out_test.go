@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"redis_cluster_proxy/pkg/redis"
+	"strconv"
+	"testing"
+)
+
+func TestFanOut(t *testing.T) {
+	// the first node holds "bar" and "baz", the second "foo"
+	node := func(keys ...string) func(args []string) string {
+		return func(args []string) string {
+			switch args[0] {
+			case "DBSIZE":
+				return fmt.Sprintf(":%d\r\n", len(keys))
+			case "KEYS":
+				reply := fmt.Sprintf("*%d\r\n", len(keys))
+				for _, key := range keys {
+					reply += "$3\r\n" + key + "\r\n"
+				}
+				return reply
+			case "INFO":
+				info := fmt.Sprintf("# Keyspace\r\ndb0:keys=%d,expires=1,avg_ttl=%d\r\n", len(keys), len(keys)*2000-1000)
+				return fmt.Sprintf("$%d\r\n%s\r\n", len(info), info)
+			case "SCAN":
+				// one key per page, the cursor being the index of the key
+				cursor, _ := strconv.Atoi(args[1])
+				next := (cursor + 1) % len(keys)
+				return fmt.Sprintf("*2\r\n$1\r\n%d\r\n*1\r\n$3\r\n%s\r\n", next, keys[cursor])
+			}
+			return "+OK\r\n"
+		}
+	}
+	cluster := newFakeCluster(map[string]func(args []string) string{"10.0.0.1:7000": node("bar", "baz"), "10.0.0.2:7000": node("foo")})
+	r := newRoutingRedis(cluster)
+
+	cases := map[string]struct {
+		command       []string
+		expectedReply string
+	}{
+		"dbsize": {
+			command:       []string{"DBSIZE"},
+			expectedReply: ":3\r\n",
+		},
+		"keys": {
+			command:       []string{"KEYS", "*"},
+			expectedReply: "*3\r\n$3\r\nbar\r\n$3\r\nbaz\r\n$3\r\nfoo\r\n",
+		},
+		"info keyspace": {
+			command:       []string{"INFO", "keyspace"},
+			expectedReply: "$47\r\n# Keyspace\r\ndb0:keys=3,expires=2,avg_ttl=2000\r\n\r\n",
+		},
+		"flushdb": {
+			command:       []string{"FLUSHDB"},
+			expectedReply: "+OK\r\n",
+		},
+		"function load": {
+			command:       []string{"FUNCTION", "LOAD", "#!lua name=lib\nredis.register_function('f', function() return 1 end)"},
+			expectedReply: "+OK\r\n",
+		},
+	}
+
+	for caseName, c := range cases {
+		cluster.received = make(map[string][]string)
+		reply := r.route(commandFromWords(c.command...), nil)
+		assert.Equal(t, c.expectedReply, componentToString(t, reply), caseName)
+		assert.Len(t, cluster.commands("10.0.0.1:7000"), 1, caseName)
+		assert.Len(t, cluster.commands("10.0.0.2:7000"), 1, caseName)
+	}
+
+	// SCAN walks the first master, then the second, with the master's index in the low bits of the cursor
+	pages := []string{
+		"*2\r\n$4\r\n1024\r\n*1\r\n$3\r\nbar\r\n",
+		"*2\r\n$1\r\n1\r\n*1\r\n$3\r\nbaz\r\n",
+		"*2\r\n$1\r\n0\r\n*1\r\n$3\r\nfoo\r\n",
+	}
+	cursor := "0"
+	for i, expected := range pages {
+		reply := r.route(commandFromWords("SCAN", cursor), nil)
+		assert.Equal(t, expected, componentToString(t, reply), "page %d", i)
+		page, ok := reply.(*redis.Array)
+		if !ok {
+			t.Fatalf("page %d is not an array", i)
+		}
+		cursor = (*page)[0].(*redis.BulkString).String()
+	}
+	assert.Equal(t, "-ERR invalid cursor\r\n", componentToString(t, r.route(commandFromWords("SCAN", "2"), nil)), "no third master")
+}
+
+func TestMergeKeyspaceInfo(t *testing.T) {
+	replies := []redis.Componenter{
+		redis.NewBulkStringFromString("# Keyspace\r\ndb10:keys=1,expires=0,avg_ttl=0\r\ndb2:keys=2,keys=2,expires=0,avg_ttl=0\r\n"),
+		redis.NewBulkStringFromString("# Keyspace\r\ndb2:keys=3,expires=0,avg_ttl=0\r\n"),
+	}
+	merged := mergeKeyspaceInfo(replies)
+	if assert.IsType(t, redis.NewBulkStringFromString(""), merged) {
+		assert.Equal(t, "# Keyspace\r\ndb2:keys=5,expires=0,avg_ttl=0\r\ndb10:keys=1,expires=0,avg_ttl=0\r\n", merged.(*redis.BulkString).String())
+	}
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import "strings"
+
+// Horizon is one network that clients reach the proxy from. Every node port is bound on BindHost, and clients that connect
+// through it are sent PublicHost in CLUSTER SLOTS, CLUSTER NODES and MOVED replies. This lets VPN clients and in-cluster
+// sidecars, for example, each be given an address they can route to
+type Horizon struct {
+	BindHost   string
+	PublicHost string
+}
+
+// AddHorizon adds another network to listen on. Must be called before DiscoverAndListen
+func (r *Redis) AddHorizon(horizon Horizon) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	horizons := make([]Horizon, len(r.settings.horizons), len(r.settings.horizons)+1)
+	copy(horizons, r.settings.horizons)
+	horizon.PublicHost = unbracketed(horizon.PublicHost)
+	r.settings.horizons = append(horizons, horizon)
+}
+
+// SetHorizonPublicHost changes the host advertised to clients that connect through the listeners bound to bindHost
+func (r *Redis) SetHorizonPublicHost(bindHost, publicHost string) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	horizons := make([]Horizon, len(r.settings.horizons))
+	copy(horizons, r.settings.horizons)
+	for i := range horizons {
+		if horizons[i].BindHost == bindHost {
+			horizons[i].PublicHost = unbracketed(publicHost)
+		}
+	}
+	r.settings.horizons = horizons
+}
+
+// unbracketed drops the brackets around an IPv6 host, such as [::1], as clients are sent addresses the way Redis Cluster
+// writes them
+func unbracketed(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// publicHostFor is the host advertised to clients connected through the horizon at horizonIndex
+func (r *Redis) publicHostFor(horizonIndex int) string {
+	return r.liveSettings().horizons[horizonIndex].PublicHost
+}
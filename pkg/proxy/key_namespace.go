@@ -0,0 +1,471 @@
+package proxy
+
+import (
+	"fmt"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"strings"
+	"sync"
+)
+
+// A key namespace is a prefix the proxy adds to every key a client sends, and strips from every key the cluster sends
+// back, so that tenants sharing a cluster cannot see each other's keys. Prefixed keys must hash to the same slot as the
+// key the client asked for, as the client picked the node by that slot:
+//
+//   - a key without a hash tag, K, is stored as prefix{K}, so the whole of K is the tag. Such keys cannot contain '}'
+//   - a key with a hash tag is stored as prefix}K. Its own tag still comes first, and the '}' marker keeps the two forms apart
+
+// metricNamespaceRejected counts commands rejected because they could reach outside the namespace
+const metricNamespaceRejected = "namespace.rejected"
+
+// keylessInNamespace are the commands without keys that clients of a key namespace may run, by name or name|subcommand, as
+// neither what they do nor their replies involve keys. Any other keyless command is rejected, as it could act on, or reply
+// with, the keys of other tenants, such as FLUSHALL, CLUSTER GETKEYSINSLOT, SLOWLOG GET or MONITOR. Scripts and functions
+// called without keys are allowed, as they may only touch the keys they are passed
+var keylessInNamespace = map[string]bool{
+	// connections and transactions
+	"auth": true, "hello": true, "ping": true, "echo": true, "select": true, "quit": true, "reset": true,
+	"readonly": true, "readwrite": true, "multi": true, "exec": true, "discard": true, "unwatch": true,
+	"client|id": true, "client|getname": true, "client|setname": true, "client|info": true, "client|setinfo": true,
+	"client|reply": true,
+
+	// server information and cluster topology
+	"info": true, "time": true, "lastsave": true, "role": true, "dbsize": true, "wait": true, "waitaof": true,
+	"command": true, "command|count": true, "command|docs": true, "command|info": true, "command|list": true,
+	"command|getkeys": true, "command|getkeysandflags": true,
+	"cluster|info": true, "cluster|slots": true, "cluster|nodes": true, "cluster|shards": true, "cluster|myid": true,
+	"cluster|myshardid": true, "cluster|keyslot": true,
+
+	// Pub/Sub, scripts and functions
+	"publish": true, "pubsub|channels": true, "pubsub|numpat": true, "pubsub|numsub": true,
+	"eval": true, "evalsha": true, "eval_ro": true, "evalsha_ro": true, "fcall": true, "fcall_ro": true,
+	"script|load": true, "script|exists": true, "script|kill": true,
+	"function|list": true, "function|dump": true, "function|kill": true, "function|stats": true,
+}
+
+// SetKeyPrefix sets the key namespace of clients whose user has no prefix of its own. Empty turns namespacing off. Applies
+// to commands sent after the call, including on connections that are already open
+func (r *Redis) SetKeyPrefix(prefix string) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.keyPrefix = prefix
+}
+
+// namespacedKey returns key as stored inside the namespace
+func namespacedKey(prefix, key string) (stored string, err error) {
+	if _, tagged := redisPkg.HashTag(key); tagged {
+		return prefix + "}" + key, nil
+	}
+	if len(key) == 0 || strings.ContainsRune(key, '}') {
+		return "", fmt.Errorf("ERR key '%s' cannot be used in a key namespace, keys without a hash tag cannot be empty or contain '}'", key)
+	}
+	return prefix + "{" + key + "}", nil
+}
+
+// stripNamespace returns the key the client knows stored by, if stored is inside the namespace
+func stripNamespace(prefix, stored string) (key string, ok bool) {
+	if !strings.HasPrefix(stored, prefix) || len(stored) < len(prefix)+2 {
+		return
+	}
+	rest := stored[len(prefix):]
+	switch {
+	case rest[0] == '}':
+		return rest[1:], true
+	case rest[0] == '{' && rest[len(rest)-1] == '}':
+		return rest[1 : len(rest)-1], true
+	}
+	return
+}
+
+// namespacePattern is a glob matching every key inside the namespace
+func namespacePattern(prefix string) string {
+	return escapeGlob(prefix) + "[{}]*"
+}
+
+func escapeGlob(s string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteByte(s[i])
+	}
+	return escaped.String()
+}
+
+// keyspaceChannel splits a keyspace notification channel, __keyspace@<db>__:<key>
+func keyspaceChannel(channel string) (db, key string, ok bool) {
+	const start, end = "__keyspace@", "__:"
+	if !strings.HasPrefix(channel, start) {
+		return
+	}
+	separator := strings.Index(channel, end)
+	if separator < 0 {
+		return
+	}
+	return channel[len(start):separator], channel[separator+len(end):], true
+}
+
+func isKeyeventChannel(channel string) bool {
+	return strings.HasPrefix(channel, "__keyevent@")
+}
+
+// replyKind says where the keys are in a reply
+type replyKind int
+
+const (
+	replyPlain replyKind = iota
+	// replyKeys is a list of keys, from KEYS
+	replyKeys
+	// replyScan is a cursor and a list of keys, from SCAN
+	replyScan
+	// replyFirstKey is the key popped from, followed by what was popped, such as from BLPOP or LMPOP
+	replyFirstKey
+	// replyStreams is a list of streams, each its key followed by its entries, from XREAD and XREADGROUP
+	replyStreams
+	// replyExec is the replies to the commands of a transaction
+	replyExec
+)
+
+// expectedReply describes the reply to one forwarded command
+type expectedReply struct {
+	kind   replyKind
+	prefix string
+	// pattern is the glob the client gave KEYS or SCAN, which replaces the namespace pattern the cluster was sent
+	pattern string
+	// transaction are the replies expected inside the reply to EXEC
+	transaction []expectedReply
+}
+
+// keyNamespace follows one client connection, prefixing keys on the way to the cluster and stripping them from replies.
+// Replies are matched to commands in order, so every forwarded command is tracked, even while the namespace is off
+type keyNamespace struct {
+	r   *Redis
+	acl *aclSession
+	// next is the reply expected for the command admit let through. It is queued when the command is forwarded
+	next expectedReply
+	// inMulti and transaction are only used by the client side
+	inMulti     bool
+	transaction []expectedReply
+
+	// mu guards what the client side shares with the cluster side
+	mu       sync.Mutex
+	expected []expectedReply
+	// subscribed is set while the connection is in Pub/Sub mode, where replies are not matched to commands
+	subscribed   bool
+	pubSubPrefix string
+	// patterns maps the keyspace patterns sent to the cluster back to the client's patterns
+	patterns map[string]string
+}
+
+func (r *Redis) newKeyNamespace(acl *aclSession) *keyNamespace {
+	return &keyNamespace{r: r, acl: acl, patterns: make(map[string]string)}
+}
+
+// prefix is the namespace for the next command: the client's user's, or else the proxy's
+func (n *keyNamespace) prefix() string {
+	if n.acl != nil && n.acl.user != nil && len(n.acl.user.KeyPrefix) != 0 {
+		return n.acl.user.KeyPrefix
+	}
+	return n.r.liveSettings().keyPrefix
+}
+
+func (n *keyNamespace) reject(message string) (forward, reply redisPkg.Componenter) {
+	n.r.metrics.Incr(metricNamespaceRejected)
+	return nil, redisPkg.NewErrorFromString(message)
+}
+
+// admit prefixes the keys of a command. It must come last when chained, as it expects the command to be forwarded
+func (n *keyNamespace) admit(command redisPkg.Componenter, _ int) (forward, reply redisPkg.Componenter) {
+	n.next = expectedReply{}
+	prefix := n.prefix()
+	if len(prefix) == 0 {
+		return command, nil
+	}
+	args, ok := commandArgs(command)
+	if !ok || len(args) == 0 {
+		return command, nil
+	}
+	name := strings.ToLower(args[0])
+	spec, known := n.r.commandTable().Lookup(args)
+	if !known {
+		return n.reject(fmt.Sprintf("ERR the proxy does not know which arguments of '%s' are keys, so it cannot be used in a key namespace", name))
+	}
+	n.next.prefix = prefix
+	switch name {
+	case "keys":
+		if len(args) != 2 {
+			return command, nil
+		}
+		n.next.kind, n.next.pattern = replyKeys, args[1]
+		args[1] = namespacePattern(prefix)
+		return argsToCommand(args), nil
+	case "scan":
+		n.next.kind, n.next.pattern = replyScan, "*"
+		for i := 2; i+1 < len(args); i++ {
+			if strings.EqualFold(args[i], "MATCH") {
+				n.next.pattern = args[i+1]
+				args[i+1] = namespacePattern(prefix)
+				return argsToCommand(args), nil
+			}
+		}
+		return argsToCommand(append(args, "MATCH", namespacePattern(prefix))), nil
+	case "randomkey":
+		return n.reject("NOPERM 'randomkey' could return keys outside the key namespace")
+	case "sort", "sort_ro":
+		for i := 2; i+1 < len(args); i++ {
+			if (strings.EqualFold(args[i], "BY") && !strings.EqualFold(args[i+1], "nosort")) || (strings.EqualFold(args[i], "GET") && args[i+1] != "#") {
+				return n.reject("NOPERM SORT BY and GET patterns are not supported in a key namespace")
+			}
+		}
+	case "subscribe", "unsubscribe":
+		for i := 1; i < len(args); i++ {
+			if db, key, isKeyspace := keyspaceChannel(args[i]); isKeyspace {
+				stored, err := namespacedKey(prefix, key)
+				if err != nil {
+					return n.reject(err.Error())
+				}
+				args[i] = "__keyspace@" + db + "__:" + stored
+			}
+		}
+		return argsToCommand(args), nil
+	case "psubscribe", "punsubscribe":
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for i := 1; i < len(args); i++ {
+			if db, keyPattern, isKeyspace := keyspaceChannel(args[i]); isKeyspace {
+				// matches both stored forms of the keys the client asked for, and a few more, which are dropped as
+				// notifications arrive. Each client pattern gets its own so they can be told apart in pmessage
+				rewritten := "__keyspace@" + db + "__:" + escapeGlob(prefix) + "[{}]" + keyPattern + "*"
+				n.patterns[rewritten] = args[i]
+				args[i] = rewritten
+			}
+		}
+		return argsToCommand(args), nil
+	}
+
+	indexes := spec.KeyIndexes(args)
+	if len(indexes) == 0 && !keylessInNamespace[name] && !(len(args) > 1 && keylessInNamespace[name+"|"+strings.ToLower(args[1])]) {
+		return n.reject(fmt.Sprintf("NOPERM '%s' reaches outside the key namespace", spec.Name))
+	}
+	for _, index := range indexes {
+		stored, err := namespacedKey(prefix, args[index])
+		if err != nil {
+			return n.reject(err.Error())
+		}
+		args[index] = stored
+	}
+	switch name {
+	case "blpop", "brpop", "bzpopmin", "bzpopmax", "blmpop", "bzmpop", "lmpop", "zmpop":
+		n.next.kind = replyFirstKey
+	case "xread", "xreadgroup":
+		n.next.kind = replyStreams
+	}
+	return argsToCommand(args), nil
+}
+
+func argsToCommand(args []string) redisPkg.Componenter {
+	command := make(redisPkg.Array, 0, len(args))
+	for _, arg := range args {
+		command = append(command, redisPkg.NewBulkStringFromString(arg))
+	}
+	return &command
+}
+
+// forwarded queues the reply expected for a command sent to the cluster
+func (n *keyNamespace) forwarded(command redisPkg.Componenter) {
+	name, _ := commandName(command)
+	expected := n.next
+	n.next = expectedReply{}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch name {
+	case "multi":
+		n.inMulti, n.transaction = true, nil
+		n.expected = append(n.expected, expectedReply{})
+		return
+	case "discard":
+		n.inMulti, n.transaction = false, nil
+		n.expected = append(n.expected, expectedReply{})
+		return
+	case "exec":
+		if n.inMulti {
+			expected = expectedReply{kind: replyExec, transaction: n.transaction}
+		}
+		n.inMulti, n.transaction = false, nil
+		n.expected = append(n.expected, expected)
+		return
+	}
+	if n.inMulti {
+		// the cluster replies QUEUED now, and the real reply inside the reply to EXEC
+		n.transaction = append(n.transaction, expected)
+		n.expected = append(n.expected, expectedReply{})
+		return
+	}
+	switch name {
+	case "subscribe", "psubscribe", "ssubscribe", "unsubscribe", "punsubscribe", "sunsubscribe":
+		// confirmed with Pub/Sub messages rather than replies
+		n.subscribed = true
+		n.pubSubPrefix = n.prefix()
+		return
+	case "ping":
+		if n.subscribed {
+			return
+		}
+	}
+	n.expected = append(n.expected, expected)
+}
+
+// reply strips the namespace from a component sent by the cluster. Notifications about keys outside the namespace are dropped
+func (n *keyNamespace) reply(componenter redisPkg.Componenter) redisPkg.Componenter {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.subscribed {
+		if array, ok := componenter.(*redisPkg.Array); ok && len(*array) != 0 {
+			if kind, ok := (*array)[0].(*redisPkg.BulkString); ok {
+				if rewritten, isPubSub := n.pubSubMessage(strings.ToLower(kind.String()), *array); isPubSub {
+					return rewritten
+				}
+			}
+		}
+	}
+	if len(n.expected) == 0 {
+		return componenter
+	}
+	expected := n.expected[0]
+	n.expected = n.expected[1:]
+	stripReply(expected, componenter)
+	return componenter
+}
+
+// pubSubMessage rewrites a message sent in Pub/Sub mode. It returns nil to drop the message
+func (n *keyNamespace) pubSubMessage(kind string, message redisPkg.Array) (rewritten redisPkg.Componenter, isPubSub bool) {
+	bulk := func(i int) (string, bool) {
+		if i >= len(message) {
+			return "", false
+		}
+		value, ok := message[i].(*redisPkg.BulkString)
+		if !ok {
+			return "", false
+		}
+		return value.String(), true
+	}
+	setBulk := func(i int, value string) {
+		message[i] = redisPkg.NewBulkStringFromString(value)
+	}
+	switch kind {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ssubscribe", "sunsubscribe":
+		if count, ok := message[len(message)-1].(*redisPkg.Int); ok && len(message) == 3 {
+			n.subscribed = count.Int() > 0
+		}
+		if name, ok := bulk(1); ok {
+			if clientPattern, isRewritten := n.patterns[name]; isRewritten {
+				setBulk(1, clientPattern)
+			} else if db, stored, isKeyspace := keyspaceChannel(name); isKeyspace && len(n.pubSubPrefix) != 0 {
+				if key, inside := stripNamespace(n.pubSubPrefix, stored); inside {
+					setBulk(1, "__keyspace@"+db+"__:"+key)
+				}
+			}
+		}
+		return &message, true
+	case "pong":
+		return &message, true
+	case "message", "pmessage", "smessage":
+	default:
+		return nil, false
+	}
+	if len(n.pubSubPrefix) == 0 {
+		return &message, true
+	}
+	channelIndex, clientPattern := 1, ""
+	if kind == "pmessage" {
+		channelIndex = 2
+		if pattern, ok := bulk(1); ok {
+			if original, isRewritten := n.patterns[pattern]; isRewritten {
+				clientPattern = original
+				setBulk(1, original)
+			}
+		}
+	}
+	channel, ok := bulk(channelIndex)
+	if !ok {
+		return &message, true
+	}
+	if db, stored, isKeyspace := keyspaceChannel(channel); isKeyspace {
+		key, inside := stripNamespace(n.pubSubPrefix, stored)
+		if !inside {
+			return nil, true
+		}
+		channel = "__keyspace@" + db + "__:" + key
+		if len(clientPattern) != 0 && !redisPkg.MatchGlob(clientPattern, channel) {
+			return nil, true
+		}
+		setBulk(channelIndex, channel)
+	} else if isKeyeventChannel(channel) {
+		stored, _ := bulk(channelIndex + 1)
+		key, inside := stripNamespace(n.pubSubPrefix, stored)
+		if !inside {
+			return nil, true
+		}
+		setBulk(channelIndex+1, key)
+	}
+	return &message, true
+}
+
+// stripReply removes the namespace from the keys in a reply, in place
+func stripReply(expected expectedReply, componenter redisPkg.Componenter) {
+	array, ok := componenter.(*redisPkg.Array)
+	if !ok || (expected.kind != replyExec && len(expected.prefix) == 0) {
+		return
+	}
+	switch expected.kind {
+	case replyKeys:
+		*array = stripKeyList(expected, *array)
+	case replyScan:
+		if len(*array) == 2 {
+			if keys, ok := (*array)[1].(*redisPkg.Array); ok {
+				*keys = stripKeyList(expected, *keys)
+			}
+		}
+	case replyFirstKey:
+		if len(*array) != 0 {
+			stripKeyAt(expected.prefix, *array, 0)
+		}
+	case replyStreams:
+		for _, stream := range *array {
+			if streamArray, ok := stream.(*redisPkg.Array); ok && len(*streamArray) != 0 {
+				stripKeyAt(expected.prefix, *streamArray, 0)
+			}
+		}
+	case replyExec:
+		for i, transactionReply := range expected.transaction {
+			if i < len(*array) {
+				stripReply(transactionReply, (*array)[i])
+			}
+		}
+	}
+}
+
+// stripKeyList keeps the keys that are inside the namespace and match the client's pattern, without the namespace
+func stripKeyList(expected expectedReply, keys redisPkg.Array) redisPkg.Array {
+	stripped := make(redisPkg.Array, 0, len(keys))
+	for _, component := range keys {
+		stored, ok := component.(*redisPkg.BulkString)
+		if !ok {
+			continue
+		}
+		key, inside := stripNamespace(expected.prefix, stored.String())
+		if inside && redisPkg.MatchGlob(expected.pattern, key) {
+			stripped = append(stripped, redisPkg.NewBulkStringFromString(key))
+		}
+	}
+	return stripped
+}
+
+func stripKeyAt(prefix string, array redisPkg.Array, index int) {
+	if stored, ok := array[index].(*redisPkg.BulkString); ok {
+		if key, inside := stripNamespace(prefix, stored.String()); inside {
+			array[index] = redisPkg.NewBulkStringFromString(key)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/port_pool"
+	"redis_cluster_proxy/pkg/redis"
+	"testing"
+)
+
+func TestNamespacedKey(t *testing.T) {
+	cases := map[string]struct {
+		key            string
+		expectedStored string
+		expectedError  bool
+	}{
+		"plain": {
+			key:            "orders:1",
+			expectedStored: "tenant-a:{orders:1}",
+		},
+		"tagged": {
+			key:            "{user1000}.following",
+			expectedStored: "tenant-a:}{user1000}.following",
+		},
+		"unclosed brace": {
+			key:            "a{b",
+			expectedStored: "tenant-a:{a{b}",
+		},
+		"empty tag": {
+			key:           "a{}b",
+			expectedError: true,
+		},
+		"empty": {
+			key:           "",
+			expectedError: true,
+		},
+	}
+
+	for caseName, c := range cases {
+		stored, err := namespacedKey("tenant-a:", c.key)
+		if c.expectedError {
+			assert.Error(t, err, caseName)
+			continue
+		}
+		assert.NoError(t, err, caseName)
+		assert.Equal(t, c.expectedStored, stored, caseName)
+		assert.Equal(t, redis.KeySlot(c.key), redis.KeySlot(stored), caseName+": same slot")
+		key, ok := stripNamespace("tenant-a:", stored)
+		assert.True(t, ok, caseName)
+		assert.Equal(t, c.key, key, caseName)
+		assert.True(t, redis.MatchGlob(namespacePattern("tenant-a:"), stored), caseName)
+	}
+
+	_, ok := stripNamespace("tenant-a:", "tenant-b:{orders:1}")
+	assert.False(t, ok, "other namespace")
+}
+
+func TestKeyNamespaceSession(t *testing.T) {
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	r.SetKeyPrefix("t:")
+	namespace := r.newKeyNamespace(r.newACLSession())
+
+	send := func(words ...string) string {
+		forward, reply := namespace.admit(commandFromWords(words...), 0)
+		if reply != nil {
+			return componentToString(t, reply)
+		}
+		namespace.forwarded(forward)
+		return componentToString(t, forward)
+	}
+	receive := func(wire string) string {
+		component, err := stringToComponents(wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		component = namespace.reply(component)
+		if component == nil {
+			return ""
+		}
+		return componentToString(t, component)
+	}
+
+	// commands are pipelined, then the replies arrive in order
+	assert.Equal(t, "*3\r\n$3\r\nSET\r\n$5\r\nt:{a}\r\n$1\r\n1\r\n", send("SET", "a", "1"))
+	assert.Equal(t, "*2\r\n$4\r\nKEYS\r\n$7\r\nt:[{}]*\r\n", send("KEYS", "a*"))
+	assert.Equal(t, "*4\r\n$4\r\nSCAN\r\n$1\r\n0\r\n$5\r\nMATCH\r\n$7\r\nt:[{}]*\r\n", send("SCAN", "0"))
+	assert.Equal(t, "*3\r\n$5\r\nBLPOP\r\n$8\r\nt:}{q}:1\r\n$1\r\n0\r\n", send("BLPOP", "{q}:1", "0"))
+	assert.Equal(t, "-NOPERM 'flushall' reaches outside the key namespace\r\n", send("FLUSHALL"))
+	assert.Equal(t, "-NOPERM 'cluster' reaches outside the key namespace\r\n", send("CLUSTER", "GETKEYSINSLOT", "0", "10"), "the reply would hold other tenants' keys")
+	assert.Equal(t, "-NOPERM 'slowlog' reaches outside the key namespace\r\n", send("SLOWLOG", "GET"))
+	assert.Equal(t, "*2\r\n$7\r\nCLUSTER\r\n$5\r\nSLOTS\r\n", send("CLUSTER", "SLOTS"))
+	send("MULTI")
+	send("GET", "a")
+	send("KEYS", "*")
+	send("EXEC")
+
+	assert.Equal(t, "+OK\r\n", receive("+OK\r\n"))
+	assert.Equal(t, "*1\r\n$1\r\na\r\n", receive("*2\r\n$5\r\nt:{a}\r\n$5\r\nt:{b}\r\n"), "filtered by the client's pattern")
+	assert.Equal(t, "*2\r\n$1\r\n0\r\n*1\r\n$5\r\n{q}:1\r\n", receive("*2\r\n$1\r\n0\r\n*1\r\n$8\r\nt:}{q}:1\r\n"))
+	assert.Equal(t, "*2\r\n$5\r\n{q}:1\r\n$5\r\nt:{a}\r\n", receive("*2\r\n$8\r\nt:}{q}:1\r\n$5\r\nt:{a}\r\n"), "only the key is stripped, not the value")
+	assert.Equal(t, "*0\r\n", receive("*0\r\n"))
+	assert.Equal(t, "+OK\r\n", receive("+OK\r\n"))
+	assert.Equal(t, "+QUEUED\r\n", receive("+QUEUED\r\n"))
+	assert.Equal(t, "+QUEUED\r\n", receive("+QUEUED\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\n1\r\n*1\r\n$1\r\na\r\n", receive("*2\r\n$1\r\n1\r\n*1\r\n$5\r\nt:{a}\r\n"))
+
+	// keyspace notifications
+	assert.Equal(t, "*3\r\n$9\r\nSUBSCRIBE\r\n$20\r\n__keyspace@0__:t:{a}\r\n$18\r\n__keyevent@0__:del\r\n", send("SUBSCRIBE", "__keyspace@0__:a", "__keyevent@0__:del"))
+	assert.Equal(t, "*3\r\n$9\r\nsubscribe\r\n$16\r\n__keyspace@0__:a\r\n:1\r\n", receive("*3\r\n$9\r\nsubscribe\r\n$20\r\n__keyspace@0__:t:{a}\r\n:1\r\n"))
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n$16\r\n__keyspace@0__:a\r\n$3\r\nset\r\n", receive("*3\r\n$7\r\nmessage\r\n$20\r\n__keyspace@0__:t:{a}\r\n$3\r\nset\r\n"))
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n$18\r\n__keyevent@0__:del\r\n$1\r\na\r\n", receive("*3\r\n$7\r\nmessage\r\n$18\r\n__keyevent@0__:del\r\n$5\r\nt:{a}\r\n"))
+	assert.Equal(t, "", receive("*3\r\n$7\r\nmessage\r\n$18\r\n__keyevent@0__:del\r\n$5\r\nu:{a}\r\n"), "other tenants' keys are dropped")
+}
@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"redis_cluster_proxy/pkg/ip_map"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"sync"
+)
+
+// splitCommand is a multi-key command that the routing endpoint splits into one command per slot when its keys span slots
+type splitCommand struct {
+	// step is the number of arguments that go with each key, including the key, such as 2 for the key value pairs of MSET
+	step int
+	// merge combines the replies of the per slot commands, none of which are errors, into the reply for the client.
+	// positions has, for each per slot command, the index of each of its keys among the keys of the original command
+	merge func(replies []redisPkg.Componenter, positions [][]int, keyCount int) redisPkg.Componenter
+}
+
+// splitCommands can be split by slot. Each part is atomic on its own node, but the command as a whole is not
+var splitCommands = map[string]splitCommand{
+	"mget":   {step: 1, merge: mergeByPosition},
+	"mset":   {step: 2, merge: mergeOK},
+	"del":    {step: 1, merge: mergeSum},
+	"exists": {step: 1, merge: mergeSum},
+	"unlink": {step: 1, merge: mergeSum},
+	"touch":  {step: 1, merge: mergeSum},
+}
+
+// slotCommand is the part of a split command for the keys in one slot
+type slotCommand struct {
+	slot    int
+	command redisPkg.Componenter
+}
+
+// bySlot splits args, the command name followed by its keys and their arguments, into one command per slot, in the order
+// the slots are first seen. ok is false if the arguments do not come in whole steps, which the cluster should reject
+func (c splitCommand) bySlot(args []string) (commands []slotCommand, positions [][]int, ok bool) {
+	if len(args) < 2 || (len(args)-1)%c.step != 0 {
+		return nil, nil, false
+	}
+	commandIndex := make(map[int]int)
+	for position := 0; position*c.step+1 < len(args); position++ {
+		first := position*c.step + 1
+		slot := redisPkg.KeySlot(args[first])
+		i, seen := commandIndex[slot]
+		if !seen {
+			i = len(commands)
+			commandIndex[slot] = i
+			commands = append(commands, slotCommand{slot: slot, command: &redisPkg.Array{redisPkg.NewBulkStringFromString(args[0])}})
+			positions = append(positions, nil)
+		}
+		command := commands[i].command.(*redisPkg.Array)
+		for _, arg := range args[first : first+c.step] {
+			*command = append(*command, redisPkg.NewBulkStringFromString(arg))
+		}
+		positions[i] = append(positions[i], position)
+	}
+	return commands, positions, true
+}
+
+// sendSplit sends the commands for the slots of each master to that master, pipelined on one connection, with every master
+// in parallel, and merges the replies. If any part fails, the first error, in slot order, is the reply
+func (r *Redis) sendSplit(split splitCommand, args []string) redisPkg.Componenter {
+	commands, positions, ok := split.bySlot(args)
+	if !ok {
+		command := redisPkg.Array{}
+		for _, arg := range args {
+			command = append(command, redisPkg.NewBulkStringFromString(arg))
+		}
+		return r.sendToSlot(redisPkg.KeySlot(args[1]), &command)
+	}
+	replies := make([]redisPkg.Componenter, len(commands))
+	var masters []ip_map.HostWithPort
+	byMaster := make(map[ip_map.HostWithPort][]int)
+	for i, command := range commands {
+		addr, served := r.slotOwner(command.slot)
+		if !served {
+			replies[i] = redisPkg.NewErrorFromString(slotNotServed)
+			continue
+		}
+		if _, seen := byMaster[addr]; !seen {
+			masters = append(masters, addr)
+		}
+		byMaster[addr] = append(byMaster[addr], i)
+	}
+	inParallel(len(masters), func(m int) redisPkg.Componenter {
+		indexes := byMaster[masters[m]]
+		batch := make([]redisPkg.Componenter, len(indexes))
+		for j, i := range indexes {
+			batch[j] = commands[i].command
+		}
+		for j, reply := range r.sendPipelined(masters[m], batch) {
+			replies[indexes[j]] = reply
+		}
+		return nil
+	})
+	if reply := firstError(replies); reply != nil {
+		return reply
+	}
+	return split.merge(replies, positions, (len(args)-1)/split.step)
+}
+
+// sendPipelined sends commands to the node at addr at once, over one pooled connection, and returns their replies in order.
+// Commands that were redirected, as their slot is moving, are sent again on their own to follow the redirection
+func (r *Redis) sendPipelined(addr ip_map.HostWithPort, commands []redisPkg.Componenter) []redisPkg.Componenter {
+	replies := make([]redisPkg.Componenter, len(commands))
+	failAll := func(reply redisPkg.Componenter) []redisPkg.Componenter {
+		for i := range replies {
+			replies[i] = reply
+		}
+		return replies
+	}
+	buffer := r.buffers.Get()
+	if buffer == nil {
+		return failAll(redisPkg.NewErrorFromString("ERR the proxy ran out of buffers"))
+	}
+	defer r.buffers.Put(buffer)
+	conn, err := r.backends.Dial(addr.String())
+	if err != nil {
+		return failAll(r.backendError(err))
+	}
+	pooled := conn.(*pooledConnection)
+	received, err := r.exchange(pooled, commands, buffer, nil)
+	if err != nil {
+		return failAll(r.backendError(err))
+	}
+	_ = pooled.Close()
+	for i, reply := range received {
+		if _, _, redirected := redirection(reply); redirected {
+			reply = r.sendTo(addr, commands[i], nil)
+		}
+		replies[i] = reply
+	}
+	return replies
+}
+
+// inParallel calls send for 0 to count-1, each on its own goroutine, and returns the replies in that order
+func inParallel(count int, send func(i int) redisPkg.Componenter) []redisPkg.Componenter {
+	replies := make([]redisPkg.Componenter, count)
+	wait := &sync.WaitGroup{}
+	for i := range replies {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			replies[i] = send(i)
+		}(i)
+	}
+	wait.Wait()
+	return replies
+}
+
+func firstError(replies []redisPkg.Componenter) redisPkg.Componenter {
+	for _, reply := range replies {
+		if isError(reply) {
+			return reply
+		}
+	}
+	return nil
+}
+
+// mergeByPosition puts the elements of the array replies back in the order of the original keys, as for MGET
+func mergeByPosition(replies []redisPkg.Componenter, positions [][]int, keyCount int) redisPkg.Componenter {
+	merged := make(redisPkg.Array, keyCount)
+	for i, reply := range replies {
+		elements, ok := reply.(*redisPkg.Array)
+		if !ok || len(*elements) != len(positions[i]) {
+			return unexpectedReply(reply)
+		}
+		for j, position := range positions[i] {
+			merged[position] = (*elements)[j]
+		}
+	}
+	return &merged
+}
+
+// mergeOK replies with the first reply, as for MSET where every part replies OK
+func mergeOK(replies []redisPkg.Componenter, _ [][]int, _ int) redisPkg.Componenter {
+	return firstReply(replies)
+}
+
+// mergeSum adds up the integer replies, as for DEL
+func mergeSum(replies []redisPkg.Componenter, _ [][]int, _ int) redisPkg.Componenter {
+	return sumReplies(replies)
+}
+
+func firstReply(replies []redisPkg.Componenter) redisPkg.Componenter {
+	return replies[0]
+}
+
+func sumReplies(replies []redisPkg.Componenter) redisPkg.Componenter {
+	total := 0
+	for _, reply := range replies {
+		count, ok := reply.(*redisPkg.Int)
+		if !ok {
+			return unexpectedReply(reply)
+		}
+		total += count.Int()
+	}
+	return redisPkg.NewIntFromInt(total)
+}
+
+func unexpectedReply(reply redisPkg.Componenter) redisPkg.Componenter {
+	return redisPkg.NewErrorFromString("ERR unexpected " + reply.RedisTypeName() + " reply from the cluster")
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"sync"
+)
+
+// nodeListener is the sockets clients use to reach one cluster node, one per horizon, along with the client connections accepted on them
+type nodeListener struct {
+	listeners []net.Listener
+	mu        *sync.Mutex
+	// clients maps each client connection to its connection to the cluster node
+	clients map[net.Conn]net.Conn
+	// departure is why the node's clients were disconnected, empty until Depart
+	departure string
+}
+
+func newNodeListener(listeners []net.Listener) *nodeListener {
+	return &nodeListener{
+		listeners: listeners,
+		mu:        &sync.Mutex{},
+		clients:   make(map[net.Conn]net.Conn),
+	}
+}
+
+func (n *nodeListener) track(client, cluster net.Conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.clients[client] = cluster
+}
+
+func (n *nodeListener) untrack(client net.Conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.clients, client)
+}
+
+func (n *nodeListener) Close() (err error) {
+	for _, listener := range n.listeners {
+		closeErr := listener.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// Depart stops accepting clients and disconnects the connected ones by closing their connections to the cluster node.
+// Each client is then sent farewell, a RESP error saying why, by the side that writes the node's replies to it, so the
+// notice cannot land in the middle of a reply
+func (n *nodeListener) Depart(reason string) (err error) {
+	err = n.Close()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.departure = reason
+	for _, cluster := range n.clients {
+		_ = cluster.Close()
+	}
+	return
+}
+
+// farewell is the last reply for a client whose connection to the cluster node ended, or nil if the node did not depart
+func (n *nodeListener) farewell() redisPkg.Componenter {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.departure) == 0 {
+		return nil
+	}
+	return redisPkg.NewErrorFromString(n.departure)
+}
+
+// departure is a node listener to Depart, and why
+type departure struct {
+	listener *nodeListener
+	reason   string
+}
+
+// departAll disconnects the clients of every departed node. It is called after topologyMu is released, so that slow
+// clients cannot hold up the topology
+func departAll(departures []departure) {
+	for _, departed := range departures {
+		if err := departed.listener.Depart(departed.reason); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func departedNodeNotice(remote fmt.Stringer) string {
+	return fmt.Sprintf("ERR cluster node %s left the cluster, reconnect and refresh the cluster slots", remote.String())
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"net"
+	"redis_cluster_proxy/pkg/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingReply is a command forwarded to the cluster that was not answered yet, or a reply the proxy made itself that
+// waits for the replies to the commands before it
+type pendingReply struct {
+	command redis.Componenter
+	// wait is how much longer than the read timeout the reply may take, such as the timeout of BLPOP. Negative waits as long
+	// as it takes
+	wait time.Duration
+	// local is the reply the proxy made itself, nil for a forwarded command
+	local redis.Componenter
+}
+
+// pendingReplies tracks the commands forwarded to the cluster without a reply yet, in order, so that the replies the proxy
+// makes itself, such as rejections, reach a pipelining client in the order it sent the commands.
+// The cluster read deadline is only armed while replies are outstanding, so a quiet client does not look like a slow cluster.
+// It always counts towards the oldest outstanding reply, so a client that keeps pipelining cannot hold off a stuck one.
+// Pub/Sub messages are not replies to commands, so Pub/Sub commands are left out
+type pendingReplies struct {
+	mu    *sync.Mutex
+	queue []pendingReply
+	// outstanding counts the forwarded commands in queue
+	outstanding int
+	// subscribed is set from the first Pub/Sub command until the cluster confirms there are no subscriptions left
+	subscribed bool
+	client     net.Conn
+	cluster    net.Conn
+	timeouts   Timeouts
+}
+
+func newPendingReplies(client, cluster net.Conn, timeouts Timeouts) *pendingReplies {
+	return &pendingReplies{
+		mu:       &sync.Mutex{},
+		client:   client,
+		cluster:  cluster,
+		timeouts: timeouts,
+	}
+}
+
+// Sent records a command on its way to the cluster, and arms the read deadline if no other reply was outstanding. wait is how
+// much longer than the read timeout the command may block on the node, negative for as long as it takes
+func (p *pendingReplies) Sent(command redis.Componenter, wait time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name, _ := commandName(command)
+	switch name {
+	case "subscribe", "psubscribe", "ssubscribe", "unsubscribe", "punsubscribe", "sunsubscribe":
+		// confirmed with Pub/Sub messages rather than replies
+		p.subscribed = true
+		return
+	case "ping":
+		if p.subscribed {
+			return
+		}
+	}
+	p.queue = append(p.queue, pendingReply{command: command, wait: wait})
+	p.outstanding++
+	if p.outstanding == 1 {
+		p.arm()
+	}
+}
+
+// Received records a component from the cluster, and returns the command it answers. Pub/Sub messages answer none.
+// The deadline is extended if more replies are due, otherwise it is cleared. The replies of the proxy that were waiting
+// for this one are left for Flush, once it is written
+func (p *pendingReplies) Received(componenter redis.Componenter) (command redis.Componenter, isReply bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.subscribed {
+		if kind, subscriptions, isPubSub := pubSubMessage(componenter); isPubSub {
+			if strings.HasSuffix(kind, "subscribe") && subscriptions >= 0 {
+				p.subscribed = subscriptions > 0
+			}
+			return nil, false
+		}
+	}
+	for i, pending := range p.queue {
+		if pending.local == nil {
+			command = pending.command
+			p.queue = append(p.queue[:i:i], p.queue[i+1:]...)
+			p.outstanding--
+			isReply = true
+			break
+		}
+	}
+	if p.outstanding == 0 {
+		_ = p.cluster.SetReadDeadline(time.Time{})
+	} else {
+		p.arm()
+	}
+	return
+}
+
+// Local queues a reply the proxy made itself behind the outstanding replies from the cluster. It returns false, queueing
+// nothing, if there are none, for the caller to write the reply right away
+func (p *pendingReplies) Local(reply redis.Componenter) (queued bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.queue) == 0 {
+		return false
+	}
+	p.queue = append(p.queue, pendingReply{local: reply})
+	return true
+}
+
+// Flush writes to the client the replies of the proxy that are no longer waiting for a reply from the cluster
+func (p *pendingReplies) Flush() (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.queue) != 0 && p.queue[0].local != nil {
+		setWriteDeadline(p.client, p.timeouts.Write)
+		err = writeComponent(p.client, p.queue[0].local)
+		p.queue = p.queue[1:]
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// arm sets the read deadline for the oldest outstanding reply
+func (p *pendingReplies) arm() {
+	if p.timeouts.BackendRead <= 0 {
+		return
+	}
+	for _, pending := range p.queue {
+		if pending.local != nil {
+			continue
+		}
+		if pending.wait < 0 {
+			_ = p.cluster.SetReadDeadline(time.Time{})
+			return
+		}
+		_ = p.cluster.SetReadDeadline(time.Now().Add(p.timeouts.BackendRead + pending.wait))
+		return
+	}
+}
+
+// pubSubMessage tells a Pub/Sub message, such as a published message or a subscription being confirmed, from a reply.
+// subscriptions is the number of subscriptions left that confirmations carry, or -1
+func pubSubMessage(componenter redis.Componenter) (kind string, subscriptions int, isPubSub bool) {
+	array, ok := componenter.(*redis.Array)
+	if !ok || len(*array) == 0 {
+		return
+	}
+	first, ok := (*array)[0].(*redis.BulkString)
+	if !ok {
+		return
+	}
+	kind, subscriptions = strings.ToLower(first.String()), -1
+	switch kind {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ssubscribe", "sunsubscribe":
+		if count, isInt := (*array)[len(*array)-1].(*redis.Int); isInt && len(*array) == 3 {
+			subscriptions = count.Int()
+		}
+		return kind, subscriptions, true
+	case "message", "pmessage", "smessage", "pong":
+		return kind, subscriptions, true
+	}
+	return "", -1, false
+}
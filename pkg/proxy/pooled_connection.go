@@ -9,17 +9,20 @@ import (
 
 type pooledConnection struct {
 	realConnection net.Conn
-	pool           ConnectionPooler
-	isClosed       bool
-	mu             *sync.RWMutex
+	// destinationAddr is the address the connection was dialed with, which keys its pool
+	destinationAddr string
+	pool            ConnectionPooler
+	isClosed        bool
+	mu              *sync.RWMutex
 }
 
-func newPooledConnection(pool ConnectionPooler, conn net.Conn) *pooledConnection {
+func newPooledConnection(pool ConnectionPooler, destinationAddr string, conn net.Conn) *pooledConnection {
 	return &pooledConnection{
-		realConnection: conn,
-		pool:           pool,
-		isClosed:       false,
-		mu:             &sync.RWMutex{},
+		realConnection:  conn,
+		destinationAddr: destinationAddr,
+		pool:            pool,
+		isClosed:        false,
+		mu:              &sync.RWMutex{},
 	}
 }
 
@@ -52,6 +55,18 @@ func (p *pooledConnection) Close() error {
 	return nil
 }
 
+// Discard closes the connection for good instead of returning it to the pool. Use it when the connection may have
+// unread replies, or is broken
+func (p *pooledConnection) Discard() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.isClosed {
+		p.isClosed = true
+		return p.pool.DiscardConnection(p)
+	}
+	return nil
+}
+
 func (p pooledConnection) LocalAddr() net.Addr {
 	return p.realConnection.LocalAddr()
 }
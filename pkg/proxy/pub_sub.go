@@ -0,0 +1,475 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"redis_cluster_proxy/pkg/ip_map"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricRoutingResubscribed counts sharded channels sent SSUBSCRIBE again after their slot moved or their node was lost
+const metricRoutingResubscribed = "routing.resubscribed"
+
+// resubscribeDelay is how long to wait before subscribing again to the sharded channels of a lost node, for the cluster
+// to promote a replica
+const resubscribeDelay = time.Second
+
+const pubSubOnlyMessage = "ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"
+
+// routedPubSub follows the subscriptions of one client of the routing endpoint. Subscribed connections receive messages
+// at any time rather than replies to commands, so they cannot be shared: each session subscribes on connections of its
+// own, outside the backend pool. SUBSCRIBE and PSUBSCRIBE reach every node, so they use one connection to any master.
+// SSUBSCRIBE uses a connection to the master of each channel's slot
+type routedPubSub struct {
+	mu sync.Mutex
+	// active is set from the first subscription until the client has none left, while only Pub/Sub commands are allowed
+	active       bool
+	classic      net.Conn
+	classicCount int
+	shardConns   map[ip_map.HostWithPort]net.Conn
+	shards       map[string]*shardSubscription
+	// done is closed when the client leaves, so that lost connections are not subscribed again
+	done chan struct{}
+}
+
+// shardSubscription is a sharded channel the client subscribed to
+type shardSubscription struct {
+	slot int
+	addr ip_map.HostWithPort
+	// confirmed is set once the node confirmed the subscription, and counts it in the replies to the client
+	confirmed bool
+	// unsubscribing is set when the client sent SUNSUBSCRIBE for the channel
+	unsubscribing bool
+	// resubscribing is set while the channel is sent SSUBSCRIBE again, as the client was already told it is subscribed
+	resubscribing bool
+}
+
+func newRoutedPubSub() *routedPubSub {
+	return &routedPubSub{
+		shardConns: make(map[ip_map.HostWithPort]net.Conn),
+		shards:     make(map[string]*shardSubscription),
+		done:       make(chan struct{}),
+	}
+}
+
+func (p *routedPubSub) isActive() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active
+}
+
+// shardCount is the number of sharded channels to tell the client it is subscribed to. Callers hold mu
+func (p *routedPubSub) shardCount() (count int) {
+	for _, subscription := range p.shards {
+		if subscription.confirmed {
+			count++
+		}
+	}
+	return
+}
+
+// updateActive leaves Pub/Sub mode once no subscriptions are left. Callers hold mu
+func (p *routedPubSub) updateActive() {
+	p.active = p.classicCount > 0 || len(p.shards) > 0
+}
+
+// pubSub handles the Pub/Sub commands, and the commands sent while subscribed. A nil reply means the replies come as
+// messages from the cluster. handled is false for commands that are routed as usual
+func (s *routedSession) pubSub(command redisPkg.Componenter) (reply redisPkg.Componenter, handled bool) {
+	args, _ := commandArgs(command)
+	name := strings.ToLower(args[0])
+	switch name {
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "ssubscribe", "sunsubscribe":
+		if s.tx.inMulti {
+			s.tx.aborted = true
+			return redisPkg.NewErrorFromString(fmt.Sprintf("ERR '%s' is not supported inside MULTI on the routing endpoint", name)), true
+		}
+	}
+	switch name {
+	case "subscribe", "psubscribe":
+		return s.sendClassic(command, true), true
+	case "unsubscribe", "punsubscribe":
+		return s.sendClassic(command, false), true
+	case "ssubscribe":
+		return s.shardSubscribe(command, args), true
+	case "sunsubscribe":
+		return s.shardUnsubscribe(args), true
+	}
+	if !s.ps.isActive() {
+		return nil, false
+	}
+	if name == "ping" {
+		pong := redisPkg.Array{redisPkg.NewBulkStringFromString("pong"), redisPkg.NewBulkStringFromString("")}
+		if len(args) > 1 {
+			pong[1] = redisPkg.NewBulkStringFromString(args[1])
+		}
+		return &pong, true
+	}
+	return redisPkg.NewErrorFromString(fmt.Sprintf(pubSubOnlyMessage, name)), true
+}
+
+// sendClassic sends SUBSCRIBE, PSUBSCRIBE or their unsubscribe commands over the session's connection to the first master
+func (s *routedSession) sendClassic(command redisPkg.Componenter, subscribe bool) redisPkg.Componenter {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	if s.ps.classic == nil {
+		addr, ok := s.r.slotOwner(-1)
+		if !ok {
+			return redisPkg.NewErrorFromString(slotNotServed)
+		}
+		conn, err := s.r.backends.dial(addr.String())
+		if err != nil {
+			return s.r.backendError(err)
+		}
+		s.ps.classic = conn
+		go s.readPushes(conn, addr, false)
+	}
+	if err := s.sendSubscriber(s.ps.classic, command); err != nil {
+		// the connection's reader ends the session, as its subscriptions are lost
+		_ = s.ps.classic.Close()
+		return s.r.backendError(err)
+	}
+	if subscribe {
+		s.ps.active = true
+	}
+	return nil
+}
+
+// shardSubscribe sends SSUBSCRIBE to the master of the channels' slot, which must be the same for every channel
+func (s *routedSession) shardSubscribe(command redisPkg.Componenter, args []string) redisPkg.Componenter {
+	if len(args) < 2 {
+		return redisPkg.NewErrorFromString("ERR wrong number of arguments for 'ssubscribe' command")
+	}
+	slots := keySlots(args[1:])
+	if len(slots) > 1 {
+		s.r.metrics.Incr(metricRoutingCrossSlot)
+		return redisPkg.NewErrorFromString(crossSlotMessage)
+	}
+	addr, ok := s.r.slotOwner(slots[0])
+	if !ok {
+		return redisPkg.NewErrorFromString(slotNotServed)
+	}
+
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	conn, err := s.shardConn(addr)
+	if err == nil {
+		err = s.sendSubscriber(conn, command)
+	}
+	if err != nil {
+		return s.r.backendError(err)
+	}
+	for _, channel := range args[1:] {
+		if subscription, ok := s.ps.shards[channel]; !ok || subscription.unsubscribing {
+			s.ps.shards[channel] = &shardSubscription{slot: slots[0], addr: addr}
+		}
+	}
+	s.ps.active = true
+	return nil
+}
+
+// shardUnsubscribe sends SUNSUBSCRIBE to the nodes of the channels, or of every sharded channel without arguments. The
+// proxy answers for channels the client is not subscribed to itself
+func (s *routedSession) shardUnsubscribe(args []string) redisPkg.Componenter {
+	s.ps.mu.Lock()
+	channels := args[1:]
+	if len(channels) == 0 {
+		for channel := range s.ps.shards {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) == 0 {
+		s.ps.mu.Unlock()
+		return shardUnsubscribed(redisPkg.NewNullString(), 0)
+	}
+	byNode := make(map[ip_map.HostWithPort][]string)
+	var notSubscribed []string
+	for _, channel := range channels {
+		subscription, ok := s.ps.shards[channel]
+		if !ok || s.ps.shardConns[subscription.addr] == nil {
+			delete(s.ps.shards, channel)
+			notSubscribed = append(notSubscribed, channel)
+			continue
+		}
+		subscription.unsubscribing = true
+		byNode[subscription.addr] = append(byNode[subscription.addr], channel)
+	}
+	for addr, nodeChannels := range byNode {
+		if err := s.sendSubscriber(s.ps.shardConns[addr], argsToCommand(append([]string{args[0]}, nodeChannels...))); err != nil {
+			log.Println(err)
+			_ = s.ps.shardConns[addr].Close()
+			for _, channel := range nodeChannels {
+				delete(s.ps.shards, channel)
+			}
+			notSubscribed = append(notSubscribed, nodeChannels...)
+		}
+	}
+	count := s.ps.shardCount()
+	s.ps.updateActive()
+	s.ps.mu.Unlock()
+
+	for _, channel := range notSubscribed {
+		_ = s.deliver(shardUnsubscribed(redisPkg.NewBulkStringFromString(channel), count))
+	}
+	return nil
+}
+
+func shardUnsubscribed(channel redisPkg.Componenter, count int) redisPkg.Componenter {
+	return &redisPkg.Array{redisPkg.NewBulkStringFromString("sunsubscribe"), channel, redisPkg.NewIntFromInt(count)}
+}
+
+// shardConn returns the session's subscribed connection to the node at addr, opening it if needed. Callers hold mu
+func (s *routedSession) shardConn(addr ip_map.HostWithPort) (conn net.Conn, err error) {
+	if conn = s.ps.shardConns[addr]; conn != nil {
+		return
+	}
+	if conn, err = s.r.backends.dial(addr.String()); err != nil {
+		return
+	}
+	s.ps.shardConns[addr] = conn
+	go s.readPushes(conn, addr, true)
+	return
+}
+
+func (s *routedSession) sendSubscriber(conn net.Conn, command redisPkg.Componenter) error {
+	setWriteDeadline(conn, s.r.liveSettings().timeouts.Write)
+	return writeComponent(conn, command)
+}
+
+// readPushes passes the messages from a subscribed connection on to the client until the connection closes
+func (s *routedSession) readPushes(conn net.Conn, addr ip_map.HostWithPort, sharded bool) {
+	buffer := s.r.buffers.Get()
+	if buffer == nil {
+		_ = conn.Close()
+		s.subscriberLost(conn, addr, sharded, fmt.Errorf("ran out of buffers"))
+		return
+	}
+	defer s.r.buffers.Put(buffer)
+	for {
+		message, _, err := redisPkg.ComponentFromReader(conn, buffer)
+		if err != nil {
+			_ = conn.Close()
+			s.subscriberLost(conn, addr, sharded, err)
+			return
+		}
+		if sharded {
+			message = s.shardMessage(message, addr)
+		} else {
+			message = s.classicMessage(message)
+		}
+		if message == nil {
+			continue
+		}
+		if err = s.deliver(message); err != nil {
+			_ = s.client.Close()
+			return
+		}
+	}
+}
+
+// deliver writes a message from the cluster to the client, without the key namespace
+func (s *routedSession) deliver(message redisPkg.Componenter) error {
+	if message = s.namespace.reply(message); message == nil {
+		return nil
+	}
+	return s.write(message)
+}
+
+// subscriptionCount reads a confirmation of a subscribe or unsubscribe command: its kind, channel and count
+func subscriptionCount(message redisPkg.Componenter) (kind string, channel redisPkg.Componenter, count int, ok bool) {
+	array, isArray := message.(*redisPkg.Array)
+	if !isArray || len(*array) != 3 {
+		return
+	}
+	kindString, isBulk := (*array)[0].(*redisPkg.BulkString)
+	countInt, isInt := (*array)[2].(*redisPkg.Int)
+	if !isBulk || !isInt {
+		return
+	}
+	switch kind = strings.ToLower(kindString.String()); kind {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ssubscribe", "sunsubscribe":
+		return kind, (*array)[1], countInt.Int(), true
+	}
+	return "", nil, 0, false
+}
+
+func (s *routedSession) classicMessage(message redisPkg.Componenter) redisPkg.Componenter {
+	if _, _, count, ok := subscriptionCount(message); ok {
+		s.ps.mu.Lock()
+		s.ps.classicCount = count
+		s.ps.updateActive()
+		s.ps.mu.Unlock()
+	}
+	return message
+}
+
+// shardMessage follows the sharded channels of the node at addr. Nodes count only their own channels, so the count is
+// replaced by the session's. It returns nil for messages the client should not see, as when a channel is subscribed
+// again on the new master of its slot
+func (s *routedSession) shardMessage(message redisPkg.Componenter, addr ip_map.HostWithPort) redisPkg.Componenter {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	if target, ask, redirected := redirection(message); redirected && !ask {
+		// SSUBSCRIBE reached a node that no longer has the slot
+		s.r.metrics.Incr(metricRoutingRedirected)
+		s.r.RequestTopologyRefresh()
+		if len(target.Host) == 0 {
+			target.Host = addr.Host
+		}
+		slot, _ := strconv.Atoi(strings.Fields(message.(*redisPkg.ErrorComp).String())[1])
+		var pending []string
+		for channel, subscription := range s.ps.shards {
+			if subscription.addr == addr && subscription.slot == slot && (!subscription.confirmed || subscription.resubscribing) {
+				pending = append(pending, channel)
+			}
+		}
+		s.resubscribe(pending, target)
+		return nil
+	}
+	kind, channelComponent, _, ok := subscriptionCount(message)
+	if !ok {
+		return message
+	}
+	channel := ""
+	if bulk, isBulk := channelComponent.(*redisPkg.BulkString); isBulk {
+		channel = bulk.String()
+	}
+	subscription, tracked := s.ps.shards[channel]
+	if tracked && subscription.addr != addr {
+		// from a node the channel has since left
+		return nil
+	}
+	switch kind {
+	case "ssubscribe":
+		if tracked {
+			subscription.confirmed = true
+			if subscription.resubscribing {
+				subscription.resubscribing = false
+				return nil
+			}
+		}
+	case "sunsubscribe":
+		if tracked && !subscription.unsubscribing {
+			// the node dropped the channel as its slot moved away. Until the topology is refreshed the old node may still
+			// look like the owner, and it answers MOVED with the new one
+			s.r.RequestTopologyRefresh()
+			subscription.resubscribing = true
+			owner, ok := s.r.slotOwner(subscription.slot)
+			if !ok {
+				owner = addr
+			}
+			s.resubscribe([]string{channel}, owner)
+			return nil
+		}
+		delete(s.ps.shards, channel)
+	}
+	(*message.(*redisPkg.Array))[2] = redisPkg.NewIntFromInt(s.ps.shardCount())
+	s.ps.updateActive()
+	return message
+}
+
+// resubscribe sends SSUBSCRIBE for channels, all in one slot, to the node at addr. Callers hold mu
+func (s *routedSession) resubscribe(channels []string, addr ip_map.HostWithPort) {
+	if len(channels) == 0 {
+		return
+	}
+	for _, channel := range channels {
+		s.ps.shards[channel].addr = addr
+	}
+	s.r.metrics.Incr(metricRoutingResubscribed)
+	conn, err := s.shardConn(addr)
+	if err == nil {
+		err = s.sendSubscriber(conn, argsToCommand(append([]string{"SSUBSCRIBE"}, channels...)))
+	}
+	if err != nil {
+		log.Println(err)
+		if conn == nil {
+			s.resubscribeLater(channels)
+			return
+		}
+		// the connection's reader subscribes its channels again later
+		_ = conn.Close()
+	}
+}
+
+// resubscribeLater subscribes again to channels after resubscribeDelay, on the masters of their slots by then
+func (s *routedSession) resubscribeLater(channels []string) {
+	go func() {
+		select {
+		case <-s.ps.done:
+			return
+		case <-time.After(resubscribeDelay):
+		}
+		s.ps.mu.Lock()
+		defer s.ps.mu.Unlock()
+		byNode := make(map[ip_map.HostWithPort][]string)
+		for _, channel := range channels {
+			subscription, ok := s.ps.shards[channel]
+			if !ok || subscription.unsubscribing {
+				continue
+			}
+			if addr, ok := s.r.slotOwner(subscription.slot); ok {
+				subscription.addr = addr
+			}
+			byNode[subscription.addr] = append(byNode[subscription.addr], channel)
+		}
+		for addr, nodeChannels := range byNode {
+			// channels of different slots may share a node, and SSUBSCRIBE takes one slot at a time
+			bySlot := make(map[int][]string)
+			for _, channel := range nodeChannels {
+				bySlot[s.ps.shards[channel].slot] = append(bySlot[s.ps.shards[channel].slot], channel)
+			}
+			for _, slotChannels := range bySlot {
+				s.resubscribe(slotChannels, addr)
+			}
+		}
+	}()
+}
+
+// subscriberLost handles a subscribed connection that closed while the client was still there. Sharded channels are
+// subscribed again once the cluster has had time to fail over. The client is disconnected when the connection for
+// SUBSCRIBE and PSUBSCRIBE is lost, as there is no telling which of its messages were missed
+func (s *routedSession) subscriberLost(conn net.Conn, addr ip_map.HostWithPort, sharded bool, err error) {
+	select {
+	case <-s.ps.done:
+		return
+	default:
+	}
+	log.Printf("routing -> cluster[%s]: subscribed connection lost: %v", addr, err)
+	if !sharded {
+		_ = s.client.Close()
+		return
+	}
+	s.ps.mu.Lock()
+	if s.ps.shardConns[addr] == conn {
+		delete(s.ps.shardConns, addr)
+	}
+	var channels []string
+	for channel, subscription := range s.ps.shards {
+		if subscription.addr == addr {
+			subscription.resubscribing = subscription.confirmed
+			channels = append(channels, channel)
+		}
+	}
+	s.ps.mu.Unlock()
+	s.r.RequestTopologyRefresh()
+	s.resubscribeLater(channels)
+}
+
+// close closes the session's subscribed connections
+func (p *routedPubSub) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	close(p.done)
+	if p.classic != nil {
+		_ = p.classic.Close()
+	}
+	for _, conn := range p.shardConns {
+		_ = conn.Close()
+	}
+}
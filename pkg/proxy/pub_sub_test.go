@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"redis_cluster_proxy/pkg/redis"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRoutedPubSub(t *testing.T) {
+	// the slot of "moving", 14604, moves from the second node to the first after the channel is subscribed to
+	moved := &atomic.Bool{}
+	cluster := newFakeCluster(map[string]func(args []string) string{
+		"10.0.0.1:7000": func(args []string) string {
+			switch strings.Join(args, " ") {
+			case "SUBSCRIBE news":
+				return "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n"
+			case "SSUBSCRIBE moving":
+				return "*3\r\n$10\r\nssubscribe\r\n$6\r\nmoving\r\n:1\r\n"
+			case "SUNSUBSCRIBE moving":
+				return "*3\r\n$12\r\nsunsubscribe\r\n$6\r\nmoving\r\n:0\r\n"
+			}
+			return "-ERR unexpected\r\n"
+		},
+		"10.0.0.2:7000": func(args []string) string {
+			if moved.Swap(true) {
+				return "-MOVED 14604 10.0.0.1:7000\r\n"
+			}
+			return "*3\r\n$10\r\nssubscribe\r\n$6\r\nmoving\r\n:1\r\n*3\r\n$12\r\nsunsubscribe\r\n$6\r\nmoving\r\n:0\r\n"
+		},
+	})
+	r := newRoutingRedis(cluster)
+	session := r.newRoutedSession(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	clientSide, proxySide := net.Pipe()
+	session.client = proxySide
+	defer session.close()
+
+	send := func(words ...string) string {
+		reply := session.handle(commandFromWords(words...), 0)
+		if reply == nil {
+			return ""
+		}
+		return componentToString(t, reply)
+	}
+	// next reads the next message pushed to the client, or returns "" if none comes
+	next := func(wait time.Duration) string {
+		_ = clientSide.SetReadDeadline(time.Now().Add(wait))
+		message, _, err := redis.ComponentFromReader(clientSide, make([]byte, BufferSizeBytes))
+		if err != nil {
+			return ""
+		}
+		return componentToString(t, message)
+	}
+
+	assert.Equal(t, "", send("SUBSCRIBE", "news"), "confirmed by a message")
+	assert.Equal(t, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n", next(time.Second))
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n", next(time.Second))
+	assert.Equal(t, "-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n", send("GET", "bar"))
+	assert.Equal(t, "*2\r\n$4\r\npong\r\n$0\r\n\r\n", send("PING"))
+
+	assert.Equal(t, "", send("SSUBSCRIBE", "moving"))
+	assert.Equal(t, "*3\r\n$10\r\nssubscribe\r\n$6\r\nmoving\r\n:1\r\n", next(time.Second))
+	assert.Eventually(t, func() bool {
+		return len(cluster.commands("10.0.0.1:7000")) == 2
+	}, time.Second, 10*time.Millisecond, "subscribed again on the new master")
+	assert.Equal(t, []string{"SSUBSCRIBE moving", "SSUBSCRIBE moving"}, cluster.commands("10.0.0.2:7000"), "the old master answered MOVED")
+
+	assert.Equal(t, "", send("SUNSUBSCRIBE", "moving"))
+	assert.Equal(t, "*3\r\n$12\r\nsunsubscribe\r\n$6\r\nmoving\r\n:0\r\n", next(time.Second))
+	assert.Equal(t, "", next(100*time.Millisecond), "the client is not told about the move")
+	assert.Equal(t, []string{"SUBSCRIBE news", "SSUBSCRIBE moving", "SUNSUBSCRIBE moving"}, cluster.commands("10.0.0.1:7000"))
+}
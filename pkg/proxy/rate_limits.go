@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"net"
+	"redis_cluster_proxy/pkg/rate_limit"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimits throttle the commands clients send to the cluster. A zero rate is unlimited
+type RateLimits struct {
+	// PerClient applies to all the connections from one client IP
+	PerClient Limit
+	// PerUser applies to all the connections authenticated as the same user: the proxy user, or else the user of the last AUTH
+	// or HELLO AUTH the cluster accepted. Clients that have not authenticated share the "default" user, as in Redis
+	PerUser Limit
+	// Reject replies "-ERR rate limited" to commands over the limit. Otherwise they are delayed until the limit allows them
+	Reject bool
+}
+
+// Limit is a sustained rate, with a burst of one second's worth
+type Limit struct {
+	CommandsPerSecond float64
+	BytesPerSecond    float64
+}
+
+// Metric names for rate limiting
+const (
+	metricRateLimitDelayed  = "rate_limit.delayed"
+	metricRateLimitRejected = "rate_limit.rejected"
+)
+
+// defaultUser is the user of clients that have not sent AUTH
+const defaultUser = "default"
+
+// rateLimiter holds the buckets for every client and user. It is replaced, not changed, when the limits are
+type rateLimiter struct {
+	limits         RateLimits
+	clientCommands *rate_limit.Keyed
+	clientBytes    *rate_limit.Keyed
+	userCommands   *rate_limit.Keyed
+	userBytes      *rate_limit.Keyed
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	return &rateLimiter{
+		limits:         limits,
+		clientCommands: newKeyedBuckets(limits.PerClient.CommandsPerSecond),
+		clientBytes:    newKeyedBuckets(limits.PerClient.BytesPerSecond),
+		userCommands:   newKeyedBuckets(limits.PerUser.CommandsPerSecond),
+		userBytes:      newKeyedBuckets(limits.PerUser.BytesPerSecond),
+	}
+}
+
+func newKeyedBuckets(rate float64) *rate_limit.Keyed {
+	if rate <= 0 {
+		return nil
+	}
+	return rate_limit.NewKeyed(rate, rate)
+}
+
+// SetRateLimits replaces the rate limits. Connections that are already open switch to the new limits, starting with full buckets
+func (r *Redis) SetRateLimits(limits RateLimits) {
+	var limiter *rateLimiter
+	if limits.PerClient != (Limit{}) || limits.PerUser != (Limit{}) {
+		limiter = newRateLimiter(limits)
+	}
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.rateLimiter = limiter
+}
+
+// clientRateLimit limits the commands of one client connection
+type clientRateLimit struct {
+	r          *Redis
+	clientHost string
+	acl        *aclSession
+	// mu guards user, which is set by the side reading the cluster's replies
+	mu   sync.Mutex
+	user string
+}
+
+// newClientRateLimit starts limiting a client connecting from clientAddr. The client counts as the proxy user it is logged
+// in as, if any, and otherwise as the user the cluster last accepted
+func (r *Redis) newClientRateLimit(clientAddr net.Addr, acl *aclSession) *clientRateLimit {
+	clientHost := clientAddr.String()
+	if host, _, err := net.SplitHostPort(clientHost); err == nil {
+		clientHost = host
+	}
+	return &clientRateLimit{r: r, clientHost: clientHost, acl: acl, user: defaultUser}
+}
+
+// replied switches to the user of an AUTH or HELLO AUTH once it succeeded
+func (l *clientRateLimit) replied(command, reply redisPkg.Componenter) {
+	if isError(reply) {
+		return
+	}
+	if authUser, ok := authenticatedUser(command); ok {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.user = authUser
+	}
+}
+
+// currentUser is the user whose buckets the next command is taken from
+func (l *clientRateLimit) currentUser() string {
+	if name, ok := l.acl.userName(); ok {
+		return name
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.user
+}
+
+func (l *clientRateLimit) admit(componenter redisPkg.Componenter, byteCount int) (forward, reply redisPkg.Componenter) {
+	limiter := l.r.liveSettings().rateLimiter
+	if limiter == nil {
+		return componenter, nil
+	}
+	user := l.currentUser()
+	buckets := make([]*rate_limit.Bucket, 0, 4)
+	amounts := make([]float64, 0, 4)
+	add := func(keyed *rate_limit.Keyed, key string, amount float64) {
+		if keyed != nil {
+			buckets = append(buckets, keyed.For(key))
+			amounts = append(amounts, amount)
+		}
+	}
+	add(limiter.clientCommands, l.clientHost, 1)
+	add(limiter.clientBytes, l.clientHost, float64(byteCount))
+	add(limiter.userCommands, user, 1)
+	add(limiter.userBytes, user, float64(byteCount))
+
+	if limiter.limits.Reject {
+		if !rate_limit.TakeAll(buckets, amounts) {
+			l.r.metrics.Incr(metricRateLimitRejected)
+			return nil, redisPkg.NewErrorFromString("ERR rate limited")
+		}
+		return componenter, nil
+	}
+
+	var wait time.Duration
+	for i, bucket := range buckets {
+		if bucketWait := bucket.Reserve(amounts[i]); bucketWait > wait {
+			wait = bucketWait
+		}
+	}
+	if wait > 0 {
+		l.r.metrics.Incr(metricRateLimitDelayed)
+		time.Sleep(wait)
+	}
+	return componenter, nil
+}
+
+// authenticatedUser returns the user named by AUTH [username] password or HELLO protover AUTH username password
+func authenticatedUser(componenter redisPkg.Componenter) (user string, ok bool) {
+	name, _ := commandName(componenter)
+	if name != "auth" && name != "hello" {
+		return
+	}
+	args, isCommand := commandArgs(componenter)
+	if !isCommand {
+		return
+	}
+	switch {
+	case name == "auth" && len(args) == 2:
+		return defaultUser, true
+	case name == "auth" && len(args) == 3:
+		return args[1], true
+	case name == "hello":
+		for i := 2; i+2 < len(args); i++ {
+			if strings.EqualFold(args[i], "AUTH") {
+				return args[i+1], true
+			}
+		}
+	}
+	return
+}
+
+func isError(reply redisPkg.Componenter) bool {
+	_, ok := reply.(*redisPkg.ErrorComp)
+	return ok
+}
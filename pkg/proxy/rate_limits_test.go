@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/port_pool"
+	"redis_cluster_proxy/pkg/redis"
+	"testing"
+)
+
+func TestAuthenticatedUser(t *testing.T) {
+	cases := map[string]struct {
+		command      string
+		expectedUser string
+		expectedOk   bool
+	}{
+		"password only": {
+			command:      "*2\r\n$4\r\nAUTH\r\n$6\r\nsecret\r\n",
+			expectedUser: defaultUser,
+			expectedOk:   true,
+		},
+		"username and password": {
+			command:      "*3\r\n$4\r\nauth\r\n$5\r\nteam1\r\n$6\r\nsecret\r\n",
+			expectedUser: "team1",
+			expectedOk:   true,
+		},
+		"hello": {
+			command:      "*5\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$4\r\nAUTH\r\n$5\r\nteam2\r\n$6\r\nsecret\r\n",
+			expectedUser: "team2",
+			expectedOk:   true,
+		},
+		"hello without auth": {
+			command: "*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n",
+		},
+		"other command": {
+			command: "*2\r\n$3\r\nGET\r\n$4\r\nAUTH\r\n",
+		},
+	}
+
+	for caseName, c := range cases {
+		command, err := stringToComponents(c.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, ok := authenticatedUser(command)
+		assert.Equal(t, c.expectedUser, user, caseName)
+		assert.Equal(t, c.expectedOk, ok, caseName)
+	}
+}
+
+func TestRateLimitReject(t *testing.T) {
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	r.SetRateLimits(RateLimits{PerClient: Limit{CommandsPerSecond: 2}, Reject: true})
+	admit := r.newClientRateLimit(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}, r.newACLSession()).admit
+	otherClient := r.newClientRateLimit(&net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 50000}, r.newACLSession()).admit
+	command, err := stringToComponents("*1\r\n$4\r\nPING\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := func(admit AdmitFunc) redis.Componenter {
+		_, reply := admit(command, 14)
+		return reply
+	}
+	assert.Nil(t, reply(admit))
+	assert.Nil(t, reply(admit))
+	assert.Equal(t, "-ERR rate limited\r\n", componentToString(t, reply(admit)))
+	assert.Nil(t, reply(otherClient), "limits are per client IP")
+	assert.Equal(t, uint64(1), r.metrics.Get(metricRateLimitRejected))
+
+	r.SetRateLimits(RateLimits{})
+	assert.Nil(t, reply(admit), "limits can be removed from open connections")
+}
+
+func TestRateLimitUserSwitchesOnSuccess(t *testing.T) {
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	r.SetRateLimits(RateLimits{PerUser: Limit{CommandsPerSecond: 1}, Reject: true})
+	limit := r.newClientRateLimit(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}, r.newACLSession())
+	ping := commandFromWords("PING")
+	auth := commandFromWords("AUTH", "team1", "secret")
+
+	_, reply := limit.admit(ping, 14)
+	assert.Nil(t, reply)
+	_, reply = limit.admit(auth, 40)
+	assert.NotNil(t, reply, "the default user's bucket is empty")
+
+	limit.replied(auth, redis.NewErrorFromString(wrongPassMessage))
+	_, reply = limit.admit(ping, 14)
+	assert.NotNil(t, reply, "a failed AUTH keeps the default user")
+
+	limit.replied(auth, redis.NewSimpleStringFromString("OK"))
+	_, reply = limit.admit(ping, 14)
+	assert.Nil(t, reply, "team1 has a bucket of its own")
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"redis_cluster_proxy/pkg/command_table"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+)
+
+// Metric names for read-only mode
+const (
+	metricReadOnlyRejected = "read_only.rejected"
+	// metricReadOnlyUnknown counts commands rejected because the command table does not say whether they write
+	metricReadOnlyUnknown = "read_only.unknown"
+)
+
+// readOnlyMessage is worded like the error a read-only replica sends
+const readOnlyMessage = "READONLY You can't write against a read only proxy."
+
+// SetReadOnly makes the proxy reject every command that may change data or the nodes. Applies to commands sent after the call,
+// including on connections that are already open
+func (r *Redis) SetReadOnly(readOnly bool) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.readOnly = readOnly
+}
+
+// mayWrite is true unless the command table says the command cannot change data. Scripts and functions that may write,
+// such as EVAL, count as writes; their _RO variants do not. Admin commands, such as SHUTDOWN, CONFIG SET or CLUSTER FAILOVER,
+// count as writes too, as they change the node rather than its data. Commands missing from the table count as writes, so
+// that read-only stays safe when clients use commands newer than the table
+func mayWrite(table *command_table.Table, args []string) (write, known bool) {
+	spec, known := table.Lookup(args)
+	if !known {
+		return true, false
+	}
+	return spec.HasAny(command_table.Write | command_table.MayReplicate | command_table.Admin), true
+}
+
+// readOnlyAdmitter rejects client commands that may write while the proxy is read-only
+func (r *Redis) readOnlyAdmitter() AdmitFunc {
+	return func(componenterIn redisPkg.Componenter, _ int) (forward, reply redisPkg.Componenter) {
+		if !r.liveSettings().readOnly {
+			return componenterIn, nil
+		}
+		args, ok := commandArgs(componenterIn)
+		if !ok || len(args) == 0 {
+			return componenterIn, nil
+		}
+		write, known := mayWrite(r.commandTable(), args)
+		if !write {
+			return componenterIn, nil
+		}
+		if known {
+			r.metrics.Incr(metricReadOnlyRejected)
+		} else {
+			r.metrics.Incr(metricReadOnlyUnknown)
+		}
+		return nil, redisPkg.NewErrorFromString(readOnlyMessage)
+	}
+}
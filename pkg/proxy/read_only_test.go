@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/port_pool"
+	"testing"
+)
+
+func TestReadOnlyAdmitter(t *testing.T) {
+	cases := map[string]struct {
+		words          []string
+		expectedReject bool
+		expectedMetric string
+	}{
+		"read": {
+			words: []string{"GET", "k"},
+		},
+		"write": {
+			words:          []string{"SET", "k", "v"},
+			expectedReject: true,
+			expectedMetric: metricReadOnlyRejected,
+		},
+		"script that may write": {
+			words:          []string{"EVAL", "return 1", "0"},
+			expectedReject: true,
+			expectedMetric: metricReadOnlyRejected,
+		},
+		"read only script": {
+			words: []string{"EVAL_RO", "return 1", "0"},
+		},
+		"write subcommand": {
+			words:          []string{"FUNCTION", "LOAD", "code"},
+			expectedReject: true,
+			expectedMetric: metricReadOnlyRejected,
+		},
+		"shutdown": {
+			words:          []string{"SHUTDOWN", "NOSAVE"},
+			expectedReject: true,
+			expectedMetric: metricReadOnlyRejected,
+		},
+		"config set": {
+			words:          []string{"CONFIG", "SET", "maxmemory", "1"},
+			expectedReject: true,
+			expectedMetric: metricReadOnlyRejected,
+		},
+		"cluster failover": {
+			words:          []string{"CLUSTER", "FAILOVER"},
+			expectedReject: true,
+			expectedMetric: metricReadOnlyRejected,
+		},
+		"client kill": {
+			words:          []string{"CLIENT", "KILL", "ID", "1"},
+			expectedReject: true,
+			expectedMetric: metricReadOnlyRejected,
+		},
+		"client getname": {
+			words: []string{"CLIENT", "GETNAME"},
+		},
+		"unknown command": {
+			words:          []string{"MODULE.WRITE", "k"},
+			expectedReject: true,
+			expectedMetric: metricReadOnlyUnknown,
+		},
+	}
+
+	for caseName, c := range cases {
+		r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+		r.SetReadOnly(true)
+		command := commandFromWords(c.words...)
+		forward, reply := r.readOnlyAdmitter()(command, 0)
+		if c.expectedReject {
+			assert.Nil(t, forward, caseName)
+			assert.Equal(t, "-READONLY You can't write against a read only proxy.\r\n", componentToString(t, reply), caseName)
+			assert.Equal(t, uint64(1), r.metrics.Get(c.expectedMetric), caseName)
+		} else {
+			assert.Nil(t, reply, caseName)
+			assert.Equal(t, command, forward, caseName)
+		}
+
+		r.SetReadOnly(false)
+		_, reply = r.readOnlyAdmitter()(command, 0)
+		assert.Nil(t, reply, caseName)
+	}
+}
@@ -2,46 +2,109 @@ package proxy
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"github.com/wojnosystems/retry"
 	"io"
 	"log"
 	"net"
+	"redis_cluster_proxy/pkg/command_table"
 	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/metrics"
 	"redis_cluster_proxy/pkg/port_pool"
 	redisPkg "redis_cluster_proxy/pkg/redis"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Redis struct {
 	listenAddr             ip_map.HostWithPort
 	clusterAddr            ip_map.HostWithPort
-	publicHostname         string
 	ipMap                  *ip_map.Concurrent
 	portCounter            port_pool.Counter
+	staticPorts            *port_pool.Static
 	listenIPs              []net.IP
 	clusterIPs             []net.IP
 	facadeClusterSlotsResp []redisPkg.ClusterSlotResp
 	facadeClusterNodesResp []redisPkg.ClusterNodeResp
-	listeners              []net.Listener
+	listeners              map[uint16]*nodeListener
+	topologyMu             *sync.RWMutex
+	refreshNow             chan struct{}
+	closed                 chan struct{}
+	closeOnce              *sync.Once
 	buffers                *bufferPool
 	readBufferByteSize     int
-	debugOutputEnabled     bool
+	metrics                *metrics.Counters
+	settingsMu             *sync.RWMutex
+	settings               liveSettings
+	// sentinel is set when the proxy fronts a Sentinel-managed deployment instead of a Redis Cluster
+	sentinel *sentinelTopology
+	// commands describes the commands the cluster understands, guarded by settingsMu. The bundled table until loadCommandTable
+	commands *command_table.Table
+	// routeAddr is the single endpoint where the proxy routes commands itself. A zero Port disables it
+	routeAddr     ip_map.HostWithPort
+	routeListener net.Listener
+	// backends are the connections to the cluster nodes shared by the clients of the routing endpoint
+	backends *connPool
+	// blockingBackends are kept apart for blocking commands, so that clients waiting on them cannot use up backends
+	blockingBackends *connPool
+	// scripts are the bodies of the scripts sent to the routing endpoint, to load them again on nodes that answer NOSCRIPT
+	scripts *scriptCache
+}
+
+// liveSettings can be changed while the proxy is running without dropping client connections
+type liveSettings struct {
+	// horizons are the networks clients connect from. The first is the listenAddr host paired with the public hostname
+	horizons           []Horizon
+	debugOutputEnabled bool
+	timeouts           Timeouts
+	credentials        Credentials
+	// sentinelCredentials are used instead of credentials when dialing a Sentinel
+	sentinelCredentials Credentials
+	// commandRules reject commands before they reach the cluster. nil allows everything
+	commandRules *CommandRules
+	// accessList decides which client IPs may connect. nil lets everyone in
+	accessList *AccessList
+	// rateLimiter throttles the commands clients send. nil is unlimited
+	rateLimiter *rateLimiter
+	// users authenticate clients at the proxy. nil passes AUTH through to the cluster
+	users *Users
+	// readOnly rejects every command that may write
+	readOnly bool
+	// keyPrefix is the key namespace of clients whose user has none. Empty is off
+	keyPrefix string
+	// scriptNode runs the scripts and functions sent to the routing endpoint that declare no keys. A zero Port rejects them
+	scriptNode  ip_map.HostWithPort
+	listenerTLS *tls.Config
+	clusterTLS  *tls.Config
 }
 
 func NewRedis(listenAddr, clusterAddr ip_map.HostWithPort, publicHostname string, portKeeper port_pool.Counter, numberOfBuffers int, maxConcurrentConnections int, readBufferByteSize int) (redis *Redis) {
 	ret := &Redis{
 		listenAddr:         listenAddr,
 		clusterAddr:        clusterAddr,
-		publicHostname:     publicHostname,
 		ipMap:              ip_map.NewConcurrent(),
 		portCounter:        portKeeper,
+		staticPorts:        port_pool.NewStatic(),
 		readBufferByteSize: readBufferByteSize,
-		listeners:          make([]net.Listener, 0, 6),
+		listeners:          make(map[uint16]*nodeListener),
+		topologyMu:         &sync.RWMutex{},
+		refreshNow:         make(chan struct{}, 1),
+		closed:             make(chan struct{}),
+		closeOnce:          &sync.Once{},
 		buffers:            newBufferPool(numberOfBuffers, readBufferByteSize),
+		metrics:            metrics.NewCounters(),
+		settingsMu:         &sync.RWMutex{},
+		commands:           command_table.Bundled(),
+		settings: liveSettings{
+			horizons: []Horizon{{BindHost: listenAddr.Host, PublicHost: unbracketed(publicHostname)}},
+		},
 	}
+	ret.backends = newConnPool(maxConcurrentConnections, ret.dialBackend)
+	ret.blockingBackends = newConnPool(maxConcurrentConnections, ret.dialBackend)
+	ret.scripts = newScriptCache()
 
 	return ret
 }
@@ -69,7 +132,7 @@ func (r *Redis) DiscoverAndListen() (err error) {
 	var cluster net.Conn
 
 	err = retry.How(MaxConnectRetries.New()).This(func(controller retry.ServiceController) error {
-		cluster, err = net.Dial("tcp", r.clusterAddr.String())
+		cluster, err = r.dialCluster(r.clusterAddr)
 		return err
 	})
 	if err != nil {
@@ -79,86 +142,14 @@ func (r *Redis) DiscoverAndListen() (err error) {
 	// close the connection to Redis
 	defer func() { _ = cluster.Close() }()
 
-	{
-		_, err = cluster.Write([]byte(ClusterSlotsDiscoverStatement))
-		if err != nil && io.EOF != err {
-			log.Println("io error while writing to cluster socket: " + err.Error())
-			return err
-		}
-
-		buffer := r.buffers.Get()
-		if buffer == nil {
-			err = fmt.Errorf("ran out of buffers")
-			return err
-		}
-		defer r.buffers.Put(buffer)
-		responseComponent, _, err := redisPkg.ComponentFromReader(cluster, buffer)
-		if err != nil && io.EOF != err {
-			log.Println("io error while reading cluster socket: " + err.Error())
-			return err
-		}
-		r.facadeClusterSlotsResp, err = redisPkg.NewSlotArrayFromComponent(responseComponent)
-		if err != nil {
-			log.Println("Unable to read the cluster response: " + err.Error())
-			return err
-		}
-	}
-
-	{
-		_, err = cluster.Write([]byte(ClusterNodeDiscoverStatement))
-		if err != nil && io.EOF != err {
-			log.Println("io error while writing to cluster socket: " + err.Error())
-			return err
-		}
-
-		buffer := r.buffers.Get()
-		if buffer == nil {
-			err = fmt.Errorf("ran out of buffers")
-			return err
-		}
-		defer r.buffers.Put(buffer)
-		responseComponent, _, err := redisPkg.ComponentFromReader(cluster, buffer)
-		if err != nil && io.EOF != err {
-			log.Println("io error while reading cluster socket: " + err.Error())
-			return err
-		}
-		r.facadeClusterNodesResp, err = redisPkg.NewClusterNodesRespFromComponent(responseComponent)
-		if err != nil {
-			log.Println("Unable to read the cluster response: " + err.Error())
-			return err
-		}
+	if r.sentinel == nil {
+		r.loadCommandTable(cluster)
 	}
-
-	serverAddrs := serverAddrAndPortFromSlotResp(r.facadeClusterSlotsResp)
-	for _, serverAddr := range serverAddrs {
-		if _, exists := r.ipMap.RemoteToLocal(serverAddr); exists {
-			// already exists, do nothing
-		} else {
-			// No mapping exists, open a socket to service it
-			var nodeListener net.Listener
-			newListenerAddr := ip_map.HostWithPort{
-				Host: r.listenAddr.Host, // we don't want to bind to any hostname, bind to all available interfaces
-				Port: 0,
-			}
-			newListenerAddr.Port, err = r.portCounter.Next()
-			if err != nil {
-				return
-			}
-			nodeListener, err = net.Listen("tcp", newListenerAddr.String())
-			if err != nil {
-				return
-			}
-			r.listeners = append(r.listeners, nodeListener)
-			// create the mapping entry for the new socket
-			hostAndPort, _ := ip_map.NewHostWithPortFromString(nodeListener.Addr().String())
-			r.ipMap.Create(serverAddr, hostAndPort.Port)
-
-			go func(nodeListener net.Listener, newListenerAddr ip_map.HostWithPort) {
-				_ = listenLoop(nodeListener, r, newListenerAddr)
-			}(nodeListener, newListenerAddr)
-		}
+	err = r.refreshTopology(cluster)
+	if err != nil {
+		return
 	}
-	return nil
+	return r.listenForRouting()
 }
 
 func (r *Redis) resolveClusterAddrIP() (err error) {
@@ -197,49 +188,191 @@ func (r Redis) PrintConnectionStatuses(writer io.Writer) (err error) {
 			return
 		}
 	}
+	if r.routeAddr.Port != 0 {
+		_, err = fmt.Fprintf(writer, "Routing on: %s\n", r.routeAddr.String())
+	}
 	return
 }
 
-func serverAddrAndPortFromSlotResp(clusterSlotResponses []redisPkg.ClusterSlotResp) (hostAndPort []ip_map.HostWithPort) {
-	hostAndPort = make([]ip_map.HostWithPort, 0, 10)
+func serversFromSlotResp(clusterSlotResponses []redisPkg.ClusterSlotResp) (servers []redisPkg.ClusterServerResp) {
+	servers = make([]redisPkg.ClusterServerResp, 0, 10)
 	for _, slot := range clusterSlotResponses {
-		for _, server := range slot.Servers() {
-			hostAndPort = append(hostAndPort, ip_map.HostWithPort{Host: server.Ip(), Port: server.Port()})
+		servers = append(servers, slot.Servers()...)
+	}
+	return servers
+}
+
+// SetStaticPorts pins nodes to fixed local ports. Nodes without a pinned port get the next port from the port counter
+func (r *Redis) SetStaticPorts(staticPorts *port_pool.Static) {
+	r.staticPorts = staticPorts
+}
+
+// releasePort returns a port to the counter. Statically pinned ports never came from the counter and are left alone
+func (r *Redis) releasePort(port uint16) {
+	if !r.staticPorts.IsReserved(port) {
+		r.portCounter.Release(port)
+	}
+}
+
+// allocatePort picks the local port for a cluster node: its pinned port if it has one, otherwise the next port from the counter that is not pinned to another node
+func (r *Redis) allocatePort(nodeId string, serverAddr ip_map.HostWithPort) (port uint16, err error) {
+	if port, ok := r.staticPorts.PortFor(nodeId, serverAddr.String()); ok {
+		return port, nil
+	}
+	for {
+		port, err = r.portCounter.Next()
+		if err != nil || !r.staticPorts.IsReserved(port) {
+			return
 		}
 	}
-	return hostAndPort
 }
 
 func (r *Redis) Close() (err error) {
-	for _, listener := range r.listeners {
-		closeErr := listener.Close()
-		if err == nil {
-			err = closeErr
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		r.topologyMu.Lock()
+		defer r.topologyMu.Unlock()
+		for _, listener := range r.listeners {
+			closeErr := listener.Close()
+			if err == nil {
+				err = closeErr
+			}
 		}
-	}
+		if r.routeListener != nil {
+			closeErr := r.routeListener.Close()
+			if err == nil {
+				err = closeErr
+			}
+		}
+		r.backends.Close()
+		r.blockingBackends.Close()
+	})
 	return
 }
 
 func (r *Redis) SetDebug(enabled bool) {
-	r.debugOutputEnabled = enabled
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.debugOutputEnabled = enabled
+}
+
+// SetPublicHostname changes the host advertised to clients that connect through the listenAddr host in CLUSTER SLOTS, CLUSTER NODES and MOVED replies
+func (r *Redis) SetPublicHostname(publicHostname string) {
+	r.SetHorizonPublicHost(r.listenAddr.Host, publicHostname)
 }
 
-func listenLoop(listener net.Listener, r *Redis, localAddr ip_map.HostWithPort) error {
+// SetTimeouts changes the timeouts. Connections that are already open keep the timeouts they started with
+func (r *Redis) SetTimeouts(timeouts Timeouts) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.timeouts = timeouts
+}
+
+// Metrics are the counters the proxy keeps, such as the number of connections closed for each timeout reason
+func (r *Redis) Metrics() *metrics.Counters {
+	return r.metrics
+}
+
+func (r Redis) PrintMetrics(writer io.Writer) (err error) {
+	return r.metrics.Print(writer)
+}
+
+// SetCredentials sets the username and password the proxy uses to AUTH with the cluster nodes
+func (r *Redis) SetCredentials(credentials Credentials) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.credentials = credentials
+}
+
+// SetListenerTLS makes the listeners terminate TLS from clients. nil accepts plain TCP. Only new connections are affected
+func (r *Redis) SetListenerTLS(tlsConfig *tls.Config) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.listenerTLS = tlsConfig
+}
+
+// SetClusterTLS makes the proxy use TLS when it dials the cluster nodes. nil dials plain TCP
+func (r *Redis) SetClusterTLS(tlsConfig *tls.Config) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.clusterTLS = tlsConfig
+}
+
+// liveSettings returns a copy of the settings that may be changed while the proxy is running
+func (r *Redis) liveSettings() liveSettings {
+	r.settingsMu.RLock()
+	defer r.settingsMu.RUnlock()
+	return r.settings
+}
+
+func (r *Redis) isDebugEnabled() bool {
+	return r.liveSettings().debugOutputEnabled
+}
+
+// dialCluster opens a connection to a node in the cluster, applying the configured keep-alive, TLS and credentials
+func (r *Redis) dialCluster(clusterAddr ip_map.HostWithPort) (conn net.Conn, err error) {
+	settings := r.liveSettings()
+	dialer := &net.Dialer{Timeout: clusterDialTimeout}
+	if settings.timeouts.KeepAlive > 0 {
+		dialer.KeepAlive = settings.timeouts.KeepAlive
+	}
+	if settings.clusterTLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", clusterAddr.String(), settings.clusterTLS)
+	} else {
+		conn, err = dialer.Dial("tcp", clusterAddr.String())
+	}
+	if err != nil {
+		return
+	}
+	credentials := settings.credentials
+	if r.isSentinelAddr(clusterAddr) {
+		credentials = settings.sentinelCredentials
+	}
+	if credentials.IsSet() {
+		// a node that accepts the connection but never answers must not hold up the topology refresh or routing clients
+		_ = conn.SetDeadline(time.Now().Add(clusterDialTimeout))
+		err = authenticate(conn, credentials)
+		if err != nil {
+			_ = conn.Close()
+			if isTimeout(err) {
+				err = fmt.Errorf("unable to AUTH with the cluster at %s: no reply within %s", clusterAddr.String(), clusterDialTimeout)
+			}
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+	}
+	return
+}
+
+func listenLoop(listener net.Listener, tracked *nodeListener, r *Redis, localAddr ip_map.HostWithPort, horizonIndex int) error {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			return err
 		}
+		settings := r.liveSettings()
+		if !r.admitConnection(conn, settings.accessList) {
+			_ = conn.Close()
+			continue
+		}
+		setKeepAlive(conn, settings.timeouts.KeepAlive)
+		if settings.listenerTLS != nil {
+			conn = tls.Server(conn, settings.listenerTLS)
+		}
 		go func(conn net.Conn) {
-			err = proxyConnection(conn, r, localAddr)
+			err := proxyConnection(conn, r, tracked, localAddr, horizonIndex)
 			if err != nil {
+				if metricName := timeoutMetricName(err); metricName != "" {
+					r.metrics.Incr(metricName)
+				}
 				log.Println(err)
 			}
 		}(conn)
 	}
 }
 
-func proxyConnection(conn net.Conn, r *Redis, localAddr ip_map.HostWithPort) (err error) {
+// proxyConnection forwards a client to the cluster node behind localAddr. horizonIndex is the network the client connected through, which decides the public host it is sent
+func proxyConnection(conn net.Conn, r *Redis, listener *nodeListener, localAddr ip_map.HostWithPort, horizonIndex int) (err error) {
 	defer func() { _ = conn.Close() }()
 	clusterAddr, err := localToRemoteHostAndPort(r.ipMap, localAddr.Port)
 	if err != nil {
@@ -247,11 +380,13 @@ func proxyConnection(conn net.Conn, r *Redis, localAddr ip_map.HostWithPort) (er
 	}
 
 	var clusterConn net.Conn
-	clusterConn, err = net.Dial("tcp", clusterAddr.String())
+	clusterConn, err = r.dialCluster(clusterAddr)
 	if err != nil {
 		return
 	}
 	defer func() { _ = clusterConn.Close() }()
+	listener.track(conn, clusterConn)
+	defer listener.untrack(conn)
 
 	buffer1, buffer2, err := allocateBufferPair(r.buffers)
 	if err != nil {
@@ -264,24 +399,67 @@ func proxyConnection(conn net.Conn, r *Redis, localAddr ip_map.HostWithPort) (er
 
 	doneChan := make(chan error, 2)
 
-	Bidirectional(conn, clusterConn, func(componenterIn redisPkg.Componenter) (componenterOut redisPkg.Componenter) {
-		if componenterOut = mutateClusterSlotsCommand(componenterIn, r.facadeClusterSlotsResp, r.publicHostname, r.ipMap); nil != componenterOut {
+	intercept, reWrite := r.clusterRewriters(horizonIndex)
+	if r.sentinel != nil {
+		intercept, reWrite = r.sentinelRewriters(clusterAddr, horizonIndex)
+	}
+	acl := r.newACLSession()
+	rateLimit := r.newClientRateLimit(conn.RemoteAddr(), acl)
+	hooks := ClientHooks{
+		Admit: chainAdmit(rateLimit.admit, acl.admit, r.commandRulesAdmitter(), r.readOnlyAdmitter()),
+	}
+	hooks.Forwarded = acl.forwarded
+	if !r.isSentinelAddr(clusterAddr) {
+		namespace := r.newKeyNamespace(acl)
+		hooks = ClientHooks{
+			Admit: chainAdmit(hooks.Admit, namespace.admit),
+			Forwarded: func(command redisPkg.Componenter) {
+				acl.forwarded(command)
+				namespace.forwarded(command)
+			},
+			Reply: namespace.reply,
+		}
+	}
+	hooks.Replied = func(command, reply redisPkg.Componenter) error {
+		rateLimit.replied(command, reply)
+		return acl.replied(command, reply)
+	}
+	hooks.BlockTimeout = r.blockTimeout
+	hooks.Farewell = listener.farewell
+	Bidirectional(conn, clusterConn, intercept, reWrite, hooks, buffer1, buffer2, doneChan, r.liveSettings().timeouts, r.isDebugEnabled)
+
+	// the first side to finish reports why the connection ended. Close both sockets so the other side stops using its buffer before it is returned to the pool
+	err = <-doneChan
+	acl.close()
+	_ = conn.Close()
+	_ = clusterConn.Close()
+	<-doneChan
+	return
+}
+
+// clusterRewriters answer CLUSTER SLOTS and CLUSTER NODES from the proxy's view of the cluster and translate MOVED errors
+// for clients connected through the horizon at horizonIndex
+func (r *Redis) clusterRewriters(horizonIndex int) (intercept, reWrite RewriteFunc) {
+	intercept = func(componenterIn redisPkg.Componenter) (componenterOut redisPkg.Componenter) {
+		publicHostname := r.publicHostFor(horizonIndex)
+		clusterSlotsResp, clusterNodesResp := r.topology()
+		if componenterOut = mutateClusterSlotsCommand(componenterIn, clusterSlotsResp, publicHostname, r.ipMap); nil != componenterOut {
 			return
 		}
-		if componenterOut = mutateClusterNodesCommand(componenterIn, r.facadeClusterNodesResp, r.publicHostname, r.ipMap); nil != componenterOut {
+		if componenterOut = mutateClusterNodesCommand(componenterIn, clusterNodesResp, publicHostname, r.ipMap); nil != componenterOut {
 			return
 		}
 		// nil means no interception, pass the query through
 		return nil
-	}, func(componenterIn redisPkg.Componenter) (componenterOut redisPkg.Componenter) {
-		if componenterOut = mutateMovedCommand(r, componenterIn); nil != componenterOut {
+	}
+	reWrite = func(componenterIn redisPkg.Componenter) (componenterOut redisPkg.Componenter) {
+		if componenterOut = mutateMovedCommand(r, componenterIn, r.publicHostFor(horizonIndex)); nil != componenterOut {
 			return
 		}
 		// no changes
 		return componenterIn
-	}, buffer1, buffer2, doneChan, r.debugOutputEnabled)
-
-	return <-doneChan
+	}
+	return
 }
 
 func allocateBufferPair(bufferPool *bufferPool) (buffer1, buffer2 []byte, err error) {
@@ -297,19 +475,21 @@ func allocateBufferPair(bufferPool *bufferPool) (buffer1, buffer2 []byte, err er
 	return
 }
 
-func mutateMovedCommand(r *Redis, componenterIn redisPkg.Componenter) (componenterOut redisPkg.Componenter) {
+func mutateMovedCommand(r *Redis, componenterIn redisPkg.Componenter, publicHostname string) (componenterOut redisPkg.Componenter) {
 	if parts, moved := isMoved(componenterIn); moved {
-		remoteFromCluster, _ := ip_map.NewHostWithPortFromString(parts[2])
+		remoteFromCluster, _ := ip_map.NewHostWithPortFromClusterString(parts[2])
 		newLocal, ok := r.ipMap.RemoteToLocal(remoteFromCluster)
 		if !ok {
 			log.Println("no mapping from cluster address: " + remoteFromCluster.String())
+			// the cluster knows about a node we do not, go find it for the next client
+			r.RequestTopologyRefresh()
 			return nil
 		}
 		translatedAddr := ip_map.HostWithPort{
-			Host: r.publicHostname,
+			Host: publicHostname,
 			Port: newLocal,
 		}
-		re := redisPkg.ErrorComp(fmt.Sprintf("MOVED %s %s", parts[1], translatedAddr.String()))
+		re := redisPkg.ErrorComp(fmt.Sprintf("MOVED %s %s", parts[1], translatedAddr.ClusterString()))
 		return &re
 	}
 	return nil
@@ -326,8 +506,16 @@ func mutateClusterSlotsCommand(componenterIn redisPkg.Componenter, clusterSlotRe
 					log.Println("no mapping from cluster address: " + clusterAddr.String())
 				}
 				// Lie to the client
-				slotResponse[slotIndex].Servers()[serverIndex].SetIp(publicHostname)
-				slotResponse[slotIndex].Servers()[serverIndex].SetPort(localAddr)
+				server := &slotResponse[slotIndex].Servers()[serverIndex]
+				server.SetIp(publicHostname)
+				server.SetPort(localAddr)
+				if server.HasMetadata() {
+					// Redis 7 clients that route by hostname use this instead of the ip
+					server.SetMetadata(redisPkg.MetadataHostname, publicHostname)
+					if _, hasIp := server.Metadata("ip"); hasIp {
+						server.SetMetadata("ip", publicHostname)
+					}
+				}
 			}
 		}
 		return redisPkg.ClusterSlotArrayRespToComponent(slotResponse)
@@ -336,25 +524,8 @@ func mutateClusterSlotsCommand(componenterIn redisPkg.Componenter, clusterSlotRe
 }
 
 func isClusterSlotsQuery(statements redisPkg.Componenter) bool {
-	if array, ok := statements.(*redisPkg.Array); !ok {
-		return false
-	} else {
-		if command, ok := (*array)[0].(*redisPkg.BulkString); !ok {
-			return false
-		} else {
-			if !strings.EqualFold(command.String(), "CLUSTER") {
-				return false
-			}
-		}
-		if command, ok := (*array)[1].(*redisPkg.BulkString); !ok {
-			return false
-		} else {
-			if !strings.EqualFold(command.String(), "SLOTS") {
-				return false
-			}
-		}
-		return true
-	}
+	name, subcommand := commandName(statements)
+	return name == "cluster" && subcommand == "slots"
 }
 
 func mutateClusterNodesCommand(componenterIn redisPkg.Componenter, clusterNodeResp []redisPkg.ClusterNodeResp, publicHostname string, lookup *ip_map.Concurrent) (componenterOut redisPkg.Componenter) {
@@ -369,6 +540,7 @@ func mutateClusterNodesCommand(componenterIn redisPkg.Componenter, clusterNodeRe
 			// Lie to the client
 			nodes[nodeIndex].SetIp(publicHostname)
 			nodes[nodeIndex].SetPort(localAddr)
+			nodes[nodeIndex].SetHostname(publicHostname)
 		}
 		return redisPkg.ClusterNodeArrayToComponent(nodes)
 	}
@@ -376,25 +548,8 @@ func mutateClusterNodesCommand(componenterIn redisPkg.Componenter, clusterNodeRe
 }
 
 func isClusterNodesQuery(statements redisPkg.Componenter) bool {
-	if array, ok := statements.(*redisPkg.Array); !ok {
-		return false
-	} else {
-		if command, ok := (*array)[0].(*redisPkg.BulkString); !ok {
-			return false
-		} else {
-			if !strings.EqualFold(command.String(), "CLUSTER") {
-				return false
-			}
-		}
-		if command, ok := (*array)[1].(*redisPkg.BulkString); !ok {
-			return false
-		} else {
-			if !strings.EqualFold(command.String(), "NODES") {
-				return false
-			}
-		}
-		return true
-	}
+	name, subcommand := commandName(statements)
+	return name == "cluster" && subcommand == "nodes"
 }
 
 func localToRemoteHostAndPort(concurrent *ip_map.Concurrent, localPort uint16) (remoteAddr ip_map.HostWithPort, err error) {
@@ -417,8 +572,8 @@ func remoteToLocalHostAndPort(concurrent *ip_map.Concurrent, remoteAddr ip_map.H
 	return
 }
 
-func debugClientIn(label string, debugOutputEnabled bool, componenter redisPkg.Componenter) {
-	if debugOutputEnabled {
+func debugClientIn(label string, debugOutputEnabled func() bool, componenter redisPkg.Componenter) {
+	if debugOutputEnabled() {
 		buffer := &bytes.Buffer{}
 		_, _ = redisPkg.ComponentToStream(buffer, componenter)
 		log.Println(label + ": \"" + strings.ReplaceAll(buffer.String(), "\r\n", "\\r\\n") + "\"")
@@ -2,15 +2,28 @@ package proxy
 
 import (
 	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
 	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/port_pool"
 	"redis_cluster_proxy/pkg/redis"
 	"testing"
+	"time"
 )
 
 const BufferSizeBytes = 512
 
 const queryCommandSlots = "*2\r\n$7\r\nCLUSTER\r\n$5\r\nslots\r\n"
 
+func commandFromWords(words ...string) redis.Componenter {
+	command := redis.Array{}
+	for _, word := range words {
+		command = append(command, redis.NewBulkStringFromString(word))
+	}
+	return &command
+}
+
 var clusterRespInput = []redis.ClusterSlotResp{
 	redis.NewClusterSlotResp(0, 5460, []redis.ClusterServerResp{
 		redis.NewClusterServerResp("172.22.0.2", 7000, "901e06d850fe7a21253fbb200b5bdd55d3286848"),
@@ -66,3 +79,101 @@ func stringToComponents(command string) (component redis.Componenter, err error)
 	component, _, err = redis.ComponentFromReader(buffer, make([]byte, BufferSizeBytes))
 	return
 }
+
+func TestMutateMovedIPv6(t *testing.T) {
+	r := NewRedis(ip_map.HostWithPort{Host: "::"}, ip_map.HostWithPort{}, "2001:db8::10", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	r.ipMap.Create(ip_map.HostWithPort{Host: "fd00::2", Port: 7001}, 8001)
+
+	componentOut := mutateMovedCommand(r, redis.NewErrorFromString("MOVED 3999 fd00::2:7001"), r.publicHostFor(0))
+	assert.Equal(t, redis.NewErrorFromString("MOVED 3999 2001:db8::10:8001"), componentOut)
+}
+
+func TestHorizonPublicHostBrackets(t *testing.T) {
+	r := NewRedis(ip_map.HostWithPort{Host: "::"}, ip_map.HostWithPort{}, "[2001:db8::10]", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	r.AddHorizon(Horizon{BindHost: "::1", PublicHost: "[2001:db8::11]"})
+	assert.Equal(t, "2001:db8::10", r.publicHostFor(0))
+	assert.Equal(t, "2001:db8::11", r.publicHostFor(1))
+
+	r.SetHorizonPublicHost("::1", "[2001:db8::12]")
+	assert.Equal(t, "2001:db8::12", r.publicHostFor(1))
+}
+
+func TestRedisCloseTwice(t *testing.T) {
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	assert.NoError(t, r.Close())
+	assert.NotPanics(t, func() { _ = r.Close() })
+}
+
+func TestDialClusterAuthDeadline(t *testing.T) {
+	// the node accepts the connection but never answers AUTH
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+	go func() {
+		conn, acceptErr := listener.Accept()
+		if acceptErr == nil {
+			defer func() { _ = conn.Close() }()
+			_, _ = ioutil.ReadAll(conn)
+		}
+	}()
+	defer func(previous time.Duration) { clusterDialTimeout = previous }(clusterDialTimeout)
+	clusterDialTimeout = 50 * time.Millisecond
+
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	r.SetCredentials(Credentials{Password: "secret"})
+	addr, err := ip_map.NewHostWithPortFromString(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = r.dialCluster(addr)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no reply within")
+	}
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestMutateClusterSlotRespHostname(t *testing.T) {
+	lookup := ip_map.NewConcurrent()
+	lookup.Create(ip_map.HostWithPort{Host: "172.22.0.2", Port: 7000}, 8000)
+	server := redis.NewClusterServerResp("172.22.0.2", 7000, "901e06d850fe7a21253fbb200b5bdd55d3286848")
+	server.SetMetadata(redis.MetadataHostname, "redis-0.internal")
+	input := []redis.ClusterSlotResp{redis.NewClusterSlotResp(0, 16383, []redis.ClusterServerResp{server})}
+	command, err := stringToComponents(queryCommandSlots)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	componentOut := mutateClusterSlotsCommand(command, input, "proxy.example.com", lookup)
+	slots, err := redis.NewSlotArrayFromComponent(componentOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, ok := slots[0].Servers()[0].Metadata(redis.MetadataHostname)
+	assert.True(t, ok)
+	assert.Equal(t, "proxy.example.com", hostname)
+	original, _ := input[0].Servers()[0].Metadata(redis.MetadataHostname)
+	assert.Equal(t, "redis-0.internal", original, "the cached topology is not modified")
+}
+
+func TestIsClusterQuery(t *testing.T) {
+	cases := map[string]struct {
+		words         []string
+		expectedSlots bool
+		expectedNodes bool
+	}{
+		"slots":         {words: []string{"cluster", "SLOTS"}, expectedSlots: true},
+		"nodes":         {words: []string{"CLUSTER", "nodes"}, expectedNodes: true},
+		"no subcommand": {words: []string{"CLUSTER"}},
+		"other command": {words: []string{"GET", "slots"}},
+		"other subcmd":  {words: []string{"CLUSTER", "INFO"}},
+	}
+
+	for caseName, c := range cases {
+		command := commandFromWords(c.words...)
+		assert.Equal(t, c.expectedSlots, isClusterSlotsQuery(command), caseName)
+		assert.Equal(t, c.expectedNodes, isClusterNodesQuery(command), caseName)
+	}
+}
@@ -0,0 +1,565 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"redis_cluster_proxy/pkg/ip_map"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric names for the routing endpoint
+const (
+	// metricRoutingRedirected counts MOVED and ASK replies the proxy followed instead of passing them on
+	metricRoutingRedirected = "routing.redirected"
+	metricRoutingCrossSlot  = "routing.crossslot"
+	// metricRoutingSplit counts multi-key commands split into one command per slot
+	metricRoutingSplit = "routing.split"
+	// metricRoutingFanOut counts commands sent to every master
+	metricRoutingFanOut = "routing.fan_out"
+)
+
+// maxRedirects bounds how many MOVED and ASK redirections one command follows. The last redirection is sent to the client
+const maxRedirects = 5
+
+// Errors sent to clients of the routing endpoint
+const (
+	crossSlotMessage   = "CROSSSLOT Keys in request don't hash to the same slot"
+	slotNotServed      = "CLUSTERDOWN Hash slot not served"
+	noProtoMessage     = "NOPROTO sorry, this protocol version is not supported."
+	noPasswordMessage  = "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"
+	unreachableMessage = "ERR the proxy was unable to reach the cluster node for this command"
+	poolFullMessage    = "ERR the proxy has no free connections to the cluster node for this command, try again"
+)
+
+// connectionCommands change or depend on the state of the connection they are sent on. Clients of the routing endpoint
+// share backend connections, so these are rejected
+var connectionCommands = map[string]bool{
+	"asking":    true,
+	"client":    true,
+	"monitor":   true,
+	"psync":     true,
+	"readonly":  true,
+	"readwrite": true,
+	"replconf":  true,
+	"reset":     true,
+	"sync":      true,
+	"wait":      true,
+	"waitaof":   true,
+}
+
+var askingCommand = &redisPkg.Array{redisPkg.NewBulkStringFromString("ASKING")}
+
+// SetRouteAddr makes DiscoverAndListen also accept clients on routeAddr, a single endpoint where the proxy sends every
+// command to the master that owns its keys, so clients do not need to be cluster aware. Not available with Sentinel
+func (r *Redis) SetRouteAddr(routeAddr ip_map.HostWithPort) {
+	r.routeAddr = routeAddr
+}
+
+// listenForRouting binds the routing endpoint, if one was set, and starts accepting clients
+func (r *Redis) listenForRouting() (err error) {
+	if r.routeAddr.Port == 0 {
+		return nil
+	}
+	listener, err := net.Listen("tcp", r.routeAddr.String())
+	if err != nil {
+		return
+	}
+	r.topologyMu.Lock()
+	r.routeListener = listener
+	r.topologyMu.Unlock()
+	go func() {
+		_ = routeLoop(listener, r)
+	}()
+	return nil
+}
+
+func routeLoop(listener net.Listener, r *Redis) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		settings := r.liveSettings()
+		if !r.admitConnection(conn, settings.accessList) {
+			_ = conn.Close()
+			continue
+		}
+		setKeepAlive(conn, settings.timeouts.KeepAlive)
+		if settings.listenerTLS != nil {
+			conn = tls.Server(conn, settings.listenerTLS)
+		}
+		go func(conn net.Conn) {
+			err := r.routeConnection(conn)
+			if err != nil {
+				if metricName := timeoutMetricName(err); metricName != "" {
+					r.metrics.Incr(metricName)
+				}
+				log.Println(err)
+			}
+		}(conn)
+	}
+}
+
+// dialBackend opens a connection for the backend pool. destinationAddr is a cluster node's address, as HOST:PORT
+func (r *Redis) dialBackend(destinationAddr string) (net.Conn, error) {
+	clusterAddr, err := ip_map.NewHostWithPortFromString(destinationAddr)
+	if err != nil {
+		return nil, err
+	}
+	return r.dialCluster(clusterAddr)
+}
+
+// routeConnection serves one client of the routing endpoint. Commands are handled one at a time, in the order they were
+// sent, so that pipelined replies come back in order
+func (r *Redis) routeConnection(conn net.Conn) (err error) {
+	defer func() { _ = conn.Close() }()
+	buffer := r.buffers.Get()
+	if buffer == nil {
+		return fmt.Errorf("ran out of buffers")
+	}
+	defer r.buffers.Put(buffer)
+
+	timeouts := r.liveSettings().timeouts
+	session := r.newRoutedSession(conn.RemoteAddr())
+	session.client = conn
+	defer session.close()
+	reader := &clientReader{conn: conn}
+	session.watchClient = reader.watch
+	label := "cli[" + conn.RemoteAddr().String() + "] -> routing[" + conn.LocalAddr().String() + "]"
+	for {
+		if timeouts.ClientIdle > 0 && !session.ps.isActive() {
+			// subscribed clients wait for messages, so they are never idle
+			_ = conn.SetReadDeadline(time.Now().Add(timeouts.ClientIdle))
+		} else {
+			_ = conn.SetReadDeadline(time.Time{})
+		}
+		command, byteCount, readErr := redisPkg.ComponentFromReader(reader, buffer)
+		if readErr != nil {
+			if isTimeout(readErr) {
+				return fmt.Errorf("%s: %w", label, ErrClientIdleTimeout)
+			}
+			return hideErrors(readErr)
+		}
+		debugClientIn(label, r.isDebugEnabled, command)
+		if reply := session.handle(command, byteCount); reply != nil && !reader.left {
+			if err = session.write(reply); err != nil {
+				return writeError(label, err)
+			}
+		}
+		if session.quit {
+			return nil
+		}
+	}
+}
+
+// routedSession follows one client of the routing endpoint
+type routedSession struct {
+	r *Redis
+	// client is written to under writeMu, as Pub/Sub messages arrive while commands are handled
+	client    net.Conn
+	writeMu   sync.Mutex
+	namespace *keyNamespace
+	acl       *aclSession
+	rateLimit *clientRateLimit
+	admit     AdmitFunc
+	intercept RewriteFunc
+	// authenticated is set once the client sent AUTH with the proxy's credentials. Only used when there are no proxy users
+	authenticated bool
+	quit          bool
+	tx            routedTransaction
+	ps            *routedPubSub
+	// watchClient is nil when the client connection cannot be watched, as in tests
+	watchClient clientWatch
+}
+
+func (r *Redis) newRoutedSession(clientAddr net.Addr) *routedSession {
+	acl := r.newACLSession()
+	acl.pooled = true
+	s := &routedSession{r: r, namespace: r.newKeyNamespace(acl), acl: acl, rateLimit: r.newClientRateLimit(clientAddr, acl), tx: newRoutedTransaction(), ps: newRoutedPubSub()}
+	s.admit = chainAdmit(s.rateLimit.admit, acl.admit, r.commandRulesAdmitter(), r.readOnlyAdmitter(), s.admitConnectionCommands, s.namespace.admit)
+	// cluster aware clients that ask for the slots are sent the node ports
+	s.intercept, _ = r.clusterRewriters(0)
+	return s
+}
+
+// handle runs one command from the client and returns the reply, or nil when the replies come as Pub/Sub messages
+func (s *routedSession) handle(command redisPkg.Componenter, byteCount int) (reply redisPkg.Componenter) {
+	// commands are answered one at a time, so a login is settled with the reply to its command, whoever made it
+	defer func() {
+		s.acl.forwarded(command)
+		_ = s.acl.replied(command, reply)
+		s.rateLimit.replied(command, reply)
+	}()
+	forward, reply := s.admit(command, byteCount)
+	if reply != nil {
+		if s.tx.inMulti && isError(reply) {
+			s.tx.aborted = true
+		}
+		return reply
+	}
+	if reply = s.intercept(forward); reply != nil {
+		return reply
+	}
+	s.namespace.forwarded(forward)
+	reply, handled := s.pubSub(forward)
+	if !handled {
+		reply, handled = s.transact(forward)
+	}
+	if !handled {
+		reply = s.r.route(forward, s.watchClient)
+	}
+	if handled && reply == nil {
+		return nil
+	}
+	if reply = s.namespace.reply(reply); reply == nil {
+		return redisPkg.NewNullString()
+	}
+	return reply
+}
+
+// close gives back the connection pinned by WATCH, if the client leaves in the middle of a transaction, and closes the
+// subscribed connections
+func (s *routedSession) close() {
+	s.endTransaction()
+	s.ps.close()
+}
+
+// write sends a reply or a Pub/Sub message to the client
+func (s *routedSession) write(reply redisPkg.Componenter) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	setWriteDeadline(s.client, s.r.liveSettings().timeouts.Write)
+	return writeComponent(s.client, reply)
+}
+
+// admitConnectionCommands answers the commands that act on the connection itself, as the backend connections are shared
+func (s *routedSession) admitConnectionCommands(command redisPkg.Componenter, _ int) (forward, reply redisPkg.Componenter) {
+	args, ok := commandArgs(command)
+	if !ok || len(args) == 0 {
+		return nil, redisPkg.NewErrorFromString("ERR Protocol error: expected a command as an array of bulk strings")
+	}
+	settings := s.r.liveSettings()
+	// without proxy users, clients log in with the proxy's own credentials, which the backend connections already use
+	proxyAuth := settings.users.IsEmpty() && settings.credentials.IsSet()
+	name := strings.ToLower(args[0])
+	switch name {
+	case "auth":
+		if settings.users.IsEmpty() {
+			return nil, s.auth(settings.credentials, args)
+		}
+	case "hello":
+		return s.hello(settings.credentials, proxyAuth, args)
+	case "quit":
+		s.quit = true
+		return nil, redisPkg.NewSimpleStringFromString("OK")
+	}
+	if proxyAuth && !s.authenticated {
+		s.r.metrics.Incr(metricACLNoAuth)
+		return nil, redisPkg.NewErrorFromString(noAuthMessage)
+	}
+	if connectionCommands[name] {
+		return nil, redisPkg.NewErrorFromString(fmt.Sprintf("ERR '%s' is not supported on the routing endpoint, connect to the node ports instead", name))
+	}
+	return command, nil
+}
+
+// auth checks AUTH [username] password against the proxy's credentials
+func (s *routedSession) auth(credentials Credentials, args []string) redisPkg.Componenter {
+	var name, password string
+	switch len(args) {
+	case 2:
+		name, password = defaultUser, args[1]
+	case 3:
+		name, password = args[1], args[2]
+	default:
+		return redisPkg.NewErrorFromString("ERR wrong number of arguments for 'auth' command")
+	}
+	if !credentials.IsSet() {
+		return redisPkg.NewErrorFromString(noPasswordMessage)
+	}
+	username := credentials.Username
+	if len(username) == 0 {
+		username = defaultUser
+	}
+	nameMatches := subtle.ConstantTimeCompare([]byte(name), []byte(username))
+	passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password))
+	if nameMatches&passwordMatches != 1 {
+		s.r.metrics.Incr(metricACLAuthFailed)
+		return redisPkg.NewErrorFromString(wrongPassMessage)
+	}
+	s.authenticated = true
+	return redisPkg.NewSimpleStringFromString("OK")
+}
+
+// hello handles HELLO [protover [AUTH username password] [SETNAME clientname]]. Only RESP2 is spoken, as the replies are
+// read and merged by the proxy. AUTH is checked by the proxy, and SETNAME is dropped as it would name a shared connection
+func (s *routedSession) hello(credentials Credentials, proxyAuth bool, args []string) (forward, reply redisPkg.Componenter) {
+	if len(args) > 1 && args[1] != "2" {
+		return nil, redisPkg.NewErrorFromString(noProtoMessage)
+	}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				return nil, redisPkg.NewErrorFromString("ERR Syntax error in HELLO option 'auth'")
+			}
+			// proxy users have already taken AUTH out, so this is a login with the proxy's credentials
+			if authReply := s.auth(credentials, args[i:i+3]); isError(authReply) {
+				return nil, authReply
+			}
+			i += 2
+		case "SETNAME":
+			i++
+		}
+	}
+	if proxyAuth && !s.authenticated {
+		s.r.metrics.Incr(metricACLNoAuth)
+		return nil, redisPkg.NewErrorFromString(noAuthHelloMessage)
+	}
+	hello := redisPkg.Array{redisPkg.NewBulkStringFromString(args[0])}
+	if len(args) > 1 {
+		hello = append(hello, redisPkg.NewBulkStringFromString(args[1]))
+	}
+	return &hello, nil
+}
+
+// route sends a command to the master that owns the slot of its keys, and returns the reply. Commands without keys go to
+// any master. Keys in more than one slot are only allowed for the commands that can be split by slot. watch, if not nil,
+// tells blocking commands when the client leaves
+func (r *Redis) route(command redisPkg.Componenter, watch clientWatch) redisPkg.Componenter {
+	args, _ := commandArgs(command)
+	spec, known := r.commandTable().Lookup(args)
+	if !known {
+		return r.sendToSlot(-1, command)
+	}
+	r.rememberScript(args)
+	slots := keySlots(spec.Keys(args))
+	if timeout, blocks := spec.BlockTimeout(args); blocks && len(slots) <= 1 {
+		slot := -1
+		if len(slots) == 1 {
+			slot = slots[0]
+		}
+		return r.sendBlocking(slot, command, timeout, watch)
+	}
+	switch len(slots) {
+	case 0:
+		if strings.EqualFold(args[0], "scan") {
+			return r.scanCluster(args)
+		}
+		if scriptCommands[strings.ToLower(args[0])] {
+			return r.sendKeylessScript(command)
+		}
+		if merge, ok := fanOutFor(args); ok {
+			r.metrics.Incr(metricRoutingFanOut)
+			return r.sendToMasters(merge, command)
+		}
+		return r.sendToSlot(-1, command)
+	case 1:
+		return r.sendToSlot(slots[0], command)
+	}
+	if split, ok := splitCommands[strings.ToLower(args[0])]; ok {
+		r.metrics.Incr(metricRoutingSplit)
+		return r.sendSplit(split, args)
+	}
+	r.metrics.Incr(metricRoutingCrossSlot)
+	return redisPkg.NewErrorFromString(crossSlotMessage)
+}
+
+// keySlots returns the distinct slots of keys, in the order they are first seen
+func keySlots(keys []string) (slots []int) {
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		slot := redisPkg.KeySlot(key)
+		if !seen[slot] {
+			seen[slot] = true
+			slots = append(slots, slot)
+		}
+	}
+	return
+}
+
+// slotOwner returns the address of the master of slot, from the latest CLUSTER SLOTS. A negative slot returns the master
+// of the first slot range, for commands without keys
+func (r *Redis) slotOwner(slot int) (addr ip_map.HostWithPort, ok bool) {
+	slotRanges, _ := r.topology()
+	for _, slotRange := range slotRanges {
+		servers := slotRange.Servers()
+		if len(servers) == 0 {
+			continue
+		}
+		if slot < 0 || (slotRange.RangeStart() <= slot && slot <= slotRange.RangeEnd()) {
+			return ip_map.HostWithPort{Host: servers[0].Ip(), Port: servers[0].Port()}, true
+		}
+	}
+	return
+}
+
+// sendToSlot sends command to the master of slot. Failures are returned as error replies
+func (r *Redis) sendToSlot(slot int, command redisPkg.Componenter) redisPkg.Componenter {
+	addr, ok := r.slotOwner(slot)
+	if !ok {
+		return redisPkg.NewErrorFromString(slotNotServed)
+	}
+	return r.sendTo(addr, command, nil)
+}
+
+// sendTo sends command to the node at addr, following MOVED and ASK redirections. Failures are returned as error replies.
+// blocking is nil for commands that reply at once
+func (r *Redis) sendTo(addr ip_map.HostWithPort, command redisPkg.Componenter, blocking *blockingCall) redisPkg.Componenter {
+	buffer := r.buffers.Get()
+	if buffer == nil {
+		return redisPkg.NewErrorFromString("ERR the proxy ran out of buffers")
+	}
+	defer r.buffers.Put(buffer)
+
+	var before []redisPkg.Componenter
+	reloaded := false
+	for redirects := 0; ; redirects++ {
+		reply, err := r.roundTrip(addr, command, before, buffer, blocking)
+		if err != nil {
+			return r.backendError(err)
+		}
+		if load, ok := r.scriptLoadFor(command, reply); ok && !reloaded {
+			// the node lost the script, or never had it, such as a master that just took over the slot
+			r.metrics.Incr(metricRoutingScriptReloaded)
+			reloaded = true
+			before = append([]redisPkg.Componenter{load}, before...)
+			continue
+		}
+		target, ask, redirected := redirection(reply)
+		if !redirected || redirects == maxRedirects {
+			return reply
+		}
+		r.metrics.Incr(metricRoutingRedirected)
+		if !ask {
+			// the slot moved for good, the next commands should not need redirecting
+			r.RequestTopologyRefresh()
+		}
+		if len(target.Host) == 0 {
+			// Redis 7 leaves the host out when it is the one the command was sent to
+			target.Host = addr.Host
+		}
+		addr, before = target, nil
+		if ask {
+			before = []redisPkg.Componenter{askingCommand}
+		}
+	}
+}
+
+// roundTrip sends command to the node at addr over a pooled connection and reads the reply. before are sent first on the
+// same connection, such as ASKING after an ASK redirection, and their replies dropped. Blocking commands use connections
+// of their own pool
+func (r *Redis) roundTrip(addr ip_map.HostWithPort, command redisPkg.Componenter, before []redisPkg.Componenter, buffer []byte, blocking *blockingCall) (reply redisPkg.Componenter, err error) {
+	pool := r.backends
+	if blocking != nil {
+		pool = r.blockingBackends
+	}
+	conn, err := pool.Dial(addr.String())
+	if err != nil {
+		return
+	}
+	pooled := conn.(*pooledConnection)
+	commands := append(append(make([]redisPkg.Componenter, 0, len(before)+1), before...), command)
+	replies, err := r.exchange(pooled, commands, buffer, blocking)
+	if err != nil {
+		return
+	}
+	_ = pooled.Close()
+	return replies[len(replies)-1], nil
+}
+
+// exchange writes commands to conn at once and reads one reply for each. Connections that fail, or time out, are closed
+// rather than returned to the pool. The backend read timeout of a blocking command counts from the end of its own timeout
+func (r *Redis) exchange(conn *pooledConnection, commands []redisPkg.Componenter, buffer []byte, blocking *blockingCall) (replies []redisPkg.Componenter, err error) {
+	timeouts := r.liveSettings().timeouts
+	label := "routing -> cluster[" + conn.destinationAddr + "]"
+
+	request := &bytes.Buffer{}
+	for _, command := range commands {
+		if _, err = redisPkg.ComponentToStream(request, command); err != nil {
+			_ = conn.Close()
+			return
+		}
+	}
+	_ = conn.SetDeadline(time.Time{})
+	setWriteDeadline(conn, timeouts.Write)
+	_, err = conn.Write(request.Bytes())
+	if err != nil {
+		_ = conn.Discard()
+		return nil, writeError(label, err)
+	}
+	switch {
+	case timeouts.BackendRead == 0:
+	case blocking == nil:
+		_ = conn.SetReadDeadline(time.Now().Add(timeouts.BackendRead))
+	case blocking.timeout > 0:
+		_ = conn.SetReadDeadline(time.Now().Add(blocking.timeout + timeouts.BackendRead))
+	}
+	if blocking != nil && blocking.gone != nil {
+		read := make(chan struct{})
+		defer close(read)
+		go func() {
+			select {
+			case <-blocking.gone:
+				// closing the connection below unblocks the command on the node, so nothing is popped for nobody
+				_ = conn.SetReadDeadline(time.Now())
+			case <-read:
+			}
+		}()
+	}
+	replies = make([]redisPkg.Componenter, len(commands))
+	for i := range replies {
+		if replies[i], _, err = redisPkg.ComponentFromReader(conn, buffer); err != nil {
+			_ = conn.Discard()
+			if blocking.left() {
+				return nil, errClientLeft
+			}
+			if isTimeout(err) {
+				err = fmt.Errorf("%s: %w", label, ErrBackendReadTimeout)
+			}
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+// backendError logs why a command could not be sent to the cluster and builds the error reply for the client, which
+// leaves out the node's private address
+func (r *Redis) backendError(err error) redisPkg.Componenter {
+	if metricName := timeoutMetricName(err); metricName != "" {
+		r.metrics.Incr(metricName)
+	}
+	if errors.Is(err, ErrPoolDepleted) {
+		return redisPkg.NewErrorFromString(poolFullMessage)
+	}
+	if errors.Is(err, errClientLeft) {
+		return redisPkg.NewErrorFromString(unreachableMessage)
+	}
+	log.Println(err)
+	return redisPkg.NewErrorFromString(unreachableMessage)
+}
+
+// redirection reads a MOVED or ASK error. The address is the node's private address, as the cluster sent it
+func redirection(reply redisPkg.Componenter) (addr ip_map.HostWithPort, ask, ok bool) {
+	errorReply, isError := reply.(*redisPkg.ErrorComp)
+	if !isError {
+		return
+	}
+	parts := strings.Split(errorReply.String(), " ")
+	if len(parts) != 3 || (parts[0] != "MOVED" && parts[0] != "ASK") {
+		return
+	}
+	addr, err := ip_map.NewHostWithPortFromClusterString(parts[2])
+	if err != nil {
+		return
+	}
+	return addr, parts[0] == "ASK", true
+}
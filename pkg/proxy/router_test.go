@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/port_pool"
+	"redis_cluster_proxy/pkg/redis"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCluster answers the commands the routing endpoint sends to each node address with the node's handler, which
+// returns the reply as it is sent on the wire
+type fakeCluster struct {
+	handlers map[string]func(args []string) string
+	mu       *sync.Mutex
+	// received lists the commands each node was sent, words joined by spaces
+	received map[string][]string
+	// dialed counts the connections opened to each node
+	dialed map[string]int
+}
+
+func newFakeCluster(handlers map[string]func(args []string) string) *fakeCluster {
+	return &fakeCluster{handlers: handlers, mu: &sync.Mutex{}, received: make(map[string][]string), dialed: make(map[string]int)}
+}
+
+func (f *fakeCluster) dial(destinationAddr string) (net.Conn, error) {
+	handler, ok := f.handlers[destinationAddr]
+	if !ok {
+		return nil, fmt.Errorf("no node at %s", destinationAddr)
+	}
+	f.mu.Lock()
+	f.dialed[destinationAddr]++
+	f.mu.Unlock()
+	// a socket rather than net.Pipe, which would hold ASKING's reply back until the command after it was read
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = listener.Close() }()
+	proxySide, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	nodeSide, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer func() { _ = nodeSide.Close() }()
+		buffer := make([]byte, BufferSizeBytes)
+		for {
+			command, _, err := redis.ComponentFromReader(nodeSide, buffer)
+			if err != nil {
+				return
+			}
+			args, _ := commandArgs(command)
+			f.mu.Lock()
+			f.received[destinationAddr] = append(f.received[destinationAddr], strings.Join(args, " "))
+			f.mu.Unlock()
+			reply := "+OK\r\n"
+			if !strings.EqualFold(args[0], "ASKING") {
+				reply = handler(args)
+			}
+			if _, err = nodeSide.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+	return proxySide, nil
+}
+
+func (f *fakeCluster) commands(destinationAddr string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.received[destinationAddr]
+}
+
+// newRoutingRedis splits the slots between 10.0.0.1:7000, which owns "bar" and "baz", and 10.0.0.2:7000, which owns "foo"
+func newRoutingRedis(cluster *fakeCluster) *Redis {
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 16, 4, BufferSizeBytes)
+	r.facadeClusterSlotsResp = []redis.ClusterSlotResp{
+		redis.NewClusterSlotResp(0, 8191, []redis.ClusterServerResp{redis.NewClusterServerResp("10.0.0.1", 7000, "a")}),
+		redis.NewClusterSlotResp(8192, 16383, []redis.ClusterServerResp{redis.NewClusterServerResp("10.0.0.2", 7000, "b")}),
+	}
+	r.backends = newConnPool(4, cluster.dial)
+	r.blockingBackends = newConnPool(4, cluster.dial)
+	return r
+}
+
+func TestRoute(t *testing.T) {
+	// each node answers for the keys it was sent: MGET with "v-" and the key, DEL with the number of keys
+	node := func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "MGET":
+			reply := fmt.Sprintf("*%d\r\n", len(args)-1)
+			for _, key := range args[1:] {
+				reply += fmt.Sprintf("$%d\r\nv-%s\r\n", len(key)+2, key)
+			}
+			return reply
+		case "DEL":
+			return fmt.Sprintf(":%d\r\n", len(args)-1)
+		case "GET":
+			return "$1\r\n1\r\n"
+		}
+		return "+OK\r\n"
+	}
+	// the key "moved" has moved from the first node to the second
+	movedFrom := func(args []string) string {
+		if len(args) > 1 && args[1] == "moved" {
+			return "-MOVED 12182 10.0.0.2:7000\r\n"
+		}
+		return node(args)
+	}
+	cluster := newFakeCluster(map[string]func(args []string) string{"10.0.0.1:7000": movedFrom, "10.0.0.2:7000": node})
+	r := newRoutingRedis(cluster)
+
+	cases := map[string]struct {
+		command       []string
+		expectedReply string
+		expectedNode1 []string
+		expectedNode2 []string
+	}{
+		"single slot": {
+			command:       []string{"GET", "bar"},
+			expectedReply: "$1\r\n1\r\n",
+			expectedNode1: []string{"GET bar"},
+		},
+		"mget in key order": {
+			command:       []string{"MGET", "foo", "bar", "baz"},
+			expectedReply: "*3\r\n$5\r\nv-foo\r\n$5\r\nv-bar\r\n$5\r\nv-baz\r\n",
+			expectedNode1: []string{"MGET bar", "MGET baz"},
+			expectedNode2: []string{"MGET foo"},
+		},
+		"del sums": {
+			command:       []string{"DEL", "foo", "bar", "{foo}x"},
+			expectedReply: ":3\r\n",
+			expectedNode1: []string{"DEL bar"},
+			expectedNode2: []string{"DEL foo {foo}x"},
+		},
+		"mset pairs": {
+			command:       []string{"MSET", "foo", "1", "bar", "2"},
+			expectedReply: "+OK\r\n",
+			expectedNode1: []string{"MSET bar 2"},
+			expectedNode2: []string{"MSET foo 1"},
+		},
+		"spublish by channel slot": {
+			command:       []string{"SPUBLISH", "foo", "hi"},
+			expectedReply: "+OK\r\n",
+			expectedNode2: []string{"SPUBLISH foo hi"},
+		},
+		"cross slot": {
+			command:       []string{"SUNION", "foo", "bar"},
+			expectedReply: "-CROSSSLOT Keys in request don't hash to the same slot\r\n",
+		},
+		"moved is followed": {
+			command:       []string{"GET", "moved"},
+			expectedReply: "$1\r\n1\r\n",
+			expectedNode1: []string{"GET moved"},
+			expectedNode2: []string{"GET moved"},
+		},
+	}
+
+	for caseName, c := range cases {
+		cluster.received = make(map[string][]string)
+		reply := r.route(commandFromWords(c.command...), nil)
+		assert.Equal(t, c.expectedReply, componentToString(t, reply), caseName)
+		assert.ElementsMatch(t, c.expectedNode1, cluster.commands("10.0.0.1:7000"), caseName)
+		assert.ElementsMatch(t, c.expectedNode2, cluster.commands("10.0.0.2:7000"), caseName)
+	}
+}
+
+func TestRouteSplitPipelinesPerNode(t *testing.T) {
+	cluster := newFakeCluster(map[string]func(args []string) string{
+		"10.0.0.1:7000": func(args []string) string { return fmt.Sprintf(":%d\r\n", len(args)-1) },
+		"10.0.0.2:7000": func(args []string) string { return fmt.Sprintf(":%d\r\n", len(args)-1) },
+	})
+	r := newRoutingRedis(cluster)
+
+	reply := r.route(commandFromWords("DEL", "bar", "baz", "foo"), nil)
+	assert.Equal(t, ":3\r\n", componentToString(t, reply))
+	assert.Equal(t, []string{"DEL bar", "DEL baz"}, cluster.commands("10.0.0.1:7000"))
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	assert.Equal(t, 1, cluster.dialed["10.0.0.1:7000"], "both slots of a node share one connection")
+}
+
+func TestRouteTruncatedReply(t *testing.T) {
+	truncated := true
+	cluster := newFakeCluster(map[string]func(args []string) string{
+		"10.0.0.1:7000": func(args []string) string {
+			if truncated {
+				truncated = false
+				// the second element never comes
+				return "*2\r\n$1\r\na\r\n"
+			}
+			return "*1\r\n$1\r\nb\r\n"
+		},
+	})
+	r := newRoutingRedis(cluster)
+	r.SetTimeouts(Timeouts{BackendRead: 50 * time.Millisecond})
+
+	reply := r.route(commandFromWords("LRANGE", "bar", "0", "-1"), nil)
+	assert.Equal(t, "-"+unreachableMessage+"\r\n", componentToString(t, reply))
+	reply = r.route(commandFromWords("LRANGE", "bar", "0", "-1"), nil)
+	assert.Equal(t, "*1\r\n$1\r\nb\r\n", componentToString(t, reply), "the connection left mid reply is not reused")
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	assert.Equal(t, 2, cluster.dialed["10.0.0.1:7000"])
+}
+
+func TestRouteAsk(t *testing.T) {
+	cluster := newFakeCluster(map[string]func(args []string) string{
+		"10.0.0.1:7000": func(args []string) string { return "-ASK 5061 10.0.0.2:7000\r\n" },
+		"10.0.0.2:7000": func(args []string) string { return "$1\r\n1\r\n" },
+	})
+	r := newRoutingRedis(cluster)
+
+	reply := r.route(commandFromWords("GET", "bar"), nil)
+	assert.Equal(t, "$1\r\n1\r\n", componentToString(t, reply))
+	assert.Equal(t, []string{"ASKING", "GET bar"}, cluster.commands("10.0.0.2:7000"), "ASKING goes first, on the same connection")
+}
+
+func TestRoutedSession(t *testing.T) {
+	cluster := newFakeCluster(map[string]func(args []string) string{
+		"10.0.0.1:7000": func(args []string) string { return "$1\r\n1\r\n" },
+		"10.0.0.2:7000": func(args []string) string { return "$1\r\n1\r\n" },
+	})
+	r := newRoutingRedis(cluster)
+	r.SetCredentials(Credentials{Password: "secret"})
+	session := r.newRoutedSession(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	send := func(words ...string) string {
+		return componentToString(t, session.handle(commandFromWords(words...), 0))
+	}
+
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", send("GET", "bar"))
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.\r\n", send("AUTH", "wrong"))
+	assert.Equal(t, "-NOPROTO sorry, this protocol version is not supported.\r\n", send("HELLO", "3", "AUTH", "default", "secret"))
+	assert.Equal(t, "+OK\r\n", send("AUTH", "default", "secret"))
+	assert.Equal(t, "$1\r\n1\r\n", send("GET", "bar"))
+	assert.Equal(t, "-ERR 'client' is not supported on the routing endpoint, connect to the node ports instead\r\n", send("CLIENT", "SETNAME", "app"))
+	assert.Empty(t, cluster.commands("10.0.0.2:7000"))
+}
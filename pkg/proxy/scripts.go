@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"redis_cluster_proxy/pkg/ip_map"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"strings"
+	"sync"
+)
+
+// metricRoutingScriptReloaded counts EVALSHA commands sent again, after SCRIPT LOAD, to a node that did not have the script
+const metricRoutingScriptReloaded = "routing.script_reloaded"
+
+// maxCachedScripts bounds the scripts the routing endpoint remembers. Past it, a script is forgotten at random
+const maxCachedScripts = 10000
+
+// noScriptKeysMessage is sent for scripts that declare no keys when no scriptNode is set
+const noScriptKeysMessage = "ERR the proxy routes scripts and functions by their keys, pass the keys the script uses in numkeys, or set scriptNode to run scripts without keys on one node"
+
+// scriptCommands are the commands that run a script or a function. They are routed by the keys they declare
+var scriptCommands = map[string]bool{
+	"eval":       true,
+	"eval_ro":    true,
+	"evalsha":    true,
+	"evalsha_ro": true,
+	"fcall":      true,
+	"fcall_ro":   true,
+}
+
+// scriptCache remembers the body of every script the clients of the routing endpoint sent, by SHA1, so that a node
+// answering NOSCRIPT, such as a master that just took over a slot, can be sent the script before EVALSHA is sent again
+type scriptCache struct {
+	mu      sync.Mutex
+	scripts map[string]string
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{scripts: make(map[string]string)}
+}
+
+func (c *scriptCache) add(body string) {
+	sum := sha1.Sum([]byte(body))
+	sha := hex.EncodeToString(sum[:])
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.scripts[sha]; ok {
+		return
+	}
+	if len(c.scripts) >= maxCachedScripts {
+		// map iteration order is random enough to pick which script to forget
+		for forgotten := range c.scripts {
+			delete(c.scripts, forgotten)
+			break
+		}
+	}
+	c.scripts[sha] = body
+}
+
+func (c *scriptCache) get(sha string) (body string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	body, ok = c.scripts[strings.ToLower(sha)]
+	return
+}
+
+func (c *scriptCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts = make(map[string]string)
+}
+
+// SetScriptNode sets the node that runs scripts and functions declaring no keys, by its address in CLUSTER SLOTS. A zero
+// Port rejects them instead. Applies to commands sent after the call
+func (r *Redis) SetScriptNode(addr ip_map.HostWithPort) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.scriptNode = addr
+}
+
+// rememberScript caches the scripts sent with EVAL and SCRIPT LOAD, and forgets them all on SCRIPT FLUSH
+func (r *Redis) rememberScript(args []string) {
+	switch name := strings.ToLower(args[0]); {
+	case (name == "eval" || name == "eval_ro") && len(args) > 1:
+		r.scripts.add(args[1])
+	case name == "script" && len(args) > 2 && strings.EqualFold(args[1], "load"):
+		r.scripts.add(args[2])
+	case name == "script" && len(args) > 1 && strings.EqualFold(args[1], "flush"):
+		r.scripts.flush()
+	}
+}
+
+// sendKeylessScript sends a script or function that declares no keys to the scriptNode, so that such scripts, which can
+// only act on what their node holds, always run in the same place
+func (r *Redis) sendKeylessScript(command redisPkg.Componenter) redisPkg.Componenter {
+	node := r.liveSettings().scriptNode
+	if node.Port == 0 {
+		return redisPkg.NewErrorFromString(noScriptKeysMessage)
+	}
+	return r.sendTo(node, command, nil)
+}
+
+// scriptLoadFor returns SCRIPT LOAD with the body of the script an EVALSHA that got NOSCRIPT runs, if the proxy knows it
+func (r *Redis) scriptLoadFor(command redisPkg.Componenter, reply redisPkg.Componenter) (load redisPkg.Componenter, ok bool) {
+	errorReply, isError := reply.(*redisPkg.ErrorComp)
+	if !isError || !strings.HasPrefix(errorReply.String(), "NOSCRIPT") {
+		return
+	}
+	args, _ := commandArgs(command)
+	if len(args) < 2 || !(strings.EqualFold(args[0], "evalsha") || strings.EqualFold(args[0], "evalsha_ro")) {
+		return
+	}
+	body, ok := r.scripts.get(args[1])
+	if !ok {
+		return
+	}
+	return &redisPkg.Array{
+		redisPkg.NewBulkStringFromString("SCRIPT"),
+		redisPkg.NewBulkStringFromString("LOAD"),
+		redisPkg.NewBulkStringFromString(body),
+	}, true
+}
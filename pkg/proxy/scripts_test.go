@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"redis_cluster_proxy/pkg/ip_map"
+	"strings"
+	"testing"
+)
+
+func TestRouteScripts(t *testing.T) {
+	script := "return 1"
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+	noScript := "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+
+	cases := map[string]struct {
+		commands      [][]string
+		scriptNode    ip_map.HostWithPort
+		expectedReply string
+		expectedNode1 []string
+		expectedNode2 []string
+	}{
+		"routed by keys": {
+			commands:      [][]string{{"EVAL", script, "1", "bar"}},
+			expectedReply: ":1\r\n",
+			expectedNode1: []string{"EVAL return 1 1 bar"},
+		},
+		"noscript loads the script again": {
+			commands:      [][]string{{"EVAL", script, "1", "foo"}, {"EVALSHA", sha, "1", "bar"}},
+			expectedReply: ":1\r\n",
+			expectedNode1: []string{"EVALSHA " + sha + " 1 bar", "SCRIPT LOAD return 1", "EVALSHA " + sha + " 1 bar"},
+			expectedNode2: []string{"EVAL return 1 1 foo"},
+		},
+		"unknown script": {
+			commands:      [][]string{{"EVALSHA", sha, "1", "bar"}},
+			expectedReply: noScript,
+			expectedNode1: []string{"EVALSHA " + sha + " 1 bar"},
+		},
+		"flushed script": {
+			commands:      [][]string{{"SCRIPT", "LOAD", script}, {"SCRIPT", "FLUSH"}, {"EVALSHA", sha, "1", "bar"}},
+			expectedReply: noScript,
+			expectedNode1: []string{"SCRIPT LOAD return 1", "SCRIPT FLUSH", "EVALSHA " + sha + " 1 bar"},
+			expectedNode2: []string{"SCRIPT LOAD return 1", "SCRIPT FLUSH"},
+		},
+		"keys in several slots": {
+			commands:      [][]string{{"FCALL", "f", "2", "bar", "foo"}},
+			expectedReply: "-CROSSSLOT Keys in request don't hash to the same slot\r\n",
+		},
+		"no keys": {
+			commands:      [][]string{{"FCALL", "f", "0"}},
+			expectedReply: "-" + noScriptKeysMessage + "\r\n",
+		},
+		"no keys on the script node": {
+			commands:      [][]string{{"EVAL", script, "0"}},
+			scriptNode:    ip_map.HostWithPort{Host: "10.0.0.2", Port: 7000},
+			expectedReply: ":1\r\n",
+			expectedNode2: []string{"EVAL return 1 0"},
+		},
+	}
+
+	for caseName, c := range cases {
+		// each node only has the scripts it was sent
+		node := func() func(args []string) string {
+			loaded := false
+			return func(args []string) string {
+				switch strings.ToUpper(args[0]) {
+				case "SCRIPT":
+					loaded = strings.EqualFold(args[1], "LOAD")
+					if loaded {
+						return "$40\r\n" + sha + "\r\n"
+					}
+					return "+OK\r\n"
+				case "EVALSHA":
+					if !loaded {
+						return noScript
+					}
+				}
+				return ":1\r\n"
+			}
+		}
+		cluster := newFakeCluster(map[string]func(args []string) string{"10.0.0.1:7000": node(), "10.0.0.2:7000": node()})
+		r := newRoutingRedis(cluster)
+		r.SetScriptNode(c.scriptNode)
+		reply := ""
+		for _, command := range c.commands {
+			reply = componentToString(t, r.route(commandFromWords(command...), nil))
+		}
+		assert.Equal(t, c.expectedReply, reply, caseName)
+		assert.Equal(t, c.expectedNode1, cluster.commands("10.0.0.1:7000"), caseName)
+		assert.Equal(t, c.expectedNode2, cluster.commands("10.0.0.2:7000"), caseName)
+	}
+}
@@ -0,0 +1,359 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/port_pool"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"strconv"
+	"strings"
+)
+
+// sentinelTopology is what the proxy knows about a Sentinel-managed deployment
+type sentinelTopology struct {
+	// masterNames are the masters, as named in the Sentinel configuration, whose servers are proxied
+	masterNames []string
+	// sentinels are the addresses of the Sentinels, as opposed to the masters and replicas. Guarded by topologyMu
+	sentinels map[ip_map.HostWithPort]bool
+}
+
+// NewSentinel creates a proxy for standalone Redis servers managed by Sentinel. sentinelAddr is any one of the Sentinels.
+// DiscoverAndListen asks it for the masters named in masterNames, their replicas and the other Sentinels, and opens a
+// local port for each. Sentinel replies are rewritten so that clients are sent the public host and the local ports
+func NewSentinel(listenAddr, sentinelAddr ip_map.HostWithPort, masterNames []string, publicHostname string, portKeeper port_pool.Counter, numberOfBuffers int, maxConcurrentConnections int, readBufferByteSize int) (redis *Redis) {
+	redis = NewRedis(listenAddr, sentinelAddr, publicHostname, portKeeper, numberOfBuffers, maxConcurrentConnections, readBufferByteSize)
+	redis.sentinel = &sentinelTopology{
+		masterNames: masterNames,
+		sentinels:   make(map[ip_map.HostWithPort]bool),
+	}
+	return
+}
+
+// SetSentinelCredentials sets the username and password the proxy uses to AUTH with the Sentinels. The masters and
+// replicas use the credentials from SetCredentials
+func (r *Redis) SetSentinelCredentials(credentials Credentials) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.sentinelCredentials = credentials
+}
+
+// isSentinelAddr is true if the proxy is in Sentinel mode and remote is the seed Sentinel or a Sentinel it discovered
+func (r *Redis) isSentinelAddr(remote ip_map.HostWithPort) bool {
+	if r.sentinel == nil {
+		return false
+	}
+	if remote == r.clusterAddr {
+		return true
+	}
+	r.topologyMu.RLock()
+	defer r.topologyMu.RUnlock()
+	return r.sentinel.sentinels[remote]
+}
+
+// refreshSentinelTopology asks the Sentinel on sentinel for every configured master, its replicas and the other Sentinels,
+// opens a local port for any it has not seen, and closes the ports of servers the Sentinels no longer report
+func (r *Redis) refreshSentinelTopology(sentinel net.Conn) (err error) {
+	buffer := r.buffers.Get()
+	if buffer == nil {
+		return fmt.Errorf("ran out of buffers")
+	}
+	defer r.buffers.Put(buffer)
+
+	servers := make([]ip_map.HostWithPort, 0, 8)
+	sentinels := make(map[ip_map.HostWithPort]bool)
+	// the Sentinel being asked does not list itself
+	if queried, parseErr := ip_map.NewHostWithPortFromString(sentinel.RemoteAddr().String()); parseErr == nil {
+		servers = append(servers, queried)
+		sentinels[queried] = true
+	}
+	for _, masterName := range r.sentinel.masterNames {
+		var reply redisPkg.Componenter
+		reply, err = querySentinel(sentinel, buffer, "get-master-addr-by-name", masterName)
+		if err != nil {
+			return
+		}
+		master, ok := sentinelMasterAddr(reply)
+		if !ok {
+			return fmt.Errorf("sentinel does not know the master '%s'", masterName)
+		}
+		servers = append(servers, master)
+
+		reply, err = querySentinel(sentinel, buffer, "replicas", masterName)
+		if err != nil {
+			return
+		}
+		servers = append(servers, sentinelInstanceAddrs(reply)...)
+
+		reply, err = querySentinel(sentinel, buffer, "sentinels", masterName)
+		if err != nil {
+			return
+		}
+		for _, addr := range sentinelInstanceAddrs(reply) {
+			servers = append(servers, addr)
+			sentinels[addr] = true
+		}
+	}
+
+	departures, err := r.updateSentinelTopology(servers, sentinels)
+	departAll(departures)
+	return
+}
+
+// updateSentinelTopology makes sure every one of servers has a local port, and returns the servers that are gone
+func (r *Redis) updateSentinelTopology(servers []ip_map.HostWithPort, sentinels map[ip_map.HostWithPort]bool) (departures []departure, err error) {
+	r.topologyMu.Lock()
+	defer r.topologyMu.Unlock()
+	r.sentinel.sentinels = sentinels
+	serverAddrs := make(map[ip_map.HostWithPort]bool)
+	for _, server := range servers {
+		if serverAddrs[server] {
+			continue
+		}
+		serverAddrs[server] = true
+		err = r.listenForNode("", server)
+		if err != nil {
+			return
+		}
+	}
+	return r.removeDeparted(nil, serverAddrs), nil
+}
+
+// querySentinel sends SENTINEL subcommand args... and returns the reply. Error replies are returned as errors
+func querySentinel(sentinel net.Conn, buffer []byte, subcommand string, args ...string) (reply redisPkg.Componenter, err error) {
+	reply, err = queryCluster(sentinel, commandStatement(append([]string{"SENTINEL", subcommand}, args...)...), buffer)
+	if err != nil {
+		return
+	}
+	if reply == nil {
+		return nil, fmt.Errorf("sentinel closed the connection during SENTINEL %s", subcommand)
+	}
+	if errorReply, ok := reply.(*redisPkg.ErrorComp); ok {
+		return nil, fmt.Errorf("SENTINEL %s failed: %s", subcommand, errorReply.String())
+	}
+	return
+}
+
+// commandStatement serializes a command with its arguments as an array of bulk strings
+func commandStatement(args ...string) string {
+	command := make(redisPkg.Array, len(args))
+	for i, arg := range args {
+		command[i] = redisPkg.NewBulkStringFromString(arg)
+	}
+	buffer := &bytes.Buffer{}
+	_, _ = redisPkg.ComponentToStream(buffer, &command)
+	return buffer.String()
+}
+
+// sentinelMasterAddr reads the reply to SENTINEL get-master-addr-by-name, an array of the ip and the port
+func sentinelMasterAddr(reply redisPkg.Componenter) (addr ip_map.HostWithPort, ok bool) {
+	array, ok := reply.(*redisPkg.Array)
+	if !ok || len(*array) != 2 {
+		return addr, false
+	}
+	host, hostOk := (*array)[0].(*redisPkg.BulkString)
+	port, portOk := (*array)[1].(*redisPkg.BulkString)
+	if !hostOk || !portOk {
+		return addr, false
+	}
+	portNumber, err := strconv.ParseUint(port.String(), 10, 16)
+	if err != nil {
+		return addr, false
+	}
+	return ip_map.HostWithPort{Host: host.String(), Port: uint16(portNumber)}, true
+}
+
+// sentinelInstanceAddrs reads the addresses from the reply to SENTINEL replicas or SENTINEL sentinels, an array with a
+// list of field names and values for each instance
+func sentinelInstanceAddrs(reply redisPkg.Componenter) (addrs []ip_map.HostWithPort) {
+	instances, ok := reply.(*redisPkg.Array)
+	if !ok {
+		return
+	}
+	for _, instance := range *instances {
+		fields, ok := instance.(*redisPkg.Array)
+		if !ok {
+			continue
+		}
+		if addr, ok := sentinelFieldsAddr(fields, "ip", "port"); ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	return
+}
+
+// sentinelField returns the value of the field called name in a flat list of field names and values
+func sentinelField(fields *redisPkg.Array, name string) (value string, ok bool) {
+	for i := 0; i+1 < len(*fields); i += 2 {
+		key, isBulk := (*fields)[i].(*redisPkg.BulkString)
+		if !isBulk || key.String() != name {
+			continue
+		}
+		if value, isBulk := (*fields)[i+1].(*redisPkg.BulkString); isBulk {
+			return value.String(), true
+		}
+	}
+	return "", false
+}
+
+// setSentinelField replaces the value of the field called name, if it is present
+func setSentinelField(fields *redisPkg.Array, name, value string) {
+	for i := 0; i+1 < len(*fields); i += 2 {
+		if key, isBulk := (*fields)[i].(*redisPkg.BulkString); isBulk && key.String() == name {
+			(*fields)[i+1] = redisPkg.NewBulkStringFromString(value)
+		}
+	}
+}
+
+// sentinelFieldsAddr reads the address held in the hostField and portField fields
+func sentinelFieldsAddr(fields *redisPkg.Array, hostField, portField string) (addr ip_map.HostWithPort, ok bool) {
+	host, hostOk := sentinelField(fields, hostField)
+	port, portOk := sentinelField(fields, portField)
+	if !hostOk || !portOk {
+		return addr, false
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return addr, false
+	}
+	return ip_map.HostWithPort{Host: host, Port: uint16(portNumber)}, true
+}
+
+// sentinelRewriters translate the addresses in the replies of the Sentinel at clusterAddr for clients connected through
+// the horizon at horizonIndex. Masters and replicas are proxied unchanged
+func (r *Redis) sentinelRewriters(clusterAddr ip_map.HostWithPort, horizonIndex int) (intercept, reWrite RewriteFunc) {
+	intercept = func(componenterIn redisPkg.Componenter) redisPkg.Componenter {
+		return nil
+	}
+	if !r.isSentinelAddr(clusterAddr) {
+		reWrite = func(componenterIn redisPkg.Componenter) redisPkg.Componenter {
+			return componenterIn
+		}
+		return
+	}
+	reWrite = func(componenterIn redisPkg.Componenter) redisPkg.Componenter {
+		return mutateSentinelReply(componenterIn, r.publicHostFor(horizonIndex), r.ipMap, r.RequestTopologyRefresh)
+	}
+	return
+}
+
+// mutateSentinelReply rewrites the private addresses in a Sentinel reply to the public host and the local ports. It handles
+// SENTINEL get-master-addr-by-name, told apart by its host, the instance lists of SENTINEL masters, master, replicas and sentinels, and the events
+// Sentinel publishes, such as +switch-master. onUnknown is called when a reply names a server the proxy has no port for
+func mutateSentinelReply(componenterIn redisPkg.Componenter, publicHostname string, lookup *ip_map.Concurrent, onUnknown func()) (componenterOut redisPkg.Componenter) {
+	array, ok := componenterIn.(*redisPkg.Array)
+	if !ok || len(*array) == 0 {
+		return componenterIn
+	}
+	translate := func(remote ip_map.HostWithPort) (local ip_map.HostWithPort, ok bool) {
+		port, ok := lookup.RemoteToLocal(remote)
+		if !ok {
+			log.Println("no mapping from sentinel address: " + remote.String())
+			onUnknown()
+			return
+		}
+		return ip_map.HostWithPort{Host: publicHostname, Port: port}, true
+	}
+
+	if remote, isMasterAddr := sentinelMasterAddr(array); isMasterAddr && isServerHost(remote.Host, lookup) {
+		if local, ok := translate(remote); ok {
+			(*array)[0] = redisPkg.NewBulkStringFromString(local.Host)
+			(*array)[1] = redisPkg.NewBulkStringFromString(strconv.Itoa(int(local.Port)))
+		}
+		return array
+	}
+	if mutateSentinelEvent(array, lookup, publicHostname, onUnknown) {
+		return array
+	}
+	if mutateSentinelInstance(array, translate) {
+		return array
+	}
+	for _, instance := range *array {
+		if fields, ok := instance.(*redisPkg.Array); ok {
+			mutateSentinelInstance(fields, translate)
+		}
+	}
+	return array
+}
+
+// isServerHost is true if host is an IP or the host of a server the proxy has a port for. It tells the reply to SENTINEL
+// get-master-addr-by-name apart from other pairs, such as the reply to SENTINEL CONFIG GET announce-port
+func isServerHost(host string, lookup *ip_map.Concurrent) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	for _, remote := range lookup.SnapshotLocalsToRemotes() {
+		if remote.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// mutateSentinelInstance rewrites the address fields of one instance from a SENTINEL masters, master, replicas or sentinels
+// reply. Returns false if fields is not an instance
+func mutateSentinelInstance(fields *redisPkg.Array, translate func(remote ip_map.HostWithPort) (ip_map.HostWithPort, bool)) bool {
+	remote, ok := sentinelFieldsAddr(fields, "ip", "port")
+	if !ok {
+		return false
+	}
+	if local, ok := translate(remote); ok {
+		// replicas and sentinels are named after their address
+		if name, _ := sentinelField(fields, "name"); name == remote.ClusterString() {
+			setSentinelField(fields, "name", local.ClusterString())
+		}
+		setSentinelField(fields, "ip", local.Host)
+		setSentinelField(fields, "port", strconv.Itoa(int(local.Port)))
+	}
+	if master, ok := sentinelFieldsAddr(fields, "master-host", "master-port"); ok {
+		if local, ok := translate(master); ok {
+			setSentinelField(fields, "master-host", local.Host)
+			setSentinelField(fields, "master-port", strconv.Itoa(int(local.Port)))
+		}
+	}
+	return true
+}
+
+// mutateSentinelEvent rewrites every "ip port" pair in the payload of a message published by Sentinel, such as
+// "+switch-master mymaster 10.0.0.2 6379 10.0.0.3 6379". A +switch-master also refreshes the topology so that a master that
+// moved to a server the proxy has not seen gets a port. Returns false if array is not a published message
+func mutateSentinelEvent(array *redisPkg.Array, lookup *ip_map.Concurrent, publicHostname string, onUnknown func()) bool {
+	kind, ok := (*array)[0].(*redisPkg.BulkString)
+	if !ok {
+		return false
+	}
+	var channelIndex int
+	switch {
+	case strings.EqualFold(kind.String(), "message") && len(*array) == 3:
+		channelIndex = 1
+	case strings.EqualFold(kind.String(), "pmessage") && len(*array) == 4:
+		channelIndex = 2
+	default:
+		return false
+	}
+	channel, channelOk := (*array)[channelIndex].(*redisPkg.BulkString)
+	payload, payloadOk := (*array)[channelIndex+1].(*redisPkg.BulkString)
+	if !channelOk || !payloadOk {
+		return true
+	}
+	if channel.String() == "+switch-master" {
+		onUnknown()
+	}
+
+	words := strings.Split(payload.String(), " ")
+	for i := 0; i+1 < len(words); i++ {
+		port, err := strconv.ParseUint(words[i+1], 10, 16)
+		if err != nil {
+			continue
+		}
+		if local, known := lookup.RemoteToLocal(ip_map.HostWithPort{Host: words[i], Port: uint16(port)}); known {
+			words[i] = publicHostname
+			words[i+1] = strconv.Itoa(int(local))
+			i++
+		}
+	}
+	(*array)[channelIndex+1] = redisPkg.NewBulkStringFromString(strings.Join(words, " "))
+	return true
+}
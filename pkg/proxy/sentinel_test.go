@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/redis"
+	"testing"
+)
+
+func TestMutateSentinelReply(t *testing.T) {
+	lookup := ip_map.NewConcurrent()
+	lookup.Create(ip_map.HostWithPort{Host: "10.0.0.2", Port: 6379}, 8000)
+	lookup.Create(ip_map.HostWithPort{Host: "10.0.0.3", Port: 6379}, 8001)
+	lookup.Create(ip_map.HostWithPort{Host: "10.0.0.4", Port: 26379}, 8002)
+
+	cases := map[string]struct {
+		input           string
+		expected        string
+		expectedRefresh bool
+	}{
+		"get-master-addr-by-name": {
+			input:    "*2\r\n$8\r\n10.0.0.2\r\n$4\r\n6379\r\n",
+			expected: "*2\r\n$6\r\npublic\r\n$4\r\n8000\r\n",
+		},
+		"unknown master": {
+			input:           "*2\r\n$8\r\n10.0.0.9\r\n$4\r\n6379\r\n",
+			expected:        "*2\r\n$8\r\n10.0.0.9\r\n$4\r\n6379\r\n",
+			expectedRefresh: true,
+		},
+		"config get port": {
+			input:    "*2\r\n$13\r\nannounce-port\r\n$1\r\n0\r\n",
+			expected: "*2\r\n$13\r\nannounce-port\r\n$1\r\n0\r\n",
+		},
+		"replicas": {
+			input:    "*1\r\n*10\r\n$4\r\nname\r\n$13\r\n10.0.0.3:6379\r\n$2\r\nip\r\n$8\r\n10.0.0.3\r\n$4\r\nport\r\n$4\r\n6379\r\n$11\r\nmaster-host\r\n$8\r\n10.0.0.2\r\n$11\r\nmaster-port\r\n$4\r\n6379\r\n",
+			expected: "*1\r\n*10\r\n$4\r\nname\r\n$11\r\npublic:8001\r\n$2\r\nip\r\n$6\r\npublic\r\n$4\r\nport\r\n$4\r\n8001\r\n$11\r\nmaster-host\r\n$6\r\npublic\r\n$11\r\nmaster-port\r\n$4\r\n8000\r\n",
+		},
+		"master keeps its name": {
+			input:    "*6\r\n$4\r\nname\r\n$8\r\nmymaster\r\n$2\r\nip\r\n$8\r\n10.0.0.2\r\n$4\r\nport\r\n$4\r\n6379\r\n",
+			expected: "*6\r\n$4\r\nname\r\n$8\r\nmymaster\r\n$2\r\nip\r\n$6\r\npublic\r\n$4\r\nport\r\n$4\r\n8000\r\n",
+		},
+		"switch-master": {
+			input:           "*3\r\n$7\r\nmessage\r\n$14\r\n+switch-master\r\n$36\r\nmymaster 10.0.0.2 6379 10.0.0.3 6379\r\n",
+			expected:        "*3\r\n$7\r\nmessage\r\n$14\r\n+switch-master\r\n$32\r\nmymaster public 8000 public 8001\r\n",
+			expectedRefresh: true,
+		},
+		"sentinel event": {
+			input:    "*3\r\n$7\r\nmessage\r\n$6\r\n+sdown\r\n$52\r\nsentinel abc 10.0.0.4 26379 @ mymaster 10.0.0.2 6379\r\n",
+			expected: "*3\r\n$7\r\nmessage\r\n$6\r\n+sdown\r\n$47\r\nsentinel abc public 8002 @ mymaster public 8000\r\n",
+		},
+	}
+
+	for caseName, c := range cases {
+		input, err := stringToComponents(c.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refreshed := false
+		actual := mutateSentinelReply(input, "public", lookup, func() { refreshed = true })
+		assert.Equal(t, c.expected, componentToString(t, actual), caseName)
+		assert.Equal(t, c.expectedRefresh, refreshed, caseName)
+	}
+}
+
+func TestSentinelInstanceAddrs(t *testing.T) {
+	reply, err := stringToComponents("*2\r\n*4\r\n$2\r\nip\r\n$8\r\n10.0.0.4\r\n$4\r\nport\r\n$5\r\n26379\r\n*4\r\n$2\r\nip\r\n$8\r\n10.0.0.5\r\n$4\r\nport\r\n$5\r\n26379\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []ip_map.HostWithPort{
+		{Host: "10.0.0.4", Port: 26379},
+		{Host: "10.0.0.5", Port: 26379},
+	}, sentinelInstanceAddrs(reply))
+	assert.Empty(t, sentinelInstanceAddrs(redis.NewNull()))
+}
+
+func componentToString(t *testing.T, component redis.Componenter) string {
+	buffer := &bytes.Buffer{}
+	_, err := redis.ComponentToStream(buffer, component)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Timeouts controls how long the proxy waits on either side of a proxied connection. A zero value disables that timeout
+type Timeouts struct {
+	// ClientIdle is how long a client may go without sending a command before it is disconnected
+	ClientIdle time.Duration
+	// BackendRead is how long the proxy waits for the cluster to reply to a command it forwarded
+	BackendRead time.Duration
+	// Write is how long a single write to either the client or the cluster may take
+	Write time.Duration
+	// KeepAlive is the TCP keep-alive period applied to client and cluster sockets
+	KeepAlive time.Duration
+}
+
+// clusterDialTimeout bounds how long connecting to a cluster node, including the TLS handshake and AUTH, may take
+var clusterDialTimeout = 10 * time.Second
+
+var (
+	ErrClientIdleTimeout  = errors.New("client idle timeout")
+	ErrBackendReadTimeout = errors.New("backend read timeout")
+	ErrWriteTimeout       = errors.New("write timeout")
+)
+
+// Metric names used to count connections closed because of a timeout
+const (
+	MetricClientIdleTimeout  = "timeout.client_idle"
+	MetricBackendReadTimeout = "timeout.backend_read"
+	MetricWriteTimeout       = "timeout.write"
+)
+
+// timeoutMetricName maps an error returned by Bidirectional to the counter for its timeout reason. Returns an empty string if the error was not a timeout
+func timeoutMetricName(err error) string {
+	switch {
+	case errors.Is(err, ErrClientIdleTimeout):
+		return MetricClientIdleTimeout
+	case errors.Is(err, ErrBackendReadTimeout):
+		return MetricBackendReadTimeout
+	case errors.Is(err, ErrWriteTimeout):
+		return MetricWriteTimeout
+	}
+	return ""
+}
+
+func isTimeout(err error) bool {
+	if netErr, ok := err.(net.Error); ok {
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// setKeepAlive enables TCP keep-alive on conn if it is a TCP socket and a period was configured
+func setKeepAlive(conn net.Conn, period time.Duration) {
+	if period <= 0 {
+		return
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetKeepAlive(true)
+		_ = tcpConn.SetKeepAlivePeriod(period)
+	}
+}
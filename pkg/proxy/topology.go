@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"redis_cluster_proxy/pkg/ip_map"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"time"
+)
+
+// RefreshTopology asks the cluster for its current slots and nodes and updates the mappings. Nodes that are found by their
+// node ID keep their local port, even if they now have a different address. New nodes get a new listener and nodes that
+// left the cluster lose theirs.
+// The seed clusterAddr is tried first, then every node the proxy already knows, so a refresh still works if the seed node is
+// gone or stops answering. In Sentinel mode only the known Sentinels are tried
+func (r *Redis) RefreshTopology() (err error) {
+	candidates := []ip_map.HostWithPort{r.clusterAddr}
+	for _, remote := range r.ipMap.SnapshotLocalsToRemotes() {
+		if r.sentinel == nil || r.isSentinelAddr(remote) {
+			candidates = append(candidates, remote)
+		}
+	}
+	for _, candidate := range candidates {
+		var cluster net.Conn
+		cluster, err = r.dialCluster(candidate)
+		if err != nil {
+			continue
+		}
+		err = r.refreshTopology(cluster)
+		_ = cluster.Close()
+		if !isTimeout(err) {
+			return
+		}
+	}
+	return fmt.Errorf("unable to reach any cluster node to refresh the topology: %w", err)
+}
+
+// RequestTopologyRefresh asks WatchTopology to refresh as soon as it can. Does not block
+func (r *Redis) RequestTopologyRefresh() {
+	select {
+	case r.refreshNow <- struct{}{}:
+	default:
+		// a refresh is already pending
+	}
+}
+
+// WatchTopology refreshes the topology every interval, and whenever RequestTopologyRefresh is called, until Close is called.
+// An interval of 0 only refreshes on request
+func (r *Redis) WatchTopology(interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-tick:
+		case <-r.refreshNow:
+		}
+		if err := r.RefreshTopology(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// topology returns the latest CLUSTER SLOTS and CLUSTER NODES responses from the cluster. The slices are replaced, never modified, on refresh
+func (r *Redis) topology() ([]redisPkg.ClusterSlotResp, []redisPkg.ClusterNodeResp) {
+	r.topologyMu.RLock()
+	defer r.topologyMu.RUnlock()
+	return r.facadeClusterSlotsResp, r.facadeClusterNodesResp
+}
+
+// refreshTopology asks cluster for the topology. The whole exchange must be over within clusterDialTimeout, so that a node
+// that stops answering cannot hold up the refresh
+func (r *Redis) refreshTopology(cluster net.Conn) (err error) {
+	_ = cluster.SetDeadline(time.Now().Add(clusterDialTimeout))
+	defer func() { _ = cluster.SetDeadline(time.Time{}) }()
+	if r.sentinel != nil {
+		return r.refreshSentinelTopology(cluster)
+	}
+	buffer := r.buffers.Get()
+	if buffer == nil {
+		return fmt.Errorf("ran out of buffers")
+	}
+	defer r.buffers.Put(buffer)
+
+	responseComponent, err := queryCluster(cluster, ClusterSlotsDiscoverStatement, buffer)
+	if err != nil {
+		return
+	}
+	clusterSlotsResp, err := redisPkg.NewSlotArrayFromComponent(responseComponent)
+	if err != nil {
+		log.Println("Unable to read the cluster response: " + err.Error())
+		return err
+	}
+
+	responseComponent, err = queryCluster(cluster, ClusterNodeDiscoverStatement, buffer)
+	if err != nil {
+		return
+	}
+	clusterNodesResp, err := redisPkg.NewClusterNodesRespFromComponent(responseComponent)
+	if err != nil {
+		log.Println("Unable to read the cluster response: " + err.Error())
+		return err
+	}
+
+	departures, err := r.updateTopology(clusterSlotsResp, clusterNodesResp)
+	departAll(departures)
+	return
+}
+
+// updateTopology replaces the topology with the CLUSTER SLOTS and CLUSTER NODES responses, and returns the nodes that left
+func (r *Redis) updateTopology(clusterSlotsResp []redisPkg.ClusterSlotResp, clusterNodesResp []redisPkg.ClusterNodeResp) (departures []departure, err error) {
+	r.topologyMu.Lock()
+	defer r.topologyMu.Unlock()
+	r.facadeClusterSlotsResp = clusterSlotsResp
+	r.facadeClusterNodesResp = clusterNodesResp
+	err = r.listenForServers(serversFromSlotResp(clusterSlotsResp))
+	if err != nil {
+		return
+	}
+	return r.removeDepartedNodes(clusterNodesResp), nil
+}
+
+func queryCluster(cluster net.Conn, statement string, buffer []byte) (responseComponent redisPkg.Componenter, err error) {
+	_, err = cluster.Write([]byte(statement))
+	if err != nil && io.EOF != err {
+		log.Println("io error while writing to cluster socket: " + err.Error())
+		return
+	}
+	responseComponent, _, err = redisPkg.ComponentFromReader(cluster, buffer)
+	if err != nil && io.EOF != err {
+		log.Println("io error while reading cluster socket: " + err.Error())
+		return
+	}
+	return responseComponent, nil
+}
+
+// listenForServers makes sure every server has a local port. Must be called with topologyMu held
+func (r *Redis) listenForServers(servers []redisPkg.ClusterServerResp) (err error) {
+	for _, server := range servers {
+		err = r.listenForNode(server.Id(), ip_map.HostWithPort{Host: server.Ip(), Port: server.Port()})
+		if err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// listenForNode makes sure the node has a local port. An empty nodeId tracks the node by its address only.
+// Must be called with topologyMu held
+func (r *Redis) listenForNode(nodeId string, serverAddr ip_map.HostWithPort) (err error) {
+	if len(nodeId) != 0 {
+		if local, known := r.ipMap.NodeToLocal(nodeId); known {
+			if remote, _ := r.ipMap.LocalToRemote(local); remote != serverAddr {
+				r.ipMap.MoveNode(nodeId, serverAddr)
+				log.Printf("node %s moved from %s to %s, still listening on: %d\n", nodeId, remote.String(), serverAddr.String(), local)
+			}
+			return
+		}
+	}
+	if local, exists := r.ipMap.RemoteToLocal(serverAddr); exists {
+		// a node at a known address, such as a replacement node, keeps the port of the address
+		r.ipMap.CreateNode(nodeId, serverAddr, local)
+		return
+	}
+
+	// No mapping exists, open a socket on every horizon to service it
+	port, err := r.allocatePort(nodeId, serverAddr)
+	if err != nil {
+		return
+	}
+	tracked, err := r.listenOnHorizons(port)
+	if err != nil {
+		r.releasePort(port)
+		return
+	}
+	r.listeners[port] = tracked
+	// create the mapping entry for the new sockets
+	r.ipMap.CreateNode(nodeId, serverAddr, port)
+	return nil
+}
+
+// listenOnHorizons binds port on the host of every horizon and starts accepting clients. If any bind fails, nothing is left listening
+func (r *Redis) listenOnHorizons(port uint16) (tracked *nodeListener, err error) {
+	horizons := r.liveSettings().horizons
+	listeners := make([]net.Listener, 0, len(horizons))
+	for _, horizon := range horizons {
+		var listener net.Listener
+		listener, err = net.Listen("tcp", ip_map.HostWithPort{Host: horizon.BindHost, Port: port}.String())
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	tracked = newNodeListener(listeners)
+	for horizonIndex, listener := range listeners {
+		localAddr := ip_map.HostWithPort{Host: horizons[horizonIndex].BindHost, Port: port}
+		go func(listener net.Listener, localAddr ip_map.HostWithPort, horizonIndex int) {
+			_ = listenLoop(listener, tracked, r, localAddr, horizonIndex)
+		}(listener, localAddr, horizonIndex)
+	}
+	return
+}
+
+// removeDepartedNodes removes every mapped node that is no longer in CLUSTER NODES, such as nodes removed with CLUSTER
+// FORGET or scaled away. The mapping is deleted and the port goes back to the pool. Their listeners are returned for
+// departAll to close and disconnect their clients. Must be called with topologyMu held
+func (r *Redis) removeDepartedNodes(nodes []redisPkg.ClusterNodeResp) []departure {
+	nodeIds := make(map[string]bool)
+	nodeAddrs := make(map[ip_map.HostWithPort]bool)
+	for _, node := range nodes {
+		nodeIds[node.Id()] = true
+		nodeAddrs[ip_map.HostWithPort{Host: node.Ip(), Port: node.Port()}] = true
+	}
+	return r.removeDeparted(nodeIds, nodeAddrs)
+}
+
+// removeDeparted removes every mapped node that is neither in nodeIds nor, for nodes without an ID, in nodeAddrs, and
+// returns their listeners. Must be called with topologyMu held
+func (r *Redis) removeDeparted(nodeIds map[string]bool, nodeAddrs map[ip_map.HostWithPort]bool) (departures []departure) {
+	if len(nodeAddrs) == 0 {
+		// never tear everything down because of an empty response
+		return
+	}
+	for local, remote := range r.ipMap.SnapshotLocalsToRemotes() {
+		if nodeId, ok := r.ipMap.LocalToNode(local); ok {
+			if nodeIds[nodeId] {
+				continue
+			}
+		} else if nodeAddrs[remote] {
+			continue
+		}
+
+		log.Printf("node %s left the cluster, no longer listening on: %d\n", remote.String(), local)
+		if listener, ok := r.listeners[local]; ok {
+			departures = append(departures, departure{listener: listener, reason: departedNodeNotice(remote)})
+			delete(r.listeners, local)
+		}
+		r.ipMap.Delete(local)
+		r.releasePort(local)
+	}
+	return
+}
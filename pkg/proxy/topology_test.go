@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/port_pool"
+	"redis_cluster_proxy/pkg/redis"
+	"testing"
+	"time"
+)
+
+func TestRemoveDepartedNodes(t *testing.T) {
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	stayingAddr := ip_map.HostWithPort{Host: "172.22.0.2", Port: 7000}
+	departingAddr := ip_map.HostWithPort{Host: "172.22.0.2", Port: 7001}
+	r.ipMap.CreateNode("901e06d850fe7a21253fbb200b5bdd55d3286848", stayingAddr, 8000)
+	r.ipMap.CreateNode("543675033db89351b9e054ce0eef39294e282c4f", departingAddr, 8001)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.listeners[8001] = newNodeListener([]net.Listener{listener})
+
+	// a client of the departing node should be told why it was disconnected
+	clientSide, proxyClientSide := net.Pipe()
+	proxyClusterSide, _ := net.Pipe()
+	r.listeners[8001].track(proxyClientSide, proxyClusterSide)
+	hooks := ClientHooks{Farewell: r.listeners[8001].farewell}
+	doneChan := make(chan error, 2)
+	Bidirectional(proxyClientSide, proxyClusterSide, noIntercept, passThrough, hooks, make([]byte, BufferSizeBytes), make([]byte, BufferSizeBytes), doneChan, Timeouts{}, func() bool { return false })
+	noticeChan := make(chan redis.Componenter, 1)
+	go func() {
+		notice, _, _ := redis.ComponentFromReader(clientSide, make([]byte, BufferSizeBytes))
+		noticeChan <- notice
+	}()
+
+	staying, err := redis.NewClusterNodeRespFromStringRecord("901e06d850fe7a21253fbb200b5bdd55d3286848 172.22.0.2:7000@17000 master - 0 0 1 connected 0-16383")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.topologyMu.Lock()
+	departures := r.removeDepartedNodes([]redis.ClusterNodeResp{staying})
+	r.topologyMu.Unlock()
+	assert.Len(t, departures, 1, "only the departed node is disconnected")
+	departAll(departures)
+
+	_, ok := r.ipMap.LocalToRemote(8000)
+	assert.True(t, ok, "node still in the cluster keeps its mapping")
+	_, ok = r.ipMap.LocalToRemote(8001)
+	assert.False(t, ok, "departed node mapping is removed")
+	_, ok = r.listeners[8001]
+	assert.False(t, ok, "departed node listener is removed")
+	_, err = listener.Accept()
+	assert.Error(t, err, "departed node listener is closed")
+
+	notice := <-noticeChan
+	if assert.IsType(t, redis.NewErrorFromString(""), notice) {
+		assert.Contains(t, notice.(*redis.ErrorComp).String(), departingAddr.String())
+	}
+}
+
+func TestRefreshTopologySkipsSilentNode(t *testing.T) {
+	// the seed node accepts connections but never answers
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = silent.Close() }()
+	go func() {
+		for {
+			conn, acceptErr := silent.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go func() { _, _ = ioutil.ReadAll(conn) }()
+		}
+	}()
+
+	// the other known node answers CLUSTER SLOTS and CLUSTER NODES with itself
+	const nodeId = "901e06d850fe7a21253fbb200b5bdd55d3286848"
+	answering, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = answering.Close() }()
+	answeringAddr, err := ip_map.NewHostWithPortFromString(answering.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := fmt.Sprintf("%s %s@17000 myself,master - 0 0 1 connected 0-16383\n", nodeId, answeringAddr.String())
+	replies := map[string]string{
+		ClusterSlotsDiscoverStatement: fmt.Sprintf("*1\r\n*3\r\n:0\r\n:16383\r\n*3\r\n$9\r\n127.0.0.1\r\n:%d\r\n$40\r\n%s\r\n", answeringAddr.Port, nodeId),
+		ClusterNodeDiscoverStatement:  fmt.Sprintf("$%d\r\n%s\r\n", len(nodes), nodes),
+	}
+	go func() {
+		conn, acceptErr := answering.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		buffer := make([]byte, BufferSizeBytes)
+		for {
+			command, _, readErr := redis.ComponentFromReader(conn, buffer)
+			if readErr != nil {
+				return
+			}
+			statement := &bytes.Buffer{}
+			_, _ = redis.ComponentToStream(statement, command)
+			if _, readErr = conn.Write([]byte(replies[statement.String()])); readErr != nil {
+				return
+			}
+		}
+	}()
+
+	defer func(previous time.Duration) { clusterDialTimeout = previous }(clusterDialTimeout)
+	clusterDialTimeout = 50 * time.Millisecond
+	seedAddr, err := ip_map.NewHostWithPortFromString(silent.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, seedAddr, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	r.ipMap.CreateNode(nodeId, answeringAddr, 8000)
+
+	start := time.Now()
+	assert.NoError(t, r.RefreshTopology(), "the node that answers is asked after the seed times out")
+	assert.True(t, time.Since(start) < time.Second)
+	slots, _ := r.topology()
+	assert.Len(t, slots, 1)
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"fmt"
+	"redis_cluster_proxy/pkg/ip_map"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"strings"
+)
+
+// Errors sent to clients of the routing endpoint for transactions
+const (
+	execAbortMessage  = "EXECABORT Transaction discarded because of previous errors."
+	slotMovingMessage = "TRYAGAIN Hash slot of the watched keys is moving, retry the transaction"
+)
+
+var (
+	multiCommand   = &redisPkg.Array{redisPkg.NewBulkStringFromString("MULTI")}
+	execCommand    = &redisPkg.Array{redisPkg.NewBulkStringFromString("EXEC")}
+	unwatchCommand = &redisPkg.Array{redisPkg.NewBulkStringFromString("UNWATCH")}
+)
+
+// routedTransaction follows WATCH and MULTI for one client of the routing endpoint. The commands after MULTI are held by
+// the proxy and sent to the node with MULTI and EXEC in one go when the client sends EXEC. WATCH has to reach the node
+// when it is sent, so it pins a backend connection to the session until EXEC, DISCARD or UNWATCH
+type routedTransaction struct {
+	// slot is the slot of every key of the transaction and of WATCH. Negative until a command with keys is seen
+	slot    int
+	inMulti bool
+	queued  []redisPkg.Componenter
+	// aborted is set when a command was rejected after MULTI, so that EXEC fails as it does in Redis 7
+	aborted bool
+	// pinned is the connection WATCH was sent on, which still has to be sent UNWATCH, or EXEC, before it is pooled again
+	pinned *pooledConnection
+}
+
+func newRoutedTransaction() routedTransaction {
+	return routedTransaction{slot: -1}
+}
+
+// transact handles the commands of transactions. handled is false for commands that are routed as usual
+func (s *routedSession) transact(command redisPkg.Componenter) (reply redisPkg.Componenter, handled bool) {
+	args, _ := commandArgs(command)
+	switch strings.ToLower(args[0]) {
+	case "multi":
+		if s.tx.inMulti {
+			s.tx.aborted = true
+			return redisPkg.NewErrorFromString("ERR MULTI calls can not be nested"), true
+		}
+		s.tx.inMulti = true
+		return redisPkg.NewSimpleStringFromString("OK"), true
+	case "exec":
+		if !s.tx.inMulti {
+			return redisPkg.NewErrorFromString("ERR EXEC without MULTI"), true
+		}
+		return s.exec(), true
+	case "discard":
+		if !s.tx.inMulti {
+			return redisPkg.NewErrorFromString("ERR DISCARD without MULTI"), true
+		}
+		s.endTransaction()
+		return redisPkg.NewSimpleStringFromString("OK"), true
+	case "watch":
+		if s.tx.inMulti {
+			s.tx.aborted = true
+			return redisPkg.NewErrorFromString("ERR WATCH inside MULTI is not allowed"), true
+		}
+		return s.watch(command, args), true
+	case "unwatch":
+		if !s.tx.inMulti {
+			s.endTransaction()
+			return redisPkg.NewSimpleStringFromString("OK"), true
+		}
+	}
+	if !s.tx.inMulti {
+		return nil, false
+	}
+	return s.queue(command, args), true
+}
+
+// useSlot checks that the keys of a command are in the transaction's slot, which the first command with keys sets
+func (s *routedSession) useSlot(args []string) (reply redisPkg.Componenter) {
+	spec, known := s.r.commandTable().Lookup(args)
+	if !known {
+		return redisPkg.NewErrorFromString(fmt.Sprintf("ERR the proxy does not know which arguments of '%s' are keys, so it cannot be used in a transaction", strings.ToLower(args[0])))
+	}
+	slots := keySlots(spec.Keys(args))
+	if len(slots) == 0 {
+		return nil
+	}
+	if len(slots) > 1 || (s.tx.slot >= 0 && slots[0] != s.tx.slot) {
+		s.r.metrics.Incr(metricRoutingCrossSlot)
+		return redisPkg.NewErrorFromString(crossSlotMessage)
+	}
+	s.tx.slot = slots[0]
+	return nil
+}
+
+// queue holds a command sent after MULTI until EXEC. Keys outside the transaction's slot fail the transaction early
+func (s *routedSession) queue(command redisPkg.Componenter, args []string) redisPkg.Componenter {
+	if reply := s.useSlot(args); reply != nil {
+		s.tx.aborted = true
+		return reply
+	}
+	s.tx.queued = append(s.tx.queued, command)
+	return redisPkg.NewSimpleStringFromString("QUEUED")
+}
+
+// watch sends WATCH to the master of the watched keys' slot, over the connection pinned to the session
+func (s *routedSession) watch(command redisPkg.Componenter, args []string) redisPkg.Componenter {
+	if len(args) < 2 {
+		return redisPkg.NewErrorFromString("ERR wrong number of arguments for 'watch' command")
+	}
+	if reply := s.useSlot(args); reply != nil {
+		return reply
+	}
+	if s.tx.pinned == nil {
+		addr, ok := s.r.slotOwner(s.tx.slot)
+		if !ok {
+			return redisPkg.NewErrorFromString(slotNotServed)
+		}
+		conn, err := s.r.backends.Dial(addr.String())
+		if err != nil {
+			return s.r.backendError(err)
+		}
+		s.tx.pinned = conn.(*pooledConnection)
+	}
+	reply, err := s.sendPinned(command)
+	if err != nil {
+		return s.r.backendError(err)
+	}
+	if _, _, redirected := redirection(reply); redirected {
+		s.r.RequestTopologyRefresh()
+		s.endTransaction()
+		return redisPkg.NewErrorFromString(slotMovingMessage)
+	}
+	return reply
+}
+
+func (s *routedSession) sendPinned(commands ...redisPkg.Componenter) (reply redisPkg.Componenter, err error) {
+	buffer := s.r.buffers.Get()
+	if buffer == nil {
+		return nil, fmt.Errorf("ran out of buffers")
+	}
+	defer s.r.buffers.Put(buffer)
+	replies, err := s.r.exchange(s.tx.pinned, commands, buffer, nil)
+	if err != nil {
+		// the connection was discarded, and the node forgot the watched keys with it
+		s.tx.pinned = nil
+		return
+	}
+	return replies[len(replies)-1], nil
+}
+
+// exec sends MULTI, the queued commands and EXEC to the master of the transaction's slot, or to the node WATCH was sent
+// to, and returns the reply to EXEC. Without WATCH, a transaction the node refused with MOVED before running it is sent
+// again to the new master
+func (s *routedSession) exec() redisPkg.Componenter {
+	defer s.endTransaction()
+	if s.tx.aborted {
+		return redisPkg.NewErrorFromString(execAbortMessage)
+	}
+	commands := make([]redisPkg.Componenter, 0, len(s.tx.queued)+2)
+	commands = append(commands, multiCommand)
+	commands = append(commands, s.tx.queued...)
+	commands = append(commands, execCommand)
+
+	if s.tx.pinned != nil {
+		reply, err := s.sendPinned(commands...)
+		if err != nil {
+			return s.r.backendError(err)
+		}
+		// EXEC forgets the watched keys, so the connection can go back to the pool
+		_ = s.tx.pinned.Close()
+		s.tx.pinned = nil
+		return reply
+	}
+	addr, ok := s.r.slotOwner(s.tx.slot)
+	if !ok {
+		return redisPkg.NewErrorFromString(slotNotServed)
+	}
+	buffer := s.r.buffers.Get()
+	if buffer == nil {
+		return redisPkg.NewErrorFromString("ERR the proxy ran out of buffers")
+	}
+	defer s.r.buffers.Put(buffer)
+	for redirects := 0; ; redirects++ {
+		replies, err := s.sendTransaction(addr, commands, buffer)
+		if err != nil {
+			return s.r.backendError(err)
+		}
+		target, moved := movedTransaction(replies)
+		if !moved || redirects == maxRedirects {
+			return replies[len(replies)-1]
+		}
+		s.r.metrics.Incr(metricRoutingRedirected)
+		s.r.RequestTopologyRefresh()
+		if len(target.Host) == 0 {
+			target.Host = addr.Host
+		}
+		addr = target
+	}
+}
+
+func (s *routedSession) sendTransaction(addr ip_map.HostWithPort, commands []redisPkg.Componenter, buffer []byte) (replies []redisPkg.Componenter, err error) {
+	conn, err := s.r.backends.Dial(addr.String())
+	if err != nil {
+		return
+	}
+	pooled := conn.(*pooledConnection)
+	if replies, err = s.r.exchange(pooled, commands, buffer, nil); err != nil {
+		return
+	}
+	_ = pooled.Close()
+	return
+}
+
+// movedTransaction returns where to send a transaction again, if the node refused a command because its slot had moved,
+// and so discarded the transaction. The replies are those to MULTI, each queued command and EXEC
+func movedTransaction(replies []redisPkg.Componenter) (addr ip_map.HostWithPort, ok bool) {
+	for _, reply := range replies[1 : len(replies)-1] {
+		if target, ask, redirected := redirection(reply); redirected {
+			return target, !ask
+		}
+	}
+	return
+}
+
+// endTransaction forgets the transaction and the watched keys, and unpins the session's connection
+func (s *routedSession) endTransaction() {
+	if s.tx.pinned != nil {
+		if reply, err := s.sendPinned(unwatchCommand); err == nil && !isError(reply) {
+			_ = s.tx.pinned.Close()
+		} else if s.tx.pinned != nil {
+			_ = s.tx.pinned.Discard()
+		}
+	}
+	s.tx = newRoutedTransaction()
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRoutedTransaction(t *testing.T) {
+	node := func(args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "SET":
+			return "+QUEUED\r\n"
+		case "GET":
+			return "$1\r\n1\r\n"
+		case "EXEC":
+			return "*1\r\n+OK\r\n"
+		}
+		return "+OK\r\n"
+	}
+	// the key "moved" has moved from the first node to the second, which discards the transaction it was sent in
+	refused := &atomic.Bool{}
+	movedFrom := func(args []string) string {
+		switch {
+		case len(args) > 1 && args[1] == "moved":
+			refused.Store(true)
+			return "-MOVED 12182 10.0.0.2:7000\r\n"
+		case strings.EqualFold(args[0], "EXEC") && refused.Swap(false):
+			return "-EXECABORT Transaction discarded because of previous errors.\r\n"
+		}
+		return node(args)
+	}
+	cluster := newFakeCluster(map[string]func(args []string) string{"10.0.0.1:7000": movedFrom, "10.0.0.2:7000": node})
+	r := newRoutingRedis(cluster)
+
+	cases := map[string]struct {
+		commands      [][]string
+		expectedReply string
+		expectedNode1 []string
+		expectedNode2 []string
+	}{
+		"sent at exec": {
+			commands:      [][]string{{"MULTI"}, {"SET", "bar", "1"}, {"SET", "{bar}x", "2"}, {"EXEC"}},
+			expectedReply: "*1\r\n+OK\r\n",
+			expectedNode1: []string{"MULTI", "SET bar 1", "SET {bar}x 2", "EXEC"},
+		},
+		"cross slot fails early": {
+			commands:      [][]string{{"MULTI"}, {"SET", "bar", "1"}, {"SET", "foo", "1"}},
+			expectedReply: "-CROSSSLOT Keys in request don't hash to the same slot\r\n",
+		},
+		"cross slot aborts exec": {
+			commands:      [][]string{{"MULTI"}, {"SET", "bar", "1"}, {"SET", "foo", "1"}, {"EXEC"}},
+			expectedReply: "-EXECABORT Transaction discarded because of previous errors.\r\n",
+		},
+		"discard": {
+			commands:      [][]string{{"MULTI"}, {"SET", "bar", "1"}, {"DISCARD"}, {"EXEC"}},
+			expectedReply: "-ERR EXEC without MULTI\r\n",
+		},
+		"watch pins the node": {
+			commands:      [][]string{{"WATCH", "bar"}, {"GET", "foo"}, {"MULTI"}, {"SET", "bar", "1"}, {"EXEC"}},
+			expectedReply: "*1\r\n+OK\r\n",
+			expectedNode1: []string{"WATCH bar", "MULTI", "SET bar 1", "EXEC"},
+			expectedNode2: []string{"GET foo"},
+		},
+		"watched slot": {
+			commands:      [][]string{{"WATCH", "bar"}, {"MULTI"}, {"SET", "foo", "1"}},
+			expectedReply: "-CROSSSLOT Keys in request don't hash to the same slot\r\n",
+			expectedNode1: []string{"WATCH bar"},
+		},
+		"unwatch": {
+			commands:      [][]string{{"WATCH", "bar"}, {"UNWATCH"}},
+			expectedReply: "+OK\r\n",
+			expectedNode1: []string{"WATCH bar", "UNWATCH"},
+		},
+		"moved is sent again": {
+			commands:      [][]string{{"MULTI"}, {"SET", "moved", "1"}, {"EXEC"}},
+			expectedReply: "*1\r\n+OK\r\n",
+			expectedNode1: []string{"MULTI", "SET moved 1", "EXEC"},
+			expectedNode2: []string{"MULTI", "SET moved 1", "EXEC"},
+		},
+	}
+
+	for caseName, c := range cases {
+		cluster.received = make(map[string][]string)
+		session := r.newRoutedSession(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+		reply := ""
+		for _, command := range c.commands {
+			reply = componentToString(t, session.handle(commandFromWords(command...), 0))
+		}
+		assert.Equal(t, c.expectedReply, reply, caseName)
+		assert.Equal(t, c.expectedNode1, cluster.commands("10.0.0.1:7000"), caseName)
+		assert.Equal(t, c.expectedNode2, cluster.commands("10.0.0.2:7000"), caseName)
+		session.close()
+	}
+}
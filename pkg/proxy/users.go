@@ -0,0 +1,400 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	redisPkg "redis_cluster_proxy/pkg/redis"
+	"strings"
+	"sync"
+)
+
+// User is a client identity the proxy authenticates itself, instead of passing AUTH through to the cluster
+type User struct {
+	Name string
+	// PasswordSHA256 is the hex encoded SHA-256 of the password
+	PasswordSHA256 string
+	// Backend are the credentials used with the cluster once a client authenticates as this user. Unset uses the proxy's credentials
+	Backend Credentials
+	// Commands limits the commands the user may run, on top of the proxy wide rules. nil allows every command
+	Commands *CommandRules
+	// KeyPatterns are globs every key the user touches must match, as in Redis ACL ~patterns. Empty allows every key
+	KeyPatterns []string
+	// KeyPrefix is the user's key namespace, instead of the proxy's. See SetKeyPrefix
+	KeyPrefix string
+}
+
+// Users is the table of users clients authenticate against. It is replaced, not changed, when the users are
+type Users struct {
+	byName map[string]*User
+}
+
+// Metric names for proxy users
+const (
+	metricACLAuthFailed    = "acl.auth_failed"
+	metricACLNoAuth        = "acl.noauth"
+	metricACLNoPermCommand = "acl.noperm_command"
+	metricACLNoPermKey     = "acl.noperm_key"
+)
+
+// NewUsers builds the user table. Names are case sensitive, as in Redis
+func NewUsers(users []User) (table *Users, err error) {
+	table = &Users{byName: make(map[string]*User, len(users))}
+	for i := range users {
+		user := users[i]
+		if _, err = hex.DecodeString(user.PasswordSHA256); err != nil || len(user.PasswordSHA256) != sha256.Size*2 {
+			return nil, fmt.Errorf("user '%s' password hash must be %d hex characters", user.Name, sha256.Size*2)
+		}
+		if _, exists := table.byName[user.Name]; exists {
+			return nil, fmt.Errorf("user '%s' is listed more than once", user.Name)
+		}
+		user.PasswordSHA256 = strings.ToLower(user.PasswordSHA256)
+		table.byName[user.Name] = &user
+	}
+	return
+}
+
+// HashPassword returns the hex encoded SHA-256 of password, as expected in User.PasswordSHA256
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsEmpty is true if there are no users, so clients AUTH with the cluster directly
+func (u *Users) IsEmpty() bool {
+	return u == nil || len(u.byName) == 0
+}
+
+// Authenticate returns the user if the password matches
+func (u *Users) Authenticate(name, password string) (user *User, ok bool) {
+	if u.IsEmpty() {
+		return
+	}
+	user, exists := u.byName[name]
+	hash := HashPassword(password)
+	if !exists {
+		// compare anyway so unknown users take as long as wrong passwords
+		subtle.ConstantTimeCompare([]byte(hash), []byte(hash))
+		return nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(user.PasswordSHA256)) != 1 {
+		return nil, false
+	}
+	return user, true
+}
+
+// SetUsers replaces the proxy users. nil, or an empty table, passes AUTH through to the cluster. Connections that are
+// already authenticated keep their user until they AUTH again
+func (r *Redis) SetUsers(users *Users) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+	r.settings.users = users
+}
+
+// Errors sent to clients, worded as Redis words them
+const (
+	noAuthMessage      = "NOAUTH Authentication required."
+	noAuthHelloMessage = "NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"
+	wrongPassMessage   = "WRONGPASS invalid username-password pair or user is disabled."
+	noPermKeyMessage   = "NOPERM No permissions to access a key"
+)
+
+// aclSession tracks who one client connection is authenticated as, and as whom its cluster connection is authenticated
+type aclSession struct {
+	r *Redis
+	// mu guards user and backend, which the side reading the cluster's replies sets, and logins
+	mu   sync.Mutex
+	user *User
+	// proxyBackend are the proxy's credentials, that the cluster connection was opened with
+	proxyBackend Credentials
+	// backend are the credentials the cluster connection is currently authenticated with
+	backend Credentials
+	// pooled is set for clients of the routing endpoint, whose backend connections are shared and stay logged in with
+	// the proxy's credentials
+	pooled bool
+	// next is the login carried by the command admit let through. It is queued when the command is forwarded
+	next *pendingLogin
+	// logins follow the AUTH and HELLO commands sent to the cluster and not answered yet, in order
+	logins []*pendingLogin
+	// closed is closed when the client leaves, so that admit stops waiting for the cluster
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// pendingLogin is a login the cluster must accept before the client is logged in. A nil user logs in no one
+type pendingLogin struct {
+	user    *User
+	backend Credentials
+	// switching is set if the command logs the cluster connection in with backend
+	switching bool
+	// done is closed once the cluster answered
+	done chan struct{}
+}
+
+// newACLSession starts following one client connection. Its admit authenticates the client against the proxy users, and
+// checks the client's permissions
+func (r *Redis) newACLSession() *aclSession {
+	credentials := r.liveSettings().credentials
+	return &aclSession{r: r, proxyBackend: credentials, backend: credentials, closed: make(chan struct{}), closeOnce: &sync.Once{}}
+}
+
+// userName is the name of the proxy user the client is logged in as, if any
+func (s *aclSession) userName() (name string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.user == nil {
+		return
+	}
+	return s.user.Name, true
+}
+
+// forwarded queues the login of an AUTH or HELLO on its way to the cluster, to be settled by replied
+func (s *aclSession) forwarded(command redisPkg.Componenter) {
+	login := s.next
+	s.next = nil
+	if name, _ := commandName(command); name != "auth" && name != "hello" {
+		return
+	}
+	if login == nil {
+		login = &pendingLogin{done: make(chan struct{})}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logins = append(s.logins, login)
+}
+
+// replied logs the client in once the cluster accepted the AUTH or HELLO that carried the login. It returns an error, for the
+// connection to be closed, if the cluster refused to switch to the user's backend credentials
+func (s *aclSession) replied(command, reply redisPkg.Componenter) error {
+	if name, _ := commandName(command); name != "auth" && name != "hello" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.logins) == 0 {
+		return nil
+	}
+	login := s.logins[0]
+	s.logins = s.logins[1:]
+	defer close(login.done)
+	if login.user == nil {
+		return nil
+	}
+	if isError(reply) {
+		s.r.metrics.Incr(metricACLAuthFailed)
+		if login.switching {
+			return fmt.Errorf("the cluster refused the backend credentials of user '%s': %s", login.user.Name, reply.(*redisPkg.ErrorComp).String())
+		}
+		return nil
+	}
+	s.user = login.user
+	if login.switching {
+		s.backend = login.backend
+	}
+	return nil
+}
+
+// close stops admit waiting for logins the cluster will not answer, once the client leaves
+func (s *aclSession) close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// settle waits until the cluster answered every login sent so far, so that the commands pipelined behind one are checked
+// as the user it logs in, or not, as Redis would. It returns false if the client left first
+func (s *aclSession) settle() bool {
+	s.mu.Lock()
+	var last *pendingLogin
+	for _, login := range s.logins {
+		if login.user != nil {
+			last = login
+		}
+	}
+	s.mu.Unlock()
+	if last == nil {
+		return true
+	}
+	select {
+	case <-last.done:
+		return true
+	case <-s.closed:
+		return false
+	}
+}
+
+func (s *aclSession) admit(command redisPkg.Componenter, _ int) (forward, reply redisPkg.Componenter) {
+	s.next = nil
+	if !s.settle() {
+		return nil, redisPkg.NewErrorFromString("ERR the connection is closing")
+	}
+	users := s.r.liveSettings().users
+	if users.IsEmpty() {
+		return command, nil
+	}
+	args, ok := commandArgs(command)
+	if !ok || len(args) == 0 {
+		if s.user == nil {
+			s.r.metrics.Incr(metricACLNoAuth)
+			return nil, redisPkg.NewErrorFromString(noAuthMessage)
+		}
+		return command, nil
+	}
+	name := strings.ToLower(args[0])
+	switch name {
+	case "auth":
+		return s.auth(users, args)
+	case "hello":
+		return s.hello(users, args, command)
+	case "quit":
+		return command, nil
+	}
+	if s.user == nil {
+		s.r.metrics.Incr(metricACLNoAuth)
+		return nil, redisPkg.NewErrorFromString(noAuthMessage)
+	}
+	if name == "reset" {
+		// RESET would log the cluster connection out of the backend user
+		s.r.metrics.Incr(metricACLNoPermCommand)
+		return nil, s.noPermCommand(command)
+	}
+	if _, allowed := s.user.Commands.Check(command); !allowed {
+		s.r.metrics.Incr(metricACLNoPermCommand)
+		return nil, s.noPermCommand(command)
+	}
+	if len(s.user.KeyPatterns) != 0 {
+		spec, known := s.r.commandTable().Lookup(args)
+		if !known {
+			s.r.metrics.Incr(metricACLNoPermCommand)
+			return nil, s.noPermCommand(command)
+		}
+		for _, key := range spec.Keys(args) {
+			if !s.user.mayAccess(key) {
+				s.r.metrics.Incr(metricACLNoPermKey)
+				return nil, redisPkg.NewErrorFromString(noPermKeyMessage)
+			}
+		}
+	}
+	return command, nil
+}
+
+// auth handles AUTH [username] password
+func (s *aclSession) auth(users *Users, args []string) (forward, reply redisPkg.Componenter) {
+	var name, password string
+	switch len(args) {
+	case 2:
+		name, password = defaultUser, args[1]
+	case 3:
+		name, password = args[1], args[2]
+	default:
+		return nil, redisPkg.NewErrorFromString("ERR wrong number of arguments for 'auth' command")
+	}
+	user, ok := users.Authenticate(name, password)
+	if !ok {
+		s.r.metrics.Incr(metricACLAuthFailed)
+		return nil, redisPkg.NewErrorFromString(wrongPassMessage)
+	}
+	backend, switching, rejection := s.switchBackend(user)
+	if rejection != nil {
+		return nil, rejection
+	}
+	if !switching {
+		s.login(user)
+		return nil, redisPkg.NewSimpleStringFromString("OK")
+	}
+	// the cluster's reply to the backend AUTH becomes the client's reply, and logs the client in if it is +OK
+	s.next = &pendingLogin{user: user, backend: backend, switching: true, done: make(chan struct{})}
+	return backend.authCommand(), nil
+}
+
+// login logs the client in as user right away, as the cluster connection stays logged in as it is
+func (s *aclSession) login(user *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// hello handles HELLO [protover [AUTH username password] [SETNAME clientname]]. The AUTH option is checked by the proxy and
+// replaced by the user's backend credentials, so the cluster sees the protocol switch and the login together
+func (s *aclSession) hello(users *Users, args []string, command redisPkg.Componenter) (forward, reply redisPkg.Componenter) {
+	authIndex := -1
+	for i := 2; i+2 < len(args); i++ {
+		if strings.EqualFold(args[i], "AUTH") {
+			authIndex = i
+			break
+		}
+	}
+	if authIndex < 0 {
+		if s.user == nil {
+			s.r.metrics.Incr(metricACLNoAuth)
+			return nil, redisPkg.NewErrorFromString(noAuthHelloMessage)
+		}
+		return command, nil
+	}
+	user, ok := users.Authenticate(args[authIndex+1], args[authIndex+2])
+	if !ok {
+		s.r.metrics.Incr(metricACLAuthFailed)
+		return nil, redisPkg.NewErrorFromString(wrongPassMessage)
+	}
+	backend, switching, rejection := s.switchBackend(user)
+	if rejection != nil {
+		return nil, rejection
+	}
+	// the cluster may still refuse HELLO, such as for its protocol version, which leaves the client logged out
+	s.next = &pendingLogin{user: user, backend: backend, switching: switching, done: make(chan struct{})}
+	rewritten := redisPkg.Array{}
+	for i, arg := range args {
+		if i == authIndex {
+			if switching {
+				username := backend.Username
+				if len(username) == 0 {
+					username = defaultUser
+				}
+				rewritten = append(rewritten, redisPkg.NewBulkStringFromString("AUTH"), redisPkg.NewBulkStringFromString(username), redisPkg.NewBulkStringFromString(backend.Password))
+			}
+			continue
+		}
+		if i == authIndex+1 || i == authIndex+2 {
+			continue
+		}
+		rewritten = append(rewritten, redisPkg.NewBulkStringFromString(arg))
+	}
+	return &rewritten, nil
+}
+
+// switchBackend returns the credentials the cluster connection must AUTH with for user, and whether they differ from the ones
+// in use. A connection cannot be logged out of a backend user, so switching to a user without backend credentials, after
+// another user's, is rejected. So is any switch on the shared connections of the routing endpoint
+func (s *aclSession) switchBackend(user *User) (backend Credentials, switching bool, rejection redisPkg.Componenter) {
+	backend = user.Backend
+	if !backend.IsSet() {
+		backend = s.proxyBackend
+	}
+	if backend == s.backend {
+		return backend, false, nil
+	}
+	if s.pooled {
+		return backend, false, redisPkg.NewErrorFromString(fmt.Sprintf("ERR user '%s' has its own backend credentials, which the routing endpoint cannot use, connect to the node ports instead", user.Name))
+	}
+	if !backend.IsSet() {
+		return backend, false, redisPkg.NewErrorFromString(fmt.Sprintf("ERR reconnect to authenticate as '%s', this connection is already logged in to the cluster as another user", user.Name))
+	}
+	return backend, true, nil
+}
+
+func (s *aclSession) noPermCommand(command redisPkg.Componenter) redisPkg.Componenter {
+	name, subcommand := commandName(command)
+	if len(subcommand) != 0 && s.r.commandTable().Has(name+"|"+subcommand) {
+		name += "|" + subcommand
+	}
+	return redisPkg.NewErrorFromString(fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", s.user.Name, name))
+}
+
+// mayAccess is true if key matches one of the user's key patterns
+func (u *User) mayAccess(key string) bool {
+	for _, pattern := range u.KeyPatterns {
+		if redisPkg.MatchGlob(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"redis_cluster_proxy/pkg/ip_map"
+	"redis_cluster_proxy/pkg/port_pool"
+	"redis_cluster_proxy/pkg/redis"
+	"testing"
+	"time"
+)
+
+func TestACLAdmitter(t *testing.T) {
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	r.SetCredentials(Credentials{Username: "proxy", Password: "proxy-secret"})
+	noConfig, err := NewCommandRules(nil, []string{"CONFIG"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := NewUsers([]User{
+		{Name: "orders", PasswordSHA256: HashPassword("orders-secret"), Backend: Credentials{Username: "team-orders", Password: "backend-secret"}, Commands: noConfig, KeyPatterns: []string{"orders:*"}},
+		{Name: "ops", PasswordSHA256: HashPassword("ops-secret")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetUsers(users)
+	session := r.newACLSession()
+
+	steps := []struct {
+		command         redis.Componenter
+		expectedForward string
+		expectedReply   string
+	}{
+		{
+			command:       commandFromWords("GET", "orders:1"),
+			expectedReply: "-NOAUTH Authentication required.\r\n",
+		},
+		{
+			command:       commandFromWords("AUTH", "orders", "wrong"),
+			expectedReply: "-WRONGPASS invalid username-password pair or user is disabled.\r\n",
+		},
+		{
+			command:         commandFromWords("AUTH", "orders", "orders-secret"),
+			expectedForward: "*3\r\n$4\r\nAUTH\r\n$11\r\nteam-orders\r\n$14\r\nbackend-secret\r\n",
+		},
+		{
+			command:         commandFromWords("GET", "orders:1"),
+			expectedForward: "*2\r\n$3\r\nGET\r\n$8\r\norders:1\r\n",
+		},
+		{
+			command:       commandFromWords("MGET", "orders:1", "users:1"),
+			expectedReply: "-NOPERM No permissions to access a key\r\n",
+		},
+		{
+			command:       commandFromWords("CONFIG", "GET", "maxmemory"),
+			expectedReply: "-NOPERM User orders has no permissions to run the 'config|get' command\r\n",
+		},
+		{
+			command:         commandFromWords("AUTH", "ops", "ops-secret"),
+			expectedForward: "*3\r\n$4\r\nAUTH\r\n$5\r\nproxy\r\n$12\r\nproxy-secret\r\n",
+		},
+		{
+			command:         commandFromWords("HELLO", "3", "AUTH", "orders", "orders-secret", "SETNAME", "app"),
+			expectedForward: "*7\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$4\r\nAUTH\r\n$11\r\nteam-orders\r\n$14\r\nbackend-secret\r\n$7\r\nSETNAME\r\n$3\r\napp\r\n",
+		},
+		{
+			command:       commandFromWords("AUTH", "orders", "orders-secret"),
+			expectedReply: "+OK\r\n",
+		},
+	}
+	for i, step := range steps {
+		forward, reply, _ := acceptedByCluster(session, step.command)
+		if len(step.expectedReply) != 0 {
+			assert.Nil(t, forward, "step %d", i)
+			assert.Equal(t, step.expectedReply, componentToString(t, reply), "step %d", i)
+		} else {
+			assert.Nil(t, reply, "step %d", i)
+			assert.Equal(t, step.expectedForward, componentToString(t, forward), "step %d", i)
+		}
+	}
+	assert.Equal(t, uint64(1), r.metrics.Get(metricACLAuthFailed))
+	assert.Equal(t, uint64(1), r.metrics.Get(metricACLNoPermKey))
+
+	opsSession := r.newACLSession()
+	forward, reply, _ := acceptedByCluster(opsSession, commandFromWords("HELLO", "3", "AUTH", "ops", "ops-secret"))
+	assert.Nil(t, reply)
+	assert.Equal(t, "*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n", componentToString(t, forward), "users without backend credentials keep the proxy's")
+	forward, reply = opsSession.admit(commandFromWords("FLUSHALL"), 0)
+	assert.Nil(t, reply)
+	assert.NotNil(t, forward)
+
+	r.SetCredentials(Credentials{})
+	noProxyCredentials := r.newACLSession()
+	_, reply, _ = acceptedByCluster(noProxyCredentials, commandFromWords("AUTH", "orders", "orders-secret"))
+	assert.Nil(t, reply)
+	_, reply = noProxyCredentials.admit(commandFromWords("AUTH", "ops", "ops-secret"), 0)
+	assert.Equal(t, "-ERR reconnect to authenticate as 'ops', this connection is already logged in to the cluster as another user\r\n", componentToString(t, reply))
+
+	r.SetUsers(nil)
+	forward, _ = r.newACLSession().admit(commandFromWords("AUTH", "anyone", "anything"), 0)
+	assert.Equal(t, "*3\r\n$4\r\nAUTH\r\n$6\r\nanyone\r\n$8\r\nanything\r\n", componentToString(t, forward), "without users AUTH passes through")
+
+	_, err = NewUsers([]User{{Name: "bad", PasswordSHA256: "not-a-hash"}})
+	assert.Error(t, err)
+}
+
+// acceptedByCluster runs command through session as a node port would, with the cluster answering +OK to whatever is forwarded
+func acceptedByCluster(session *aclSession, command redis.Componenter) (forward, reply redis.Componenter, err error) {
+	forward, reply = session.admit(command, 0)
+	if forward != nil {
+		session.forwarded(forward)
+		err = session.replied(forward, redis.NewSimpleStringFromString("OK"))
+	}
+	return
+}
+
+func TestACLLoginWaitsForCluster(t *testing.T) {
+	r := NewRedis(ip_map.HostWithPort{Host: "127.0.0.1"}, ip_map.HostWithPort{}, "test", port_pool.NewCounter(8000), 2, 1, BufferSizeBytes)
+	users, err := NewUsers([]User{
+		{Name: "orders", PasswordSHA256: HashPassword("orders-secret"), Backend: Credentials{Username: "team-orders", Password: "backend-secret"}},
+		{Name: "ops", PasswordSHA256: HashPassword("ops-secret")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetUsers(users)
+	session := r.newACLSession()
+
+	forward, _ := session.admit(commandFromWords("AUTH", "orders", "orders-secret"), 0)
+	session.forwarded(forward)
+	pipelined := make(chan redis.Componenter)
+	go func() {
+		_, reply := session.admit(commandFromWords("GET", "orders:1"), 0)
+		pipelined <- reply
+	}()
+	select {
+	case <-pipelined:
+		t.Fatal("the command behind AUTH was admitted before the cluster answered")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Error(t, session.replied(forward, redis.NewErrorFromString(wrongPassMessage)), "refused backend credentials close the connection")
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", componentToString(t, <-pipelined))
+	_, loggedIn := session.userName()
+	assert.False(t, loggedIn)
+
+	forward, _ = session.admit(commandFromWords("HELLO", "4", "AUTH", "ops", "ops-secret"), 0)
+	session.forwarded(forward)
+	assert.NoError(t, session.replied(forward, redis.NewErrorFromString("NOPROTO unsupported protocol version")))
+	_, loggedIn = session.userName()
+	assert.False(t, loggedIn, "a refused HELLO logs no one in")
+
+	forward, _ = session.admit(commandFromWords("AUTH", "orders", "orders-secret"), 0)
+	session.forwarded(forward)
+	go func() {
+		_, reply := session.admit(commandFromWords("GET", "orders:1"), 0)
+		pipelined <- reply
+	}()
+	session.close()
+	assert.Equal(t, "-ERR the connection is closing\r\n", componentToString(t, <-pipelined), "a client that left stops waiting")
+}
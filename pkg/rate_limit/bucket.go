@@ -0,0 +1,108 @@
+package rate_limit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket. It holds up to burst tokens and gains rate tokens every second. Safe to use from many goroutines
+type Bucket struct {
+	mu     *sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewBucket creates a full bucket
+func NewBucket(rate, burst float64) *Bucket {
+	return newBucketWithClock(rate, burst, time.Now)
+}
+
+func newBucketWithClock(rate, burst float64, now func() time.Time) *Bucket {
+	return &Bucket{
+		mu:     &sync.Mutex{},
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now(),
+		now:    now,
+	}
+}
+
+// refill adds the tokens earned since the last call. Must be called with mu held
+func (b *Bucket) refill() {
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// CanTake is true if Take(n) would succeed right now. A full bucket can always be taken from, so that requests larger than
+// the burst are not rejected forever
+func (b *Bucket) CanTake(n float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	return b.tokens >= n || b.tokens >= b.burst
+}
+
+// Take removes n tokens if they are available. Returns false, taking nothing, if they are not
+func (b *Bucket) Take(n float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < n && b.tokens < b.burst {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// TakeAll takes amounts[i] tokens from each of buckets[i], or none at all. Tokens taken before a bucket comes up short are
+// given back, so no caller is let through on a bucket another caller emptied in between
+func TakeAll(buckets []*Bucket, amounts []float64) bool {
+	for i, bucket := range buckets {
+		if !bucket.Take(amounts[i]) {
+			for j := 0; j < i; j++ {
+				buckets[j].giveBack(amounts[j])
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// giveBack returns n tokens that Take removed
+func (b *Bucket) giveBack(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Reserve removes n tokens, going into debt if there are not enough, and returns how long the caller must wait before the
+// tokens are earned
+func (b *Bucket) Reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// isFull is true if the bucket is back to its burst, which makes it no different from a new bucket
+func (b *Bucket) isFull() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	return b.tokens >= b.burst
+}
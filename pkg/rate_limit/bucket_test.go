@@ -0,0 +1,65 @@
+package rate_limit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestBucketTake(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	bucket := newBucketWithClock(10, 2, clock.Now)
+
+	assert.True(t, bucket.Take(1))
+	assert.True(t, bucket.Take(1))
+	assert.False(t, bucket.Take(1), "burst used up")
+	clock.now = clock.now.Add(100 * time.Millisecond)
+	assert.True(t, bucket.Take(1), "one token earned")
+	assert.False(t, bucket.Take(1))
+
+	clock.now = clock.now.Add(time.Second)
+	assert.True(t, bucket.Take(50), "a full bucket admits a request larger than the burst")
+	assert.False(t, bucket.CanTake(1))
+}
+
+func TestTakeAll(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	plenty := newBucketWithClock(10, 5, clock.Now)
+	scarce := newBucketWithClock(10, 1, clock.Now)
+
+	assert.True(t, TakeAll([]*Bucket{plenty, scarce}, []float64{1, 1}))
+	assert.False(t, TakeAll([]*Bucket{plenty, scarce}, []float64{1, 1}), "scarce is empty")
+	assert.True(t, plenty.Take(4), "tokens taken from plenty were given back")
+}
+
+func TestBucketReserve(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	bucket := newBucketWithClock(10, 1, clock.Now)
+
+	assert.Equal(t, time.Duration(0), bucket.Reserve(1))
+	assert.Equal(t, 100*time.Millisecond, bucket.Reserve(1))
+	assert.Equal(t, 200*time.Millisecond, bucket.Reserve(1))
+}
+
+func TestKeyedSweep(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	keyed := NewKeyed(1, 1)
+	keyed.now = clock.Now
+	keyed.lastSweep = clock.now
+
+	assert.True(t, keyed.For("10.0.0.1").Take(1))
+	keyed.For("10.0.0.2")
+	assert.Equal(t, 2, keyed.Len())
+
+	clock.now = clock.now.Add(sweepInterval)
+	keyed.For("10.0.0.3")
+	assert.Equal(t, 1, keyed.Len(), "refilled buckets are forgotten")
+}
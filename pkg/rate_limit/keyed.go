@@ -0,0 +1,64 @@
+package rate_limit
+
+import (
+	"sync"
+	"time"
+)
+
+// Keyed hands out one Bucket per key, such as a client IP, all with the same rate and burst. Buckets that have refilled are
+// forgotten, as they are no different from a new bucket, so keys that stop sending do not use memory forever
+type Keyed struct {
+	mu        *sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// sweepInterval is how often Keyed looks for buckets to forget
+const sweepInterval = time.Minute
+
+// NewKeyed creates buckets that gain rate tokens a second, up to burst
+func NewKeyed(rate, burst float64) *Keyed {
+	return &Keyed{
+		mu:        &sync.Mutex{},
+		rate:      rate,
+		burst:     burst,
+		buckets:   make(map[string]*Bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// For returns the bucket for key, creating a full one if there is none
+func (k *Keyed) For(key string) *Bucket {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if now := k.now(); now.Sub(k.lastSweep) >= sweepInterval {
+		k.sweep()
+		k.lastSweep = now
+	}
+	bucket, ok := k.buckets[key]
+	if !ok {
+		bucket = newBucketWithClock(k.rate, k.burst, k.now)
+		k.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// Len is the number of buckets in use
+func (k *Keyed) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.buckets)
+}
+
+// sweep forgets the buckets that are full. Must be called with mu held
+func (k *Keyed) sweep() {
+	for key, bucket := range k.buckets {
+		if bucket.isFull() {
+			delete(k.buckets, key)
+		}
+	}
+}
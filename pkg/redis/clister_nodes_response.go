@@ -19,6 +19,28 @@ type ClusterNodeResp struct {
 	configEpic   uint64
 	linkState    string
 	slots        []string
+	// endpointFields are the comma separated fields Redis 7 and newer write after the cluster port: the hostname, then auxiliary fields.
+	// nil for older versions
+	endpointFields []string
+}
+
+// Hostname is the announced hostname of the node. ok is false if the node record does not have a hostname field, such as before Redis 7
+func (c *ClusterNodeResp) Hostname() (hostname string, ok bool) {
+	if len(c.endpointFields) == 0 {
+		return "", false
+	}
+	return c.endpointFields[0], true
+}
+
+// SetHostname replaces the announced hostname. Records from before Redis 7 do not have the field and are left alone
+func (c *ClusterNodeResp) SetHostname(hostname string) {
+	if len(c.endpointFields) == 0 {
+		return
+	}
+	endpointFields := make([]string, len(c.endpointFields))
+	copy(endpointFields, c.endpointFields)
+	endpointFields[0] = hostname
+	c.endpointFields = endpointFields
 }
 
 func (c *ClusterNodeResp) Slots() []string {
@@ -116,25 +138,29 @@ func NewClusterNodeRespFromStringRecord(record string) (node ClusterNodeResp, er
 		return node, fmt.Errorf("CLUSTER NODE record had insufficient columns; record: '%s'", record)
 	}
 	node.id = columns[0]
-	ipParts := strings.Split(columns[1], ":")
-	if len(ipParts) != 2 {
-		return node, fmt.Errorf("CLUSTER NODE ip was missing colon for port")
-	}
-	node.ip = ipParts[0]
-
-	portParts := strings.Split(ipParts[1], "@")
-	if len(portParts) != 2 {
+	// ip:port@cport[,hostname[,aux=value...]], where an IPv6 ip is not bracketed, so the port is after the last colon
+	atIndex := strings.Index(columns[1], "@")
+	if atIndex < 0 {
 		return node, fmt.Errorf("CLUSTER NODE ip was missing at (@) for port and client port")
 	}
+	colonIndex := strings.LastIndex(columns[1][:atIndex], ":")
+	if colonIndex < 0 {
+		return node, fmt.Errorf("CLUSTER NODE ip was missing colon for port")
+	}
+	node.ip = strings.TrimSuffix(strings.TrimPrefix(columns[1][:colonIndex], "["), "]")
 
 	var nodePortTmp uint64
-	nodePortTmp, err = strconv.ParseUint(portParts[0], 10, 16)
+	nodePortTmp, err = strconv.ParseUint(columns[1][colonIndex+1:atIndex], 10, 16)
 	if err != nil {
 		return
 	}
 	node.port = uint16(nodePortTmp)
 
-	nodePortTmp, err = strconv.ParseUint(portParts[1], 10, 16)
+	cportAndEndpointFields := strings.Split(columns[1][atIndex+1:], ",")
+	if len(cportAndEndpointFields) > 1 {
+		node.endpointFields = cportAndEndpointFields[1:]
+	}
+	nodePortTmp, err = strconv.ParseUint(cportAndEndpointFields[0], 10, 16)
 	if err != nil {
 		return
 	}
@@ -162,9 +188,15 @@ func NewClusterNodeRespFromStringRecord(record string) (node ClusterNodeResp, er
 
 func (c ClusterNodeResp) Record() string {
 	parts := make([]string, 0, 1+len(c.Slots()))
+	endpoint := fmt.Sprint(c.Ip(), ":", c.Port(), "@", c.Cport())
+	if len(c.endpointFields) != 0 {
+		endpoint += "," + strings.Join(c.endpointFields, ",")
+	}
 	parts = append(parts, fmt.Sprint(
 		c.Id(), " ",
-		c.Ip(), ":", c.Port(), "@", c.Cport(), " ",
+		endpoint, " ",
+		c.Flags(), " ",
+		c.Master(), " ",
 		c.PingSent(), " ",
 		c.PongReceived(), " ",
 		c.ConfigEpic(), " ",
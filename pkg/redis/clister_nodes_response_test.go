@@ -0,0 +1,54 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClusterNodeRecord(t *testing.T) {
+	cases := map[string]struct {
+		input string
+		ip    string
+		port  uint16
+		cport uint16
+	}{
+		"ipv4": {
+			input: "901e06d850fe7a21253fbb200b5bdd55d3286848 172.22.0.2:7000@17000 myself,master - 0 1580000000000 1 connected 0-5460",
+			ip:    "172.22.0.2",
+			port:  7000,
+			cport: 17000,
+		},
+		"redis 7 hostname": {
+			input: "543675033db89351b9e054ce0eef39294e282c4f 172.22.0.2:7001@17001,redis-1.internal,shard-id=4f1a master - 0 1580000000000 2 connected 5461-10922",
+			ip:    "172.22.0.2",
+			port:  7001,
+			cport: 17001,
+		},
+		"ipv6": {
+			input: "d39334b20e1f05b1cadcf6858a907360cbae58d9 fd00::2:7005@17005 slave 901e06d850fe7a21253fbb200b5bdd55d3286848 0 1580000000000 1 connected",
+			ip:    "fd00::2",
+			port:  7005,
+			cport: 17005,
+		},
+	}
+	for caseName, c := range cases {
+		node, err := NewClusterNodeRespFromStringRecord(c.input)
+		assert.NoError(t, err, caseName)
+		assert.Equal(t, c.ip, node.Ip(), caseName)
+		assert.Equal(t, c.port, node.Port(), caseName)
+		assert.Equal(t, c.cport, node.Cport(), caseName)
+		assert.Equal(t, c.input, node.Record(), caseName)
+	}
+}
+
+func TestClusterNodeSetHostname(t *testing.T) {
+	node, err := NewClusterNodeRespFromStringRecord("543675033db89351b9e054ce0eef39294e282c4f 172.22.0.2:7001@17001,redis-1.internal,shard-id=4f1a master - 0 1580000000000 2 connected 5461-10922")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, ok := node.Hostname()
+	assert.True(t, ok)
+	assert.Equal(t, "redis-1.internal", hostname)
+	node.SetHostname("proxy.example.com")
+	assert.Equal(t, "543675033db89351b9e054ce0eef39294e282c4f 172.22.0.2:7001@17001,proxy.example.com,shard-id=4f1a master - 0 1580000000000 2 connected 5461-10922", node.Record())
+}
@@ -4,10 +4,16 @@ import (
 	"fmt"
 )
 
+// MetadataHostname is the key of the hostname in the metadata Redis 7 sends for each server in CLUSTER SLOTS
+const MetadataHostname = "hostname"
+
 type ClusterServerResp struct {
 	ip   string
 	port uint16
 	id   string
+	// metadata is the 4th element Redis 7 and newer send for each server: a flat list of key, value pairs such as hostname.
+	// nil when the cluster sent only 3 elements
+	metadata *Array
 }
 
 func NewClusterServerResp(ip string, port uint16, id string) ClusterServerResp {
@@ -19,11 +25,17 @@ func NewClusterServerResp(ip string, port uint16, id string) ClusterServerResp {
 }
 
 func NewClusterServerRespFromClusterServerResp(resp ClusterServerResp) ClusterServerResp {
-	return ClusterServerResp{
+	ret := ClusterServerResp{
 		ip:   resp.ip,
 		port: resp.port,
 		id:   resp.id,
 	}
+	if resp.metadata != nil {
+		metadata := make(Array, len(*resp.metadata))
+		copy(metadata, *resp.metadata)
+		ret.metadata = &metadata
+	}
+	return ret
 }
 
 func (c ClusterServerResp) Ip() string {
@@ -39,24 +51,61 @@ func (c *ClusterServerResp) SetPort(v uint16) {
 	c.port = v
 }
 func (c ClusterServerResp) Id() string {
-	return c.ip
+	return c.id
+}
+
+// HasMetadata is true if the cluster sent the Redis 7 metadata element for this server
+func (c ClusterServerResp) HasMetadata() bool {
+	return c.metadata != nil
+}
+
+// Metadata returns the value for key from the Redis 7 metadata, if present
+func (c ClusterServerResp) Metadata(key string) (value string, ok bool) {
+	if c.metadata == nil {
+		return "", false
+	}
+	for i := 0; i+1 < len(*c.metadata); i += 2 {
+		if name, isString := (*c.metadata)[i].(*BulkString); isString && name.String() == key {
+			if v, isString := (*c.metadata)[i+1].(*BulkString); isString {
+				return v.String(), true
+			}
+		}
+	}
+	return "", false
+}
+
+// SetMetadata replaces the value for key in the metadata, adding the key if it is missing. The metadata element is created if the server did not have one
+func (c *ClusterServerResp) SetMetadata(key, value string) {
+	if c.metadata == nil {
+		c.metadata = &Array{}
+	}
+	for i := 0; i+1 < len(*c.metadata); i += 2 {
+		if name, isString := (*c.metadata)[i].(*BulkString); isString && name.String() == key {
+			(*c.metadata)[i+1] = NewBulkStringFromString(value)
+			return
+		}
+	}
+	*c.metadata = append(*c.metadata, NewBulkStringFromString(key), NewBulkStringFromString(value))
 }
 
 func ClusterServerRespToComponent(c ClusterServerResp) Componenter {
-	component := make(Array, 3)
+	component := make(Array, 3, 4)
 	component[0] = NewBulkStringFromString(c.ip)
 	component[1] = NewIntFromInt(int(c.port))
 	component[2] = NewBulkStringFromString(c.id)
+	if c.metadata != nil {
+		component = append(component, c.metadata)
+	}
 	return &component
 }
 
 func deserializeClusterServer(component Componenter) (server ClusterServerResp, err error) {
-	// Skipping the *3, all server records have 3 items: IP, port, and id
+	// Skipping the *3, all server records have 3 items: IP, port, and id. Redis 7 and newer add a 4th: a map of metadata such as the hostname
 	if componentArray, ok := component.(*Array); !ok {
 		return server, fmt.Errorf("expected server object to contain a Array, but got: %v", component)
 	} else {
-		if len(*componentArray) != 3 {
-			return server, fmt.Errorf("expected server object to contain a Array of length exactly 3, but got: %d", len(*componentArray))
+		if len(*componentArray) < 3 {
+			return server, fmt.Errorf("expected server object to contain a Array of length at least 3, but got: %d", len(*componentArray))
 		}
 
 		if serverIp, ok := (*componentArray)[0].(*BulkString); !ok {
@@ -76,6 +125,14 @@ func deserializeClusterServer(component Componenter) (server ClusterServerResp,
 		} else {
 			server.id = serverId.String()
 		}
+
+		if len(*componentArray) > 3 {
+			if metadata, ok := (*componentArray)[3].(*Array); !ok {
+				return server, fmt.Errorf("expected server metadata field to be an array, but got %v", (*componentArray)[3])
+			} else {
+				server.metadata = metadata
+			}
+		}
 	}
 	return
 }
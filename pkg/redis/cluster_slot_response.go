@@ -75,6 +75,9 @@ func NewSlotArrayFromComponent(component Componenter) (servers []ClusterSlotResp
 		servers = make([]ClusterSlotResp, len(*componentArray))
 		for serverIndex, component := range *componentArray {
 			servers[serverIndex], err = NewSlotFromComponent(component)
+			if err != nil {
+				return nil, err
+			}
 		}
 		return servers, nil
 	}
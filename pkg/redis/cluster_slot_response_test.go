@@ -78,3 +78,43 @@ func init() {
 		}, "\r\n",
 	) + "\r\n"
 }
+
+func TestRedis7ServerMetadata(t *testing.T) {
+	input := strings.Join([]string{
+		"*1",
+		"*3",
+		":0",
+		":16383",
+		"*4",
+		"$10",
+		"172.22.0.2",
+		":7000",
+		"$40",
+		"901e06d850fe7a21253fbb200b5bdd55d3286848",
+		"*2",
+		"$8",
+		"hostname",
+		"$16",
+		"redis-0.internal",
+	}, "\r\n") + "\r\n"
+	decoded, _, err := ComponentFromReader(bytes.NewBufferString(input), make([]byte, BufferSizeBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slots, err := NewSlotArrayFromComponent(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := slots[0].Servers()[0]
+	hostname, ok := server.Metadata(MetadataHostname)
+	assert.True(t, ok)
+	assert.Equal(t, "redis-0.internal", hostname)
+
+	// the metadata is preserved when the response is written back out
+	actualOutput := bytes.Buffer{}
+	_, err = ComponentToStream(&actualOutput, ClusterSlotArrayRespToComponent(slots))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, input, actualOutput.String())
+}
@@ -0,0 +1,32 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		s        string
+		expected bool
+	}{
+		{pattern: "*", s: "", expected: true},
+		{pattern: "team-a:*", s: "team-a:user:1", expected: true},
+		{pattern: "team-a:*", s: "team-b:user:1", expected: false},
+		{pattern: "h?llo", s: "hello", expected: true},
+		{pattern: "h?llo", s: "hllo", expected: false},
+		{pattern: "h[ae]llo", s: "hallo", expected: true},
+		{pattern: "h[^e]llo", s: "hello", expected: false},
+		{pattern: "h[a-b]llo", s: "hbllo", expected: true},
+		{pattern: "h\\*llo", s: "h*llo", expected: true},
+		{pattern: "h\\*llo", s: "hello", expected: false},
+		{pattern: "*:{*}", s: "session:{42}", expected: true},
+		{pattern: "a*b*c", s: "axxbyyc", expected: true},
+		{pattern: "a*b*c", s: "axxbyy", expected: false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, MatchGlob(c.pattern, c.s), c.pattern+" "+c.s)
+	}
+}
@@ -0,0 +1,46 @@
+package redis
+
+// SlotCount is the number of hash slots in a Redis Cluster
+const SlotCount = 16384
+
+// HashTag returns the part of key that Redis Cluster hashes: the text between the first '{' and the next '}', if it is not
+// empty. Otherwise the whole key is hashed and tagged is false
+func HashTag(key string) (tag string, tagged bool) {
+	for open := 0; open < len(key); open++ {
+		if key[open] != '{' {
+			continue
+		}
+		for end := open + 1; end < len(key); end++ {
+			if key[end] == '}' {
+				if end == open+1 {
+					return key, false
+				}
+				return key[open+1 : end], true
+			}
+		}
+		return key, false
+	}
+	return key, false
+}
+
+// KeySlot is the hash slot of key, honouring hash tags
+func KeySlot(key string) int {
+	tag, _ := HashTag(key)
+	return int(crc16([]byte(tag)) % SlotCount)
+}
+
+// crc16 is the CCITT/XMODEM variant Redis Cluster uses for key slots
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
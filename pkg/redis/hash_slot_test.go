@@ -0,0 +1,54 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+	cases := map[string]struct {
+		key            string
+		expectedTag    string
+		expectedTagged bool
+		expectedSlot   int
+	}{
+		"plain key": {
+			key:          "foo",
+			expectedTag:  "foo",
+			expectedSlot: 12182,
+		},
+		"tagged": {
+			key:            "{user1000}.following",
+			expectedTag:    "user1000",
+			expectedTagged: true,
+			expectedSlot:   KeySlot("user1000"),
+		},
+		"first tag only": {
+			key:            "foo{bar}{zap}",
+			expectedTag:    "bar",
+			expectedTagged: true,
+			expectedSlot:   KeySlot("bar"),
+		},
+		"empty tag hashes the whole key": {
+			key:          "foo{}{bar}",
+			expectedTag:  "foo{}{bar}",
+			expectedSlot: 8363,
+		},
+		"unclosed": {
+			key:          "foo{bar",
+			expectedTag:  "foo{bar",
+			expectedSlot: KeySlot("foo{bar"),
+		},
+		"empty key": {
+			key:          "",
+			expectedSlot: 0,
+		},
+	}
+
+	for caseName, c := range cases {
+		tag, tagged := HashTag(c.key)
+		assert.Equal(t, c.expectedTag, tag, caseName)
+		assert.Equal(t, c.expectedTagged, tagged, caseName)
+		assert.Equal(t, c.expectedSlot, KeySlot(c.key), caseName)
+	}
+}
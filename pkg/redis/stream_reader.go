@@ -40,6 +40,10 @@ func ComponentFromReader(reader io.Reader, buffer []byte) (component Componenter
 		for componentIndex := range array {
 			array[componentIndex], bytesRead, err = ComponentFromReader(reader, buffer)
 			bytesReadTotal += bytesRead
+			if err != nil {
+				// the rest of the array is still on the wire, or lost, so the stream cannot be read any further
+				return nil, bytesReadTotal, err
+			}
 		}
 		return NewArrayFromComponenterSlice(array), bytesReadTotal, nil
 	case ':': // integer
@@ -66,6 +70,9 @@ func ComponentFromReader(reader io.Reader, buffer []byte) (component Componenter
 		var theString string
 		theString, bytesRead, err = readFieldAsBulkString(reader, buffer, strLen)
 		bytesReadTotal += bytesRead
+		if err != nil {
+			return nil, bytesReadTotal, err
+		}
 		return NewBulkStringFromString(theString), bytesReadTotal, nil
 	case '+': // simple string
 		var theString string
@@ -125,7 +132,8 @@ func readFieldAsSimpleString(reader io.Reader, buffer []byte) (fieldValue string
 func readFieldAsBulkString(reader io.Reader, buffer []byte, stringLen int) (fieldValue string, bytesReadTotal int, err error) {
 	var bytesRead int
 	buffer = buffer[0:stringLen]
-	bytesRead, err = reader.Read(buffer)
+	// a bulk string may arrive over several reads
+	bytesRead, err = io.ReadFull(reader, buffer)
 	if err != nil {
 		return
 	}
@@ -138,7 +146,7 @@ func readFieldAsBulkString(reader io.Reader, buffer []byte, stringLen int) (fiel
 
 	// read OK, consume the \r\n
 	buffer = buffer[0:len(RecordSeparator)]
-	bytesRead, err = reader.Read(buffer)
+	bytesRead, err = io.ReadFull(reader, buffer)
 	if err != nil {
 		return
 	}
@@ -176,8 +184,7 @@ func ComponentToStream(writer io.Writer, componenter Componenter) (totalBytesWri
 		s := componentType.String()
 		totalBytesWritten, err = fmt.Fprintf(writer, "$%d%s%s%s", len(s), RecordSeparator, s, RecordSeparator)
 	case *SimpleString:
-		s := componentType.String()
-		totalBytesWritten, err = fmt.Fprintf(writer, "$%d%s%s%s", len(s), RecordSeparator, s, RecordSeparator)
+		totalBytesWritten, err = fmt.Fprintf(writer, "+%s%s", componentType.String(), RecordSeparator)
 	case *Null:
 		totalBytesWritten, err = fmt.Fprintf(writer, "*-1%s", RecordSeparator)
 	case *NullString:
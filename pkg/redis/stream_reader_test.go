@@ -29,3 +29,46 @@ func TestCommands(t *testing.T) {
 		assert.Equal(t, c.expected, actual, caseName)
 	}
 }
+
+func TestTruncatedReplies(t *testing.T) {
+	cases := map[string]string{
+		"array missing elements":     "*3\r\n$1\r\na\r\n$1\r\nb\r\n",
+		"array with a short element": "*2\r\n$1\r\na\r\n$5\r\nbc",
+		"nested array":               "*1\r\n*2\r\n:1\r\n",
+		"bulk string":                "$5\r\nab",
+	}
+
+	buffer := make([]byte, BufferSizeBytes)
+	for caseName, input := range cases {
+		actual, _, err := ComponentFromReader(bytes.NewBufferString(input), buffer)
+		assert.Error(t, err, caseName)
+		assert.Nil(t, actual, caseName)
+	}
+}
+
+func TestComponentToStream(t *testing.T) {
+	cases := map[string]struct {
+		input    Componenter
+		expected string
+	}{
+		"simple string": {
+			input:    NewSimpleStringFromString("OK"),
+			expected: "+OK\r\n",
+		},
+		"bulk string": {
+			input:    NewBulkStringFromString("OK"),
+			expected: "$2\r\nOK\r\n",
+		},
+		"error": {
+			input:    NewErrorFromString("ERR wrong"),
+			expected: "-ERR wrong\r\n",
+		},
+	}
+
+	for caseName, c := range cases {
+		actual := &bytes.Buffer{}
+		_, err := ComponentToStream(actual, c.input)
+		assert.NoError(t, err, caseName)
+		assert.Equal(t, c.expected, actual.String(), caseName)
+	}
+}